@@ -7,41 +7,57 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/hajimehoshi/ebiten"
 
-	"github.com/freewilll/apple2-go/audio"
 	"github.com/freewilll/apple2-go/cpu"
 	"github.com/freewilll/apple2-go/disk"
-	"github.com/freewilll/apple2-go/keyboard"
-	"github.com/freewilll/apple2-go/mmu"
+	"github.com/freewilll/apple2-go/machine"
 	"github.com/freewilll/apple2-go/system"
+	"github.com/freewilll/apple2-go/ui"
 	"github.com/freewilll/apple2-go/utils"
-	"github.com/freewilll/apple2-go/video"
 )
 
 var (
+	apple2   *machine.Machine // The emulated machine
+	keyboard *ui.Keyboard     // The ebiten keyboard feeding the machine's keyboard
+	joystick *ui.Joystick     // The ebiten gamepad, mouse and keypad feeding the machine's joystick
+
 	showInstructions    *bool   // Display all instructions as they are executed
 	disableFirmwareWait *bool   // Disable the WAIT function at $fca8
 	disableDosDelay     *bool   // Disable DOS delay functions
 	breakAddress        *uint16 // Break address from the command line
 	scale               float64 // Scale
+	stateFile           *string // Save state file used by the save and load hotkeys
 
 	resetKeysDown      bool // Keep track of ctrl-alt-R key down state
 	fpsKeysDown        bool // Keep track of ctrl-alt-F key down state
 	monochromeKeysDown bool // Keep track of ctrl-alt-M key down state
+	saveKeysDown       bool // Keep track of ctrl-alt-S key down state
+	loadKeysDown       bool // Keep track of ctrl-alt-L key down state
+	pasteKeysDown      bool // Keep track of ctrl-alt-V key down state
+	drive1KeysDown     bool // Keep track of ctrl-alt-1 key down state
+	drive2KeysDown     bool // Keep track of ctrl-alt-2 key down state
+	swapKeysDown       bool // Keep track of ctrl-alt-W key down state
 )
 
 // checkSpecialKeys checks
 // - ctrl-alt-R has been pressed. Releasing the R does a warm reset
 // - ctrl-alt-F has been pressed, toggling FPS display
+// - ctrl-alt-M has been pressed, toggling monochrome display
+// - ctrl-alt-S has been pressed. Releasing the S saves the machine state
+// - ctrl-alt-L has been pressed. Releasing the L loads the machine state
+// - ctrl-alt-V has been pressed. Releasing the V pastes the clipboard
+// - ctrl-alt-1 or 2 has been pressed. Releasing the 1 or 2 inserts the next disk image
+// - ctrl-alt-W has been pressed. Releasing the W swaps the disk images
 func checkSpecialKeys() {
 	// Check for ctrl-alt-R, and if released, do a warm CPU reset
 	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.KeyR) {
 		resetKeysDown = true
 	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyR) && resetKeysDown {
 		resetKeysDown = false
-		cpu.Reset()
+		apple2.CPU.Reset()
 	} else {
 		resetKeysDown = false
 	}
@@ -51,7 +67,7 @@ func checkSpecialKeys() {
 		fpsKeysDown = true
 	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyF) && fpsKeysDown {
 		fpsKeysDown = false
-		video.ShowFPS = !video.ShowFPS
+		apple2.Video.ShowFPS = !apple2.Video.ShowFPS
 	} else {
 		fpsKeysDown = false
 	}
@@ -61,42 +77,123 @@ func checkSpecialKeys() {
 		monochromeKeysDown = true
 	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyM) && monochromeKeysDown {
 		monochromeKeysDown = false
-		video.Monochrome = !video.Monochrome
+		apple2.Video.Monochrome = !apple2.Video.Monochrome
 	} else {
 		monochromeKeysDown = false
 	}
+
+	// Check for ctrl-alt-S and save the machine state
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.KeyS) {
+		saveKeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyS) && saveKeysDown {
+		saveKeysDown = false
+		if err := apple2.SaveState(*stateFile); err != nil {
+			fmt.Printf("Unable to save state: %s\n", err)
+		} else {
+			fmt.Printf("Saved state to %s\n", *stateFile)
+		}
+	} else {
+		saveKeysDown = false
+	}
+
+	// Check for ctrl-alt-L and load the machine state
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.KeyL) {
+		loadKeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyL) && loadKeysDown {
+		loadKeysDown = false
+		if err := apple2.LoadState(*stateFile); err != nil {
+			fmt.Printf("Unable to load state: %s\n", err)
+		} else {
+			fmt.Printf("Loaded state from %s\n", *stateFile)
+		}
+	} else {
+		loadKeysDown = false
+	}
+
+	// Check for ctrl-alt-V and type the contents of the clipboard
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.KeyV) {
+		pasteKeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyV) && pasteKeysDown {
+		pasteKeysDown = false
+		text, err := ui.ReadClipboard()
+		if err != nil {
+			fmt.Printf("Unable to read the clipboard: %s\n", err)
+		} else {
+			keyboard.Paste(text)
+		}
+	} else {
+		pasteKeysDown = false
+	}
+
+	// Check for ctrl-alt-1 and insert the next disk image into drive 1
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.Key1) {
+		drive1KeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.Key1) && drive1KeysDown {
+		drive1KeysDown = false
+		insertNextDiskImage(1)
+	} else {
+		drive1KeysDown = false
+	}
+
+	// Check for ctrl-alt-2 and insert the next disk image into drive 2
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.Key2) {
+		drive2KeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.Key2) && drive2KeysDown {
+		drive2KeysDown = false
+		insertNextDiskImage(2)
+	} else {
+		drive2KeysDown = false
+	}
+
+	// Check for ctrl-alt-W and swap the disk images
+	if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && ebiten.IsKeyPressed(ebiten.KeyW) {
+		swapKeysDown = true
+	} else if ebiten.IsKeyPressed(ebiten.KeyControl) && ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyW) && swapKeysDown {
+		swapKeysDown = false
+		swapDiskImages()
+	} else {
+		swapKeysDown = false
+	}
 }
 
 // update is the main ebiten loop
 func update(screen *ebiten.Image) error {
 
 	checkSpecialKeys() // Poll the keyboard and check for R and F keys
+	runDiskCommands()  // Insert, eject and swap disk images
 
-	if !(fpsKeysDown || monochromeKeysDown) {
+	if !(fpsKeysDown || monochromeKeysDown || saveKeysDown || loadKeysDown || pasteKeysDown || drive1KeysDown || drive2KeysDown || swapKeysDown) {
 		keyboard.Poll() // Convert ebiten's keyboard state to an interal value
 	}
 
-	system.FrameCycles = 0     // Reset cycles processed this frame
-	system.LastAudioCycles = 0 // Reset processed audio cycles
-	exitAtBreak := true        // Die if a BRK instruction is seen
+	joystick.Poll() // Convert ebiten's gamepad, mouse and keypad state to paddle values and buttons
+
+	apple2.System.FrameCycles = 0     // Reset cycles processed this frame
+	apple2.System.LastAudioCycles = 0 // Reset processed audio cycles
+	exitAtBreak := true               // Die if a BRK instruction is seen
 
 	// Run for 1/60 of a second, the duration of an ebiten frame
-	cpu.Run(*showInstructions, breakAddress, exitAtBreak, *disableFirmwareWait, *disableDosDelay, system.CPUFrequency/60)
+	apple2.CPU.Run(*showInstructions, breakAddress, exitAtBreak, *disableFirmwareWait, *disableDosDelay, system.CPUFrequency/60)
 
 	// Process any audio speaker clicks from this frame
-	audio.ForwardToFrameCycle()
+	apple2.Audio.ForwardToFrameCycle()
 
 	// Updated the cycle accounting
-	system.Cycles += system.FrameCycles
+	apple2.System.Cycles += apple2.System.FrameCycles
+
+	// Save any writes to the disk images if it's time for an autosave
+	if err := apple2.Autosave(); err != nil {
+		fmt.Printf("Unable to save disk image: %s\n", err)
+	}
 
 	// Finally render the screen
-	return video.DrawScreen(screen)
+	return ui.DrawScreen(apple2.Video, screen)
 }
 
 func main() {
 	var Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", os.Args[0])
-		fmt.Fprintf(flag.CommandLine.Output(), "Synopsis: %s [disk image file]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Synopsis: %s [disk image file] [disk image file]...\n\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "Options\n")
 		flag.PrintDefaults()
 	}
@@ -109,37 +206,90 @@ func main() {
 	mute := flag.Bool("mute", false, "Mute sound")
 	scale := flag.Float64("scale", 2, "Video scale")
 	clickWhenDriveHeadMoves := flag.Bool("drive-head-click", false, "Click speaker when drive head moves")
+	cpu65C02 := flag.Bool("65c02", false, "Emulate a 65C02 CPU as used in the enhanced Apple //e")
+	stateFile = flag.String("state-file", "apple2-go.state", "Save state file for ctrl-alt-S and ctrl-alt-L")
+	loadState := flag.Bool("load-state", false, "Resume from the save state file at startup")
+	joystickMouse := flag.Bool("joystick-mouse", false, "Use the mouse as joystick instead of the gamepad")
+	typeText := flag.String("type", "", "Type text after startup, use \\n for return")
+	writeProtect := flag.String("write-protect", "", "Write protect the disks in these drives, e.g. 1 or 1,2")
+	diskWrites := flag.String("disk-writes", "image", "Where writes to disk images go: image, overlay for a copy next to the image, or memory")
+	autosave := flag.Int("autosave", 0, "Save writes to disk images every this many seconds, 0 to only save on eject and exit")
+	hardDisk1 := flag.String("hard-disk1", "", "Hard disk image for drive 1 of the hard disk card in slot 7, a .po, .hdv or .2mg file")
+	hardDisk2 := flag.String("hard-disk2", "", "Hard disk image for drive 2 of the hard disk card in slot 7")
+	diskROM := flag.String("disk-rom", "", "Replace the slot 6 boot ROM with a 256 byte ROM file, e.g. the 13 sector P5A ROM to boot DOS 3.2 disks")
 	flag.Parse()
 
 	breakAddress = utils.DecodeCmdLineAddress(breakAddressString)
 
-	cpu.InitInstructionDecoder() // Init the instruction decoder data structures
-	mmu.InitRAM()                // Set all switches to bootup values and initialize the page tables
-	mmu.InitApple2eROM()         // Load the ROM and init page tables
-	mmu.InitIO()                 // Init slots, video and disk image statuses
+	cpuModel := cpu.Model6502
+	if *cpu65C02 {
+		cpuModel = cpu.Model65C02
+	}
+
+	apple2 = machine.New(cpuModel)
+
+	if *diskROM != "" {
+		if err := apple2.MMU.LoadDiskROM(*diskROM); err != nil {
+			panic(fmt.Sprintf("Unable to read disk ROM: %s", err))
+		}
+	}
+
+	for _, drive := range utils.DecodeCmdLineDrives(*writeProtect) {
+		apple2.Disk.SetWriteProtected(drive, true)
+	}
 
-	// If there is a disk image on the command line, load it
-	diskImages := flag.Args()
-	if len(diskImages) > 0 {
-		disk.ReadDiskImage(diskImages[0])
+	writeMode, err := disk.ParseWriteMode(*diskWrites)
+	if err != nil {
+		panic(err)
 	}
+	apple2.SetDiskWriteMode(writeMode)
+	apple2.SetAutosaveInterval(time.Duration(*autosave) * time.Second)
 
-	cpu.Init()         // Init the CPU registers, interrupts and disable testing code
-	keyboard.Init()    // Init the keyboard state and ebiten translation tables
-	video.Init()       // Init the video data structures used for rendering
-	audio.InitEbiten() // Initialize the audio sets up the ebiten output stream
+	// Load the first two disk images on the command line into drives 1 and 2
+	diskImages = flag.Args()
+	for i := 0; i < len(diskImages) && i < 2; i++ {
+		if err := apple2.Disk.InsertDiskImage(i+1, diskImages[i]); err != nil {
+			panic(fmt.Sprintf("Unable to read disk image: %s", err))
+		}
+		nextDiskImage[i] = (i + 1) % len(diskImages)
+	}
 
-	audio.Mute = *mute
-	audio.ClickWhenDriveHeadMoves = *clickWhenDriveHeadMoves
+	for i, path := range []string{*hardDisk1, *hardDisk2} {
+		if path == "" {
+			continue
+		}
+		if err := apple2.HardDisk.InsertImage(i+1, path); err != nil {
+			panic(fmt.Sprintf("Unable to read hard disk image: %s", err))
+		}
+	}
+
+	// Resume from a save state, this replaces any disk image loaded above
+	if *loadState {
+		if err := apple2.LoadState(*stateFile); err != nil {
+			panic(fmt.Sprintf("Unable to load state: %s", err))
+		}
+	}
 
-	system.Init()           // Initialize the system-wide state
-	cpu.SetColdStartReset() // Prepare memory to ensure a cold reset
-	cpu.Reset()             // Set the CPU and memory states so that a next call to cpu.Run() calls the firmware reset code
+	keyboard = ui.NewKeyboard(apple2.Keyboard)
+	apple2.Keyboard.Type(utils.DecodeCmdLineText(*typeText))
+
+	joystick = ui.NewJoystick(apple2.Joystick)
+	joystick.UseMouse = *joystickMouse
+
+	ui.InitAudio(apple2.Audio) // Initialize the audio sets up the ebiten output stream
+	apple2.Audio.Mute = *mute
+	apple2.Audio.ClickWhenDriveHeadMoves = *clickWhenDriveHeadMoves
+
+	startDiskCommands()
+	fmt.Println(diskCommandsHelp)
 
 	// Start the ebiten main loop
 	ebiten.SetRunnableInBackground(true)
 	ebiten.Run(update, 560, 384, *scale, "Apple //e")
 
-	// The main loop has ended, flush any data to the disk image if any writes have been done.
-	disk.FlushImage()
+	// The main loop has ended, flush any data to the disk images if any writes have been done.
+	if err := apple2.FlushImages(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write disk image: %s\n", err)
+		os.Exit(1)
+	}
 }
@@ -7,20 +7,36 @@ package audio
 
 import "github.com/freewilll/apple2-go/system"
 
+// Audio has the audio state of a machine
+type Audio struct {
+	System *system.System
+
+	// Mute ensures no samples are output
+	Mute bool
+
+	// ClickWhenDriveHeadMoves makes the speaker click once every time the stepper motor magnets change
+	ClickWhenDriveHeadMoves bool
+}
+
+// New creates the audio state
+func New(s *system.System) *Audio {
+	return &Audio{System: s}
+}
+
 // Click handles a speaker click
-func Click() {
-	ForwardToFrameCycle()
-	system.AudioAttenuationCounter = 400
-	system.LastAudioValue = ^system.LastAudioValue
+func (a *Audio) Click() {
+	a.ForwardToFrameCycle()
+	a.System.AudioAttenuationCounter = 400
+	a.System.LastAudioValue = ^a.System.LastAudioValue
 }
 
 // attenuate makes sure the audio goes down to zero after a period of inactivity
-func attenuate(sample int16) int16 {
-	if system.AudioAttenuationCounter == 0 {
+func (a *Audio) attenuate(sample int16) int16 {
+	if a.System.AudioAttenuationCounter == 0 {
 		return 0
 	}
 
-	system.AudioAttenuationCounter--
+	a.System.AudioAttenuationCounter--
 	return sample
 
 }
@@ -28,19 +44,19 @@ func attenuate(sample int16) int16 {
 // ForwardToFrameCycle calculates how many audio samples need to be written to
 // the channel based on how many CPU cycles have been executed since the last
 // flush and shove them into the channel.
-func ForwardToFrameCycle() {
+func (a *Audio) ForwardToFrameCycle() {
 	// 1023000/44100=23.19 cycles per audio sample
 	cyclesPerAudioSample := system.CPUFrequency / float64(system.AudioSampleRate)
 
 	// Should be about 1023000/60=17050
-	elapsedCycles := system.FrameCycles - system.LastAudioCycles
+	elapsedCycles := a.System.FrameCycles - a.System.LastAudioCycles
 
 	// Should be about 17050/23.19=735 audio samples per frame
 	audioSamples := uint64(float64(elapsedCycles) / cyclesPerAudioSample)
 
 	for i := uint64(0); i < audioSamples; i++ {
-		b := attenuate(system.LastAudioValue)
-		system.AudioChannel <- b
+		b := a.attenuate(a.System.LastAudioValue)
+		a.System.AudioChannel <- b
 	}
-	system.LastAudioCycles = system.FrameCycles
+	a.System.LastAudioCycles = a.System.FrameCycles
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAuxMemory tests the RAMRD, RAMWRT, ALTZP and 80STORE soft switches and
+// their status reads.
+func TestAuxMemory(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	m.MMU.WipeRAM()
+
+	// Main memory is used at startup
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc013)) // RDRAMRD
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc014)) // RDRAMWR
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc016)) // RDAUXZP
+	m.MMU.WriteMemory(0x2000, 0x01)
+	assert.Equal(t, uint8(0x01), m.MMU.PhysicalMemory.MainMemory[0x2000])
+	assert.Equal(t, uint8(0x00), m.MMU.PhysicalMemory.AuxMemory[0x2000])
+
+	// Write to aux memory while still reading from main memory
+	m.MMU.WriteMemory(0xc005, 0x00) // SETAUXWR
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc014))
+	m.MMU.WriteMemory(0x2000, 0x02)
+	assert.Equal(t, uint8(0x01), m.MMU.PhysicalMemory.MainMemory[0x2000])
+	assert.Equal(t, uint8(0x02), m.MMU.PhysicalMemory.AuxMemory[0x2000])
+	assert.Equal(t, uint8(0x01), m.MMU.ReadMemory(0x2000))
+
+	// Read from aux memory
+	m.MMU.WriteMemory(0xc003, 0x00) // SETAUXRD
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc013))
+	assert.Equal(t, uint8(0x02), m.MMU.ReadMemory(0x2000))
+
+	// Reads of the write-only switches don't change the banks
+	m.MMU.ReadMemory(0xc002) // CLRAUXRD
+	m.MMU.ReadMemory(0xc004) // CLRAUXWR
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc013))
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc014))
+
+	// The zero page isn't affected by RAMRD and RAMWRT
+	m.MMU.WriteMemory(0x0080, 0x03)
+	assert.Equal(t, uint8(0x03), m.MMU.PhysicalMemory.MainMemory[0x0080])
+	assert.Equal(t, uint8(0x00), m.MMU.PhysicalMemory.AuxMemory[0x0080])
+
+	// Back to main memory
+	m.MMU.WriteMemory(0xc002, 0x00) // CLRAUXRD
+	m.MMU.WriteMemory(0xc004, 0x00) // CLRAUXWR
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc013))
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc014))
+	assert.Equal(t, uint8(0x01), m.MMU.ReadMemory(0x2000))
+
+	// Alternate zero page, stack and language card
+	m.MMU.WriteMemory(0xc009, 0x00) // SETAUXZP
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc016))
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0x0080))
+	m.MMU.WriteMemory(0x0080, 0x04)
+	m.MMU.WriteMemory(0x01ff, 0x05)
+	assert.Equal(t, uint8(0x03), m.MMU.PhysicalMemory.MainMemory[0x0080])
+	assert.Equal(t, uint8(0x04), m.MMU.PhysicalMemory.AuxMemory[0x0080])
+	assert.Equal(t, uint8(0x05), m.MMU.PhysicalMemory.AuxMemory[0x01ff])
+
+	m.MMU.ReadMemory(0xc08b) // Read and write RAM bank 1
+	m.MMU.ReadMemory(0xc08b)
+	m.MMU.WriteMemory(0xd000, 0x06)
+	m.MMU.WriteMemory(0xe000, 0x07)
+	assert.Equal(t, uint8(0x06), m.MMU.PhysicalMemory.AuxMemory[0xc000])
+	assert.Equal(t, uint8(0x07), m.MMU.PhysicalMemory.AuxMemory[0xe000])
+	assert.Equal(t, uint8(0x00), m.MMU.PhysicalMemory.MainMemory[0xc000])
+	assert.Equal(t, uint8(0x06), m.MMU.ReadMemory(0xd000))
+
+	m.MMU.WriteMemory(0xc008, 0x00) // CLRAUXZP
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc016))
+	assert.Equal(t, uint8(0x03), m.MMU.ReadMemory(0x0080))
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0xd000))
+
+	// 80STORE with PAGE2 switches the text page to aux memory
+	m.MMU.WriteMemory(0xc001, 0x00) // SET80COL
+	m.MMU.ReadMemory(0xc055)        // TXTPAGE2
+	m.MMU.WriteMemory(0x0400, 0x08)
+	m.MMU.WriteMemory(0x2000, 0x09)
+	assert.Equal(t, uint8(0x08), m.MMU.PhysicalMemory.AuxMemory[0x0400])
+	assert.Equal(t, uint8(0x09), m.MMU.PhysicalMemory.MainMemory[0x2000])
+
+	// In hires mode, the hires page is switched too
+	m.MMU.ReadMemory(0xc057) // SETHIRES
+	m.MMU.WriteMemory(0x2000, 0x0a)
+	assert.Equal(t, uint8(0x0a), m.MMU.PhysicalMemory.AuxMemory[0x2000])
+	assert.Equal(t, uint8(0x09), m.MMU.PhysicalMemory.MainMemory[0x2000])
+
+	m.MMU.ReadMemory(0xc054) // TXTPAGE1
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0x0400))
+	assert.Equal(t, uint8(0x09), m.MMU.ReadMemory(0x2000))
+
+	m.MMU.ReadMemory(0xc056)        // CLRHIRES
+	m.MMU.WriteMemory(0xc000, 0x00) // CLR80COL
+}
@@ -0,0 +1,235 @@
+package main
+
+// Headless emulator executable. This runs a machine without a window or audio
+// device and dumps the text screen, memory or a PNG of the screen at the end.
+// It is useful for scripted tests of disk images.
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/freewilll/apple2-go/system"
+	"github.com/freewilll/apple2-go/utils"
+)
+
+// memoryRange is an inclusive range of addresses to dump
+type memoryRange struct {
+	start uint16
+	end   uint16
+}
+
+// parseMemoryRanges parses a comma separated list of hex ranges such as
+// 0800-08ff,2000. A single address dumps the $100 bytes starting at it.
+func parseMemoryRanges(s string) []memoryRange {
+	var ranges []memoryRange
+
+	if s == "" {
+		return ranges
+	}
+
+	for _, r := range strings.Split(s, ",") {
+		parts := strings.SplitN(r, "-", 2)
+		start := utils.DecodeCmdLineAddress(&parts[0])
+		if start == nil {
+			panic(fmt.Sprintf("Invalid memory range %q", r))
+		}
+
+		end := *start + 0xff
+		if end < *start {
+			end = 0xffff
+		}
+		if len(parts) == 2 {
+			e := utils.DecodeCmdLineAddress(&parts[1])
+			if e == nil || *e < *start {
+				panic(fmt.Sprintf("Invalid memory range %q", r))
+			}
+			end = *e
+		}
+
+		ranges = append(ranges, memoryRange{*start, end})
+	}
+
+	return ranges
+}
+
+// run runs the machine one frame at a time until either cycles cycles have
+// been executed or the PC reaches breakAddress. It returns true if the
+// breakAddress was reached.
+func run(m *machine.Machine, breakAddress *uint16, cycles uint64, disableFirmwareWait bool, disableDosDelay bool) bool {
+	for cycles == 0 || m.System.Cycles < cycles {
+		m.System.FrameCycles = 0     // Reset cycles processed this frame
+		m.System.LastAudioCycles = 0 // Reset processed audio cycles
+
+		// Run for 1/60 of a second, or less if that would go past the wanted cycles
+		frameCycles := uint64(system.CPUFrequency / 60)
+		if cycles != 0 && cycles-m.System.Cycles < frameCycles {
+			frameCycles = cycles - m.System.Cycles
+		}
+		m.CPU.Run(false, breakAddress, false, disableFirmwareWait, disableDosDelay, frameCycles)
+
+		// Process any audio speaker clicks from this frame and throw them away
+		m.Audio.ForwardToFrameCycle()
+		for len(m.System.AudioChannel) > 0 {
+			<-m.System.AudioChannel
+		}
+
+		// Updated the cycle accounting
+		m.System.Cycles += m.System.FrameCycles
+
+		// Render the screen to keep the flashing characters in step
+		m.Video.Render()
+
+		// Save any writes to the disk images if it's time for an autosave
+		if err := m.Autosave(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to save disk image: %s\n", err)
+		}
+
+		if breakAddress != nil && m.CPU.State.PC == *breakAddress {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writePNG writes the last rendered frame to a PNG file
+func writePNG(m *machine.Machine, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, m.Video.Frame()); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func main() {
+	var Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Synopsis:\n    %s [-cycles N] [-break ADDRESS] [disk image file] [disk image file]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Example:\n    %s -cycles 20000000 -type 'CATALOG\\n' -text dos33.dsk\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Usage = Usage
+
+	cycles := flag.Uint64("cycles", 0, "Stop after running this many CPU cycles")
+	breakAddressString := flag.String("break", "", "Stop when the PC reaches this address")
+	disableFirmwareWait := flag.Bool("disable-wait", false, "Ignore JSRs to firmware wait at $FCA8")
+	disableDosDelay := flag.Bool("disable-dos-delay", false, "Ignore DOS ARM move and motor on waits")
+	cpu65C02 := flag.Bool("65c02", false, "Emulate a 65C02 CPU as used in the enhanced Apple //e")
+	loadState := flag.String("load-state", "", "Resume from a save state file")
+	typeText := flag.String("type", "", "Type text after startup, use \\n for return")
+	writeProtect := flag.String("write-protect", "", "Write protect the disks in these drives, e.g. 1 or 1,2")
+	diskWrites := flag.String("disk-writes", "image", "Where writes to disk images go: image, overlay for a copy next to the image, or memory")
+	autosave := flag.Int("autosave", 0, "Save writes to disk images every this many seconds, 0 to only save at the end")
+	hardDisk1 := flag.String("hard-disk1", "", "Hard disk image for drive 1 of the hard disk card in slot 7, a .po, .hdv or .2mg file")
+	hardDisk2 := flag.String("hard-disk2", "", "Hard disk image for drive 2 of the hard disk card in slot 7")
+	diskROM := flag.String("disk-rom", "", "Replace the slot 6 boot ROM with a 256 byte ROM file, e.g. the 13 sector P5A ROM to boot DOS 3.2 disks")
+	text := flag.Bool("text", false, "Print the text screen when done")
+	dump := flag.String("dump", "", "Dump memory ranges when done, e.g. 0800-08ff,2000-20ff")
+	pngFile := flag.String("png", "", "Write a PNG of the screen to a file when done")
+	flag.Parse()
+
+	breakAddress := utils.DecodeCmdLineAddress(breakAddressString)
+	if *cycles == 0 && breakAddress == nil {
+		fmt.Fprintf(os.Stderr, "Either -cycles or -break is required\n")
+		os.Exit(2)
+	}
+
+	memoryRanges := parseMemoryRanges(*dump)
+
+	cpuModel := cpu.Model6502
+	if *cpu65C02 {
+		cpuModel = cpu.Model65C02
+	}
+
+	m := machine.New(cpuModel)
+
+	if *diskROM != "" {
+		if err := m.MMU.LoadDiskROM(*diskROM); err != nil {
+			panic(fmt.Sprintf("Unable to read disk ROM: %s", err))
+		}
+	}
+
+	for _, drive := range utils.DecodeCmdLineDrives(*writeProtect) {
+		m.Disk.SetWriteProtected(drive, true)
+	}
+
+	writeMode, err := disk.ParseWriteMode(*diskWrites)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(2)
+	}
+	m.SetDiskWriteMode(writeMode)
+	m.SetAutosaveInterval(time.Duration(*autosave) * time.Second)
+
+	// Load the disk images on the command line into drives 1 and 2
+	diskImages := flag.Args()
+	if len(diskImages) > 2 {
+		fmt.Fprintf(os.Stderr, "There are only two drives\n")
+		os.Exit(2)
+	}
+	for i, path := range diskImages {
+		if err := m.Disk.InsertDiskImage(i+1, path); err != nil {
+			panic(fmt.Sprintf("Unable to read disk image: %s", err))
+		}
+	}
+
+	for i, path := range []string{*hardDisk1, *hardDisk2} {
+		if path == "" {
+			continue
+		}
+		if err := m.HardDisk.InsertImage(i+1, path); err != nil {
+			panic(fmt.Sprintf("Unable to read hard disk image: %s", err))
+		}
+	}
+
+	// Resume from a save state, this replaces any disk image loaded above
+	if *loadState != "" {
+		if err := m.LoadState(*loadState); err != nil {
+			panic(fmt.Sprintf("Unable to load state: %s", err))
+		}
+	}
+
+	m.Keyboard.Type(utils.DecodeCmdLineText(*typeText))
+
+	reachedBreak := run(m, breakAddress, *cycles, *disableFirmwareWait, *disableDosDelay)
+
+	if *text {
+		for _, line := range m.Video.Text() {
+			fmt.Println(line)
+		}
+	}
+
+	for _, r := range memoryRanges {
+		m.CPU.DumpMemoryRange(r.start, r.end)
+	}
+
+	if *pngFile != "" {
+		if err := writePNG(m, *pngFile); err != nil {
+			panic(fmt.Sprintf("Unable to write PNG: %s", err))
+		}
+	}
+
+	// Flush any data to the disk image if any writes have been done
+	if err := m.FlushImages(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write disk image: %s\n", err)
+		os.Exit(1)
+	}
+
+	if breakAddress != nil && !reachedBreak {
+		fmt.Fprintf(os.Stderr, "Did not reach break address $%04x in %d cycles\n", *breakAddress, m.System.Cycles)
+		os.Exit(1)
+	}
+}
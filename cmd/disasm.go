@@ -8,7 +8,7 @@ import (
 	"os"
 
 	"github.com/freewilll/apple2-go/cpu"
-	"github.com/freewilll/apple2-go/mmu"
+	"github.com/freewilll/apple2-go/machine"
 	"github.com/freewilll/apple2-go/utils"
 )
 
@@ -23,6 +23,7 @@ func main() {
 
 	startString := flag.String("start", "", "Start address")
 	endString := flag.String("end", "", "End address")
+	cpu65C02 := flag.Bool("65c02", false, "Disassemble 65C02 instructions")
 	flag.Parse()
 
 	start := utils.DecodeCmdLineAddress(startString)
@@ -37,7 +38,11 @@ func main() {
 		end = &e
 	}
 
-	cpu.InitInstructionDecoder()
-	mmu.InitApple2eROM()
-	utils.Disassemble(*start, *end)
+	cpuModel := cpu.Model6502
+	if *cpu65C02 {
+		cpuModel = cpu.Model65C02
+	}
+
+	m := machine.New(cpuModel)
+	utils.Disassemble(m.CPU, *start, *end)
 }
@@ -0,0 +1,230 @@
+package main
+
+// Command line tool to look inside DOS 3.3 disk images. It lists the catalog,
+// extracts files and adds or deletes them, e.g. to put freshly assembled
+// binaries on a disk before booting it.
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/dos33"
+)
+
+const synopsis = `    %[1]s catalog IMAGE
+    %[1]s extract [-raw] IMAGE NAME [OUTPUT]
+    %[1]s add [-type T|I|A|B|S|R] [-address ADDRESS] [-raw] IMAGE FILE [NAME]
+    %[1]s delete IMAGE NAME
+`
+
+// usage prints how to use the tool and exits
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Synopsis:\n"+synopsis+"\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Example:\n    %s add -type B -address 0803 game.dsk game.bin GAME\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "extract writes to stdout if there is no OUTPUT. Without -raw, the load\n")
+	fmt.Fprintf(os.Stderr, "address and length of binary files and the length of BASIC programs are\n")
+	fmt.Fprintf(os.Stderr, "taken off and text files are converted to plain text, add does the opposite.\n")
+	os.Exit(2)
+}
+
+// fail prints an error and exits
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+// parseAddress parses a hex load address with an optional $
+func parseAddress(s string) uint16 {
+	value, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		fail("Invalid address %q, expected a hex value such as 0803", s)
+	}
+	return uint16(value)
+}
+
+// readDisk reads a DOS 3.3 disk image
+func readDisk(path string) *dos33.Disk {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("Unable to read disk image: %s", err)
+	}
+
+	d, err := dos33.New(bytes)
+	if err != nil {
+		fail("Unable to read disk image %s: %s", path, err)
+	}
+
+	return d
+}
+
+// writeDisk writes a DOS 3.3 disk image back to its file
+func writeDisk(path string, d *dos33.Disk) {
+	if err := disk.WriteFileAtomically(path, d.Bytes); err != nil {
+		fail("Unable to write disk image: %s", err)
+	}
+}
+
+// catalog prints the catalog like the DOS CATALOG command
+func catalog(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+
+	d := readDisk(args[0])
+	files, err := d.Catalog()
+	if err != nil {
+		fail("Unable to read catalog: %s", err)
+	}
+
+	fmt.Printf("DISK VOLUME %d\n\n", d.Volume())
+	for _, f := range files {
+		locked := " "
+		if f.Locked {
+			locked = "*"
+		}
+		fmt.Printf("%s%s %03d %s\n", locked, f.Type, f.Sectors%1000, f.Name)
+	}
+	fmt.Printf("\nFREE SECTORS %d\n", d.FreeSectors())
+}
+
+// extract writes a file's contents to a file or stdout
+func extract(args []string) {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	flags.Usage = usage
+	raw := flags.Bool("raw", false, "Write the contents of the data sectors as they are")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 && flags.NArg() != 3 {
+		usage()
+	}
+
+	d := readDisk(flags.Arg(0))
+	name := flags.Arg(1)
+	fileType, contents, err := d.ReadFile(name)
+	if err != nil {
+		fail("Unable to read %s: %s", name, err)
+	}
+
+	data := contents
+	if !*raw {
+		switch fileType {
+		case dos33.Binary:
+			var address uint16
+			if address, data, err = dos33.DecodeBinary(contents); err == nil {
+				fmt.Fprintf(os.Stderr, "%s loads at $%04X with length $%04X\n", name, address, len(data))
+			}
+		case dos33.Integer, dos33.Applesoft:
+			data, err = dos33.DecodeProgram(contents)
+		case dos33.Text:
+			data = dos33.DecodeText(contents)
+		}
+	}
+	if err != nil {
+		fail("Unable to read %s: %s", name, err)
+	}
+
+	if flags.NArg() == 2 || flags.Arg(2) == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err := ioutil.WriteFile(flags.Arg(2), data, 0644); err != nil {
+		fail("Unable to write %s: %s", flags.Arg(2), err)
+	}
+}
+
+// add writes a file from the host to the disk image, replacing any file with
+// the same name
+func add(args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	flags.Usage = usage
+	typeLetter := flags.String("type", "B", "DOS file type: T, I, A, B, S or R")
+	addressString := flags.String("address", "", "Load address of a binary file")
+	raw := flags.Bool("raw", false, "Write the file to the data sectors as it is")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 && flags.NArg() != 3 {
+		usage()
+	}
+
+	fileType, err := dos33.ParseFileType(*typeLetter)
+	if err != nil {
+		fail("%s", err)
+	}
+
+	path := flags.Arg(1)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("Unable to read %s: %s", path, err)
+	}
+
+	// The name defaults to the file's name without its extension
+	name := flags.Arg(2)
+	if name == "" {
+		name = strings.ToUpper(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+
+	contents := data
+	if !*raw {
+		switch fileType {
+		case dos33.Binary:
+			if *addressString == "" {
+				fail("Binary files need a load -address")
+			}
+			contents, err = dos33.EncodeBinary(parseAddress(*addressString), data)
+			if err != nil {
+				fail("Unable to add %s: %s", path, err)
+			}
+		case dos33.Integer, dos33.Applesoft:
+			contents = dos33.EncodeProgram(data)
+		case dos33.Text:
+			contents = dos33.EncodeText(data)
+		}
+	}
+
+	d := readDisk(flags.Arg(0))
+	if err := d.WriteFile(name, fileType, contents); err != nil {
+		fail("Unable to add %s: %s", name, err)
+	}
+	writeDisk(flags.Arg(0), d)
+}
+
+// remove deletes a file from the disk image
+func remove(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+
+	d := readDisk(args[0])
+	if err := d.DeleteFile(args[1]); err != nil {
+		fail("Unable to delete %s: %s", args[1], err)
+	}
+	writeDisk(args[0], d)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "catalog":
+		catalog(args)
+	case "extract":
+		extract(args)
+	case "add":
+		add(args)
+	case "delete":
+		remove(args)
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+// Command line tool to create blank disk images: unformatted, formatted for
+// DOS 3.3 or formatted for ProDOS
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/dos33"
+	"github.com/freewilll/apple2-go/prodos"
+)
+
+const prodosBlockLength = 512
+
+// fail prints an error and exits
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+// isProDOSOrder returns true if an image file with path has its sectors in
+// ProDOS order
+func isProDOSOrder(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".po", ".hdv":
+		return true
+	default:
+		return false
+	}
+}
+
+// readSystem reads the image a DOS or ProDOS boot loader is copied from and
+// returns it in DOS 3.3 order for DOS and in ProDOS order for ProDOS
+func readSystem(path string, dos bool) []byte {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("Unable to read %s: %s", path, err)
+	}
+
+	if dos {
+		if len(bytes) == disk.ImageLength && isProDOSOrder(path) {
+			bytes = disk.DOSOrderImage(bytes)
+		}
+		return bytes
+	}
+
+	blocks, _ := disk.ProDOSOrderBlocks(path, bytes)
+	return blocks
+}
+
+func main() {
+	var Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Synopsis:\n    %s [-format raw|dos33|prodos] [options] IMAGE\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Example:\n    %s -format dos33 -dos dos33_master.dsk -volume 10 work.dsk\n    %s -format prodos -name WORK work.po\n\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Usage = Usage
+
+	format := flag.String("format", "dos33", "Format of the image: raw for an unformatted disk, dos33 or prodos")
+	volume := flag.Int("volume", 254, "DOS 3.3 volume number")
+	dosMaster := flag.String("dos", "", "Copy DOS from tracks 0-2 of this DOS 3.3 image, so the disk boots")
+	name := flag.String("name", "BLANK", "ProDOS volume name")
+	blocks := flag.Int("blocks", disk.ImageLength/prodosBlockLength, "Number of blocks of a ProDOS volume, more than 280 for a hard disk image")
+	boot := flag.String("boot", "", "Copy the ProDOS boot loader from blocks 0 and 1 of this image")
+	force := flag.Bool("force", false, "Overwrite an existing image")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	var image []byte
+	var err error
+	switch *format {
+	case "raw":
+		image = make([]byte, disk.ImageLength)
+
+	case "dos33":
+		if *volume < 1 || *volume > 254 {
+			fail("Invalid volume number %d, expected 1 to 254", *volume)
+		}
+
+		var master []byte
+		if *dosMaster != "" {
+			master = readSystem(*dosMaster, true)
+		}
+		if image, err = dos33.Format(uint8(*volume), master); err != nil {
+			fail("Unable to format image: %s", err)
+		}
+		if isProDOSOrder(path) {
+			image = disk.ProDOSOrderImage(image)
+		}
+
+	case "prodos":
+		var loader []byte
+		if *boot != "" {
+			loader = readSystem(*boot, false)
+		}
+		if image, err = prodos.Format(*name, *blocks, loader); err != nil {
+			fail("Unable to format image: %s", err)
+		}
+		if len(image) == disk.ImageLength && !isProDOSOrder(path) {
+			image = disk.DOSOrderImage(image)
+		}
+
+	default:
+		fail("Invalid format %q, expected raw, dos33 or prodos", *format)
+	}
+
+	// An existing image is replaced by renaming a new file over it, so it's
+	// never left half written
+	if *force {
+		if err := disk.WriteFileAtomically(path, image); err != nil {
+			fail("Unable to write image: %s", err)
+		}
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		fail("Unable to create image: %s", err)
+	}
+	if _, err := f.Write(image); err != nil {
+		f.Close()
+		fail("Unable to write image: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		fail("Unable to write image: %s", err)
+	}
+}
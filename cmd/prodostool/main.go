@@ -0,0 +1,223 @@
+package main
+
+// Command line tool to look inside ProDOS volumes on .po, .dsk and .hdv
+// images. It lists directories, gets and puts files and makes and removes
+// directories.
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/prodos"
+)
+
+const synopsis = `    %[1]s list IMAGE [DIRECTORY]
+    %[1]s get IMAGE PATH [OUTPUT]
+    %[1]s put [-type TYPE] [-aux AUXTYPE] IMAGE FILE PATH
+    %[1]s mkdir IMAGE PATH
+    %[1]s rm IMAGE PATH
+`
+
+// usage prints how to use the tool and exits
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Synopsis:\n"+synopsis+"\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Example:\n    %s put -type BIN -aux 2000 work.po game.bin GAMES/GAME\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Paths are relative to the volume directory. get writes to stdout if there\n")
+	fmt.Fprintf(os.Stderr, "is no OUTPUT. put replaces a file with the same name.\n")
+	os.Exit(2)
+}
+
+// fail prints an error and exits
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
+
+// image is a ProDOS volume read from an image file
+type image struct {
+	path       string
+	volume     *prodos.Volume
+	dosOrdered bool // If the image file is in DOS 3.3 order
+}
+
+// readImage reads the ProDOS volume in an image file
+func readImage(path string) *image {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("Unable to read image: %s", err)
+	}
+
+	blocks, dosOrdered := disk.ProDOSOrderBlocks(path, bytes)
+	v, err := prodos.New(blocks)
+	if err != nil {
+		fail("Unable to read image %s: %s", path, err)
+	}
+
+	return &image{path, v, dosOrdered}
+}
+
+// write writes the volume back to its image file in the file's order
+func (i *image) write() {
+	bytes := i.volume.Blocks
+	if i.dosOrdered {
+		bytes = disk.DOSOrderImage(bytes)
+	}
+
+	if err := disk.WriteFileAtomically(i.path, bytes); err != nil {
+		fail("Unable to write image: %s", err)
+	}
+}
+
+// parseAuxType parses a hex aux type with an optional $
+func parseAuxType(s string) uint16 {
+	value, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		fail("Invalid aux type %q, expected a hex value such as 2000", s)
+	}
+
+	return uint16(value)
+}
+
+// list prints a directory like the ProDOS CATALOG command
+func list(args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		usage()
+	}
+
+	i := readImage(args[0])
+	dir := "/"
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	files, err := i.volume.ReadDir(dir)
+	if err != nil {
+		fail("Unable to read %s: %s", dir, err)
+	}
+
+	fmt.Printf("/%s/%s\n\n", i.volume.Name(), strings.ToUpper(strings.Trim(dir, "/")))
+	fmt.Printf(" NAME            TYPE  BLOCKS  MODIFIED          ENDFILE  SUBTYPE\n\n")
+	for _, f := range files {
+		locked := " "
+		if f.Locked {
+			locked = "*"
+		}
+
+		modified := "<NO DATE>"
+		if !f.Modified.IsZero() {
+			modified = strings.ToUpper(f.Modified.Format("02-Jan-06 15:04"))
+		}
+
+		fmt.Printf("%s%-15s %-4s %7d  %-16s %8d  $%04X\n", locked, f.Name, prodos.FileTypeName(f.Type), f.BlocksUsed, modified, f.EOF, f.AuxType)
+	}
+
+	free := i.volume.FreeBlocks()
+	total := i.volume.TotalBlocks()
+	fmt.Printf("\nBLOCKS FREE: %5d    BLOCKS USED: %5d    TOTAL BLOCKS: %5d\n", free, total-free, total)
+}
+
+// get writes a file's data to a file or stdout
+func get(args []string) {
+	if len(args) != 2 && len(args) != 3 {
+		usage()
+	}
+
+	i := readImage(args[0])
+	f, data, err := i.volume.ReadFile(args[1])
+	if err != nil {
+		fail("Unable to read %s: %s", args[1], err)
+	}
+	fmt.Fprintf(os.Stderr, "%s is %s with aux type $%04X and length %d\n", f.Name, prodos.FileTypeName(f.Type), f.AuxType, f.EOF)
+
+	if len(args) == 2 || args[2] == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err := ioutil.WriteFile(args[2], data, 0644); err != nil {
+		fail("Unable to write %s: %s", args[2], err)
+	}
+}
+
+// put writes a file from the host to the volume
+func put(args []string) {
+	flags := flag.NewFlagSet("put", flag.ExitOnError)
+	flags.Usage = usage
+	typeName := flags.String("type", "BIN", "ProDOS file type, a name such as BIN, TXT or SYS or a hex value such as $F1")
+	auxType := flags.String("aux", "0", "Aux type in hex, the load address of BIN files")
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		usage()
+	}
+
+	fileType, err := prodos.ParseFileType(*typeName)
+	if err != nil {
+		fail("%s", err)
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(1))
+	if err != nil {
+		fail("Unable to read %s: %s", flags.Arg(1), err)
+	}
+
+	i := readImage(flags.Arg(0))
+	if err := i.volume.WriteFile(flags.Arg(2), fileType, parseAuxType(*auxType), data); err != nil {
+		fail("Unable to write %s: %s", flags.Arg(2), err)
+	}
+	i.write()
+}
+
+// mkdir creates a directory on the volume
+func mkdir(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+
+	i := readImage(args[0])
+	if err := i.volume.Mkdir(args[1]); err != nil {
+		fail("Unable to create %s: %s", args[1], err)
+	}
+	i.write()
+}
+
+// rm deletes a file or empty directory from the volume
+func rm(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+
+	i := readImage(args[0])
+	if err := i.volume.Remove(args[1]); err != nil {
+		fail("Unable to remove %s: %s", args[1], err)
+	}
+	i.write()
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "list":
+		list(args)
+	case "get":
+		get(args)
+	case "put":
+		put(args)
+	case "mkdir":
+		mkdir(args)
+	case "rm":
+		rm(args)
+	default:
+		usage()
+	}
+}
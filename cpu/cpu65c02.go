@@ -0,0 +1,198 @@
+package cpu
+
+import (
+	"fmt"
+	"os"
+)
+
+// adcDecimal65C02 does a decimal mode ADC. Unlike the NMOS 6502, the N and Z flags
+// are valid and based on the decimal result.
+// See http://www.6502.org/tutorials/decimal_mode.html#A
+func (c *CPU) adcDecimal65C02(value uint8) {
+	carry := 0
+	if c.isC() {
+		carry = 1
+	}
+
+	lsd := int(c.State.A&0xf) + int(value&0xf) + carry
+	if lsd >= 0xa {
+		lsd = ((lsd + 6) & 0xf) + 0x10
+	}
+
+	// The overflow flag is calculated the same way as on the NMOS 6502, using
+	// signed arithmetic on the intermediate result.
+	signed := int(int8(c.State.A&0xf0)) + int(int8(value&0xf0)) + lsd
+	c.setV(signed < -128 || signed > 127)
+
+	result := int(c.State.A&0xf0) + int(value&0xf0) + lsd
+	if result >= 0xa0 {
+		result += 0x60
+	}
+
+	c.setC(result >= 0x100)
+	c.State.A = uint8(result)
+	c.setN(c.State.A)
+	c.setZ(c.State.A)
+}
+
+// sbcDecimal65C02 does a decimal mode SBC. The C and V flags are the same as in
+// binary mode, the N and Z flags are based on the decimal result.
+func (c *CPU) sbcDecimal65C02(value uint8) {
+	borrow := 0
+	if !c.isC() {
+		borrow = 1
+	}
+
+	binary := int(c.State.A) - int(value) - borrow
+	c.setV((((c.State.A ^ uint8(binary)) & 0x80) != 0) && (((c.State.A ^ value) & 0x80) != 0))
+	c.setC(binary >= 0)
+
+	lsd := int(c.State.A&0xf) - int(value&0xf) - borrow
+	result := binary
+	if result < 0 {
+		result -= 0x60
+	}
+	if lsd < 0 {
+		result -= 0x06
+	}
+
+	c.State.A = uint8(result)
+	c.setN(c.State.A)
+	c.setZ(c.State.A)
+}
+
+// run65C02Instruction runs an instruction that is new on the 65C02. It returns false
+// if the instruction is the same as on the NMOS 6502.
+func (c *CPU) run65C02Instruction(opcode uint8, addressMode byte) bool {
+	switch opcode {
+	case 0x80: // BRA
+		c.branch(true)
+
+	case 0x7c: // JMP ($0000,X)
+		value := uint16(c.MMU.ReadMemory(c.State.PC+1)) + uint16(c.MMU.ReadMemory(c.State.PC+2))<<8 + uint16(c.State.X)
+		address := uint16(c.MMU.ReadMemory(value)) + uint16(c.MMU.ReadMemory(value+1))<<8
+		if c.System.RunningTests && c.State.PC == address {
+			// Check for an infinite loop and exit if so
+			fmt.Printf("Trap at $%04x\n", address)
+			os.Exit(0)
+		}
+		c.State.PC = address
+		c.System.FrameCycles += 6
+
+	case 0x12: // ORA ($00)
+		c.ora(addressMode)
+	case 0x32: // AND ($00)
+		c.and(addressMode)
+	case 0x52: // EOR ($00)
+		c.eor(addressMode)
+	case 0x72: // ADC ($00)
+		c.adc(addressMode)
+	case 0x92: // STA ($00)
+		c.store(c.State.A, addressMode)
+	case 0xb2: // LDA ($00)
+		c.State.A = c.load(addressMode)
+	case 0xd2: // CMP ($00)
+		c.cmp(c.State.A, addressMode)
+	case 0xf2: // SBC ($00)
+		c.sbc(addressMode)
+
+	case 0x64, 0x74, 0x9c, 0x9e: // STZ
+		c.store(0, addressMode)
+
+	case 0x89: // BIT #$00
+		// Only the Z flag is affected in immediate mode
+		c.setZ(c.MMU.ReadMemory(c.State.PC+1) & c.State.A)
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0x34: // BIT $00,X
+		address, _ := c.getAddressFromAddressMode(addressMode)
+		c.bit(address)
+		c.State.PC += 2
+		c.System.FrameCycles += 4
+	case 0x3c: // BIT $0000,X
+		address, pageBoundaryCrossed := c.getAddressFromAddressMode(addressMode)
+		c.bit(address)
+		c.State.PC += 3
+		c.System.FrameCycles += 4
+		if pageBoundaryCrossed {
+			c.System.FrameCycles++
+		}
+
+	case 0x04, 0x0c: // TSB
+		address, _ := c.getAddressFromAddressMode(addressMode)
+		value := c.MMU.ReadMemory(address)
+		c.setZ(value & c.State.A)
+		c.MMU.WriteMemory(address, value|c.State.A)
+		c.postProcessIncDec(addressMode)
+	case 0x14, 0x1c: // TRB
+		address, _ := c.getAddressFromAddressMode(addressMode)
+		value := c.MMU.ReadMemory(address)
+		c.setZ(value & c.State.A)
+		c.MMU.WriteMemory(address, value&^c.State.A)
+		c.postProcessIncDec(addressMode)
+
+	case 0x1a: // INC A
+		c.State.A++
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+		c.State.PC++
+		c.System.FrameCycles += 2
+	case 0x3a: // DEC A
+		c.State.A--
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+		c.State.PC++
+		c.System.FrameCycles += 2
+
+	// Stack operations
+	case 0xda: // PHX
+		c.push8(c.State.X)
+		c.State.PC++
+		c.System.FrameCycles += 3
+	case 0xfa: // PLX
+		c.State.X = c.pop8()
+		c.setN(c.State.X)
+		c.setZ(c.State.X)
+		c.State.PC++
+		c.System.FrameCycles += 4
+	case 0x5a: // PHY
+		c.push8(c.State.Y)
+		c.State.PC++
+		c.System.FrameCycles += 3
+	case 0x7a: // PLY
+		c.State.Y = c.pop8()
+		c.setN(c.State.Y)
+		c.setZ(c.State.Y)
+		c.State.PC++
+		c.System.FrameCycles += 4
+
+	// NOPs of varying lengths and durations
+	case 0x02, 0x22, 0x42, 0x62, 0x82, 0xc2, 0xe2:
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0x44:
+		c.State.PC += 2
+		c.System.FrameCycles += 3
+	case 0x54, 0xd4, 0xf4:
+		c.State.PC += 2
+		c.System.FrameCycles += 4
+	case 0x5c:
+		c.State.PC += 3
+		c.System.FrameCycles += 8
+	case 0xdc, 0xfc:
+		c.State.PC += 3
+		c.System.FrameCycles += 4
+
+	default:
+		if (opcode & 0x03) == 0x03 {
+			// Single byte, single cycle NOP
+			c.State.PC++
+			c.System.FrameCycles++
+			return true
+		}
+
+		return false
+	}
+
+	return true
+}
@@ -5,10 +5,16 @@ package cpu_test
 // will exit or bail on success and failure certain conditions.
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
 	"github.com/freewilll/apple2-go/cpu"
 	"github.com/freewilll/apple2-go/mmu"
 	"github.com/freewilll/apple2-go/system"
@@ -24,9 +30,8 @@ func TestCPU(t *testing.T) {
 
 	breakAddress := utils.DecodeCmdLineAddress(breakAddressString)
 
-	cpu.InitInstructionDecoder()
-
-	mmu.InitRAM()
+	m := newMMU()
+	c := cpu.New(cpu.Model6502, m, m.System)
 
 	var Roms = []string{
 		"6502_functional_test.bin.gz",
@@ -44,39 +49,297 @@ func TestCPU(t *testing.T) {
 
 		fmt.Printf("Running %s\n", rom)
 
-		cpu.Init()
-		cpu.State.PC = 0x800
-		system.RunningTests = true
+		c.Init()
+		c.State.PC = 0x800
+		c.System.RunningTests = true
 
 		if i == 0 {
-			system.RunningFunctionalTests = true
+			c.System.RunningFunctionalTests = true
 		}
 
 		if i == 1 {
-			system.RunningInterruptTests = true
+			c.System.RunningInterruptTests = true
 		}
 
-		bytes, err := utils.ReadMemoryFromGzipFile(rom)
-		if err != nil {
-			panic(err)
-		}
+		loadTestRom(m, rom)
 
-		// Copy main RAM area 0x0000-0xbfff
-		for i := 0; i < 0xc000; i++ {
-			mmu.PhysicalMemory.MainMemory[i] = bytes[i]
-		}
+		c.Run(*showInstructions, breakAddress, true, false, false, 0)
+		fmt.Printf("Finished running %s\n\n", rom)
+	}
+}
 
-		// Map writable RAM area in 0xc000-0xffff
-		var RomPretendingToBeRAM [0x4000]uint8
-		for i := 0x0; i < 0x4000; i++ {
-			RomPretendingToBeRAM[i] = bytes[0xc000+i]
-		}
-		for i := 0x0; i < 0x40; i++ {
-			mmu.ReadPageTable[0xc0+i] = RomPretendingToBeRAM[i*0x100 : i*0x100+0x100]
-			mmu.WritePageTable[0xc0+i] = RomPretendingToBeRAM[i*0x100 : i*0x100+0x100]
+// newMMU creates an MMU with its own memory and no IO
+func newMMU() *mmu.MMU {
+	m := mmu.New(system.New(), nil, nil, nil, nil, nil)
+	m.InitRAM()
+	return m
+}
+
+// loadTestRom loads a test ROM into RAM, with the area from 0xc000 mapped as writable RAM
+func loadTestRom(m *mmu.MMU, rom string) {
+	bytes, err := utils.ReadMemoryFromGzipFile(rom)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < 0xc000; i++ {
+		m.PhysicalMemory.MainMemory[i] = bytes[i]
+	}
+
+	var RomPretendingToBeRAM [0x4000]uint8
+	for i := 0x0; i < 0x4000; i++ {
+		RomPretendingToBeRAM[i] = bytes[0xc000+i]
+	}
+	for i := 0x0; i < 0x40; i++ {
+		m.ReadPageTable[0xc0+i] = RomPretendingToBeRAM[i*0x100 : i*0x100+0x100]
+		m.WritePageTable[0xc0+i] = RomPretendingToBeRAM[i*0x100 : i*0x100+0x100]
+	}
+}
+
+// findSuccessAddress finds the address of the "jmp *" in the success macro of a test listing
+func findSuccessAddress(listing string) (uint16, error) {
+	f, err := os.Open(listing)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "jmp *") && strings.Contains(line, ";test passed") {
+			address, err := strconv.ParseUint(strings.Fields(line)[0], 16, 16)
+			return uint16(address), err
 		}
+	}
 
-		cpu.Run(*showInstructions, breakAddress, true, false, false, 0)
-		fmt.Printf("Finished running %s\n\n", rom)
+	return 0, fmt.Errorf("No success address found in %s", listing)
+}
+
+// TestCPU65C02ExtendedOpcodes runs the 65C02 extended opcodes test. The test runs until the address
+// of the success trap in the listing file has been reached.
+func TestCPU65C02ExtendedOpcodes(t *testing.T) {
+	rom := "65C02_extended_opcodes_test.bin.gz"
+	if _, err := os.Stat(rom); os.IsNotExist(err) {
+		t.Skipf("%s not found, see README.md on how to create it", rom)
 	}
+
+	successAddress, err := findSuccessAddress("65C02_extended_opcodes_test.lst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMMU()
+	c := cpu.New(cpu.Model65C02, m, m.System)
+
+	fmt.Printf("Running %s\n", rom)
+	c.State.PC = 0x800
+	c.System.RunningTests = true
+	c.System.RunningFunctionalTests = true
+	loadTestRom(m, rom)
+
+	c.Run(false, &successAddress, false, false, false, 0)
+	assert.Equal(t, successAddress, c.State.PC)
+	fmt.Println("Extended opcode tests passed")
+}
+
+// runProgram runs a program at $0800 on a new CPU until the PC reaches breakAddress or wantedCycles
+// have been run. The CPU is returned so that its state can be checked.
+func runProgram(m *mmu.MMU, model int, program []uint8, breakAddress uint16, wantedCycles uint64) *cpu.CPU {
+	c := cpu.New(model, m, m.System)
+	m.InitRAM()
+	copy(m.PhysicalMemory.MainMemory[0x800:], program)
+	c.State.PC = 0x800
+	c.Run(false, &breakAddress, false, false, false, wantedCycles)
+	return c
+}
+
+func TestCPU65C02Instructions(t *testing.T) {
+	m := newMMU()
+
+	// New instructions and the ($00) addressing mode
+	m.PhysicalMemory.MainMemory[0x11] = 0x99
+	c := runProgram(m, cpu.Model65C02, []uint8{
+		0xa9, 0x0f, // LDA #$0f
+		0x85, 0x10, // STA $10
+		0xa9, 0xf0, // LDA #$f0
+		0x04, 0x10, // TSB $10
+		0xa9, 0x0c, // LDA #$0c
+		0x14, 0x10, // TRB $10
+		0x64, 0x11, // STZ $11
+		0xa9, 0x00, // LDA #$00
+		0x85, 0x20, // STA $20
+		0xa9, 0x30, // LDA #$30
+		0x85, 0x21, // STA $21
+		0xa9, 0x42, // LDA #$42
+		0x92, 0x20, // STA ($20)
+		0x1a,       // INC A
+		0xa2, 0x55, // LDX #$55
+		0xda,       // PHX
+		0x7a,       // PLY
+		0x80, 0x02, // BRA $0823
+		0xa9, 0x00, // LDA #$00
+	}, 0x823, 0)
+	assert.Equal(t, uint16(0x823), c.State.PC)
+	assert.Equal(t, uint8(0xf3), m.PhysicalMemory.MainMemory[0x10])
+	assert.Equal(t, uint8(0x00), m.PhysicalMemory.MainMemory[0x11])
+	assert.Equal(t, uint8(0x42), m.PhysicalMemory.MainMemory[0x3000])
+	assert.Equal(t, uint8(0x43), c.State.A)
+	assert.Equal(t, uint8(0x55), c.State.Y)
+
+	// BIT immediate only affects the Z flag
+	c = runProgram(m, cpu.Model65C02, []uint8{
+		0xa9, 0x01, // LDA #$01
+		0x89, 0xc0, // BIT #$c0
+	}, 0x804, 0)
+	assert.Equal(t, uint8(0x02), c.State.P&0xc2) // Z set, N and V clear
+
+	// JMP ($xxff) only works properly on the 65C02
+	m.PhysicalMemory.MainMemory[0x30ff] = 0x00
+	m.PhysicalMemory.MainMemory[0x3000] = 0x09
+	m.PhysicalMemory.MainMemory[0x3100] = 0x0a
+	c = runProgram(m, cpu.Model6502, []uint8{0x6c, 0xff, 0x30}, 0, 1)
+	assert.Equal(t, uint16(0x0900), c.State.PC)
+	assert.Equal(t, uint64(5), m.System.FrameCycles)
+	c = runProgram(m, cpu.Model65C02, []uint8{0x6c, 0xff, 0x30}, 0, 1)
+	assert.Equal(t, uint16(0x0a00), c.State.PC)
+	assert.Equal(t, uint64(6), m.System.FrameCycles)
+
+	// JMP ($0000,X)
+	m.PhysicalMemory.MainMemory[0x3002] = 0x34
+	m.PhysicalMemory.MainMemory[0x3003] = 0x12
+	c = runProgram(m, cpu.Model65C02, []uint8{
+		0xa2, 0x02, // LDX #$02
+		0x7c, 0x00, 0x30, // JMP ($3000,X)
+	}, 0x1234, 0)
+	assert.Equal(t, uint16(0x1234), c.State.PC)
+	assert.Equal(t, uint64(8), m.System.FrameCycles)
+
+	// Decimal mode has valid flags and takes an extra cycle
+	c = runProgram(m, cpu.Model65C02, []uint8{
+		0xf8,       // SED
+		0x18,       // CLC
+		0xa9, 0x99, // LDA #$99
+		0x69, 0x01, // ADC #$01
+	}, 0x806, 0)
+	assert.Equal(t, uint8(0x00), c.State.A)
+	assert.Equal(t, uint8(0x03), c.State.P&0x83) // Z and C set, N clear
+	assert.Equal(t, uint64(9), m.System.FrameCycles)
+
+	c = runProgram(m, cpu.Model65C02, []uint8{
+		0xf8,       // SED
+		0x38,       // SEC
+		0xa9, 0x00, // LDA #$00
+		0xe9, 0x01, // SBC #$01
+	}, 0x806, 0)
+	assert.Equal(t, uint8(0x99), c.State.A)
+	assert.Equal(t, uint8(0x80), c.State.P&0x83) // N set, Z and C clear
+
+	// Undefined opcodes are NOPs
+	c = runProgram(m, cpu.Model65C02, []uint8{
+		0x5c, 0x00, 0x00, // NOP $0000
+		0x03,       // NOP
+		0x02, 0x00, // NOP #$00
+	}, 0x806, 0)
+	assert.Equal(t, uint16(0x806), c.State.PC)
+	assert.Equal(t, uint64(11), m.System.FrameCycles)
+}
+
+func TestCPUUndocumentedInstructions(t *testing.T) {
+	m := newMMU()
+
+	// LAX, SAX, SLO and DCP
+	m.PhysicalMemory.MainMemory[0x10] = 0x81
+	m.PhysicalMemory.MainMemory[0x11] = 0x40
+	m.PhysicalMemory.MainMemory[0x12] = 0x01
+	c := runProgram(m, cpu.Model6502, []uint8{
+		0xa7, 0x10, // LAX $10
+		0xa9, 0x0f, // LDA #$0f
+		0x87, 0x13, // SAX $13
+		0x07, 0x11, // SLO $11
+		0xc7, 0x12, // DCP $12
+	}, 0x80a, 0)
+	assert.Equal(t, uint16(0x80a), c.State.PC)
+	assert.Equal(t, uint8(0x81), c.State.X)
+	assert.Equal(t, uint8(0x01), m.PhysicalMemory.MainMemory[0x13])
+	assert.Equal(t, uint8(0x80), m.PhysicalMemory.MainMemory[0x11])
+	assert.Equal(t, uint8(0x8f), c.State.A)
+	assert.Equal(t, uint8(0x00), m.PhysicalMemory.MainMemory[0x12])
+	assert.Equal(t, uint8(0x81), c.State.P&0x83) // N and C set, Z clear
+	assert.Equal(t, uint64(3+2+3+5+5), m.System.FrameCycles)
+
+	// ISC and RRA
+	m.PhysicalMemory.MainMemory[0x10] = 0x0f
+	m.PhysicalMemory.MainMemory[0x11] = 0x02
+	c = runProgram(m, cpu.Model6502, []uint8{
+		0x38,       // SEC
+		0xa9, 0x20, // LDA #$20
+		0xe7, 0x10, // ISC $10
+		0x18,       // CLC
+		0x67, 0x11, // RRA $11
+	}, 0x808, 0)
+	assert.Equal(t, uint8(0x10), m.PhysicalMemory.MainMemory[0x10])
+	assert.Equal(t, uint8(0x01), m.PhysicalMemory.MainMemory[0x11])
+	assert.Equal(t, uint8(0x11), c.State.A)
+
+	// ANC, ALR, ARR and SBX
+	c = runProgram(m, cpu.Model6502, []uint8{
+		0xa9, 0xff, // LDA #$ff
+		0x0b, 0x80, // ANC #$80
+		0x08,       // PHP
+		0x4b, 0x03, // ALR #$03
+		0xa9, 0xff, // LDA #$ff
+		0x38,       // SEC
+		0x6b, 0xc0, // ARR #$c0
+		0x85, 0x10, // STA $10
+		0xa9, 0x0f, // LDA #$0f
+		0xa2, 0x3f, // LDX #$3f
+		0xcb, 0x05, // SBX #$05
+	}, 0x814, 0)
+	assert.Equal(t, uint8(0x81), m.PhysicalMemory.MainMemory[0x1ff]&0x81) // ANC: N and C set
+	assert.Equal(t, uint8(0xe0), m.PhysicalMemory.MainMemory[0x10])       // ARR
+	assert.Equal(t, uint8(0x0a), c.State.X)
+	assert.Equal(t, uint8(0x01), c.State.P&0x83) // SBX: C set, N and Z clear
+
+	// NOPs of various sizes
+	c = runProgram(m, cpu.Model6502, []uint8{
+		0x1a,       // NOP
+		0x80, 0x00, // NOP #$00
+		0x04, 0x00, // NOP $00
+		0x14, 0x00, // NOP $00,X
+		0x0c, 0x00, 0x00, // NOP $0000
+		0x1c, 0x00, 0x00, // NOP $0000,X
+	}, 0x80d, 0)
+	assert.Equal(t, uint16(0x80d), c.State.PC)
+	assert.Equal(t, uint64(2+2+3+4+4+4), m.System.FrameCycles)
+}
+
+func TestDumpMemoryRange(t *testing.T) {
+	m := newMMU()
+	c := cpu.New(cpu.Model6502, m, m.System)
+	m.PhysicalMemory.MainMemory[0xbfff] = 0x42
+	m.ReadPageTable[0xc0] = nil
+
+	// Capture stdout
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	c.DumpMemoryRange(0xbffe, 0xc001)
+	os.Stdout = stdout
+	w.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	// The $c0 IO page isn't mapped and is shown as --
+	assert.Equal(t, []string{
+		"bff0  " + strings.Repeat("   ", 8) + " " + strings.Repeat("   ", 6) + " 00 42",
+		"c000   -- --",
+	}, lines)
 }
@@ -3,8 +3,6 @@ package cpu
 import (
 	"fmt"
 	"strings"
-
-	"github.com/freewilll/apple2-go/mmu"
 )
 
 // printFlag prints a lower or uppercase letter depending on the state of the flag
@@ -17,42 +15,42 @@ func printFlag(p byte, flag uint8, code string) {
 }
 
 // printInstruction prings a single instruction and optionally also registers
-func printInstruction(instruction string, showRegisters bool) {
-	fmt.Printf("%04x-   %-24s", State.PC, instruction)
+func (c *CPU) printInstruction(instruction string, showRegisters bool) {
+	fmt.Printf("%04x-   %-24s", c.State.PC, instruction)
 
 	if showRegisters {
 		fmt.Printf("     A=%02x X=%02x Y=%02x S=%02x P=%02x ",
-			State.A,
-			State.X,
-			State.Y,
-			State.SP,
-			State.P,
+			c.State.A,
+			c.State.X,
+			c.State.Y,
+			c.State.SP,
+			c.State.P,
 		)
 
-		printFlag(State.P, cpuFlagN, "n")
-		printFlag(State.P, cpuFlagV, "v")
+		printFlag(c.State.P, cpuFlagN, "n")
+		printFlag(c.State.P, cpuFlagV, "v")
 		fmt.Print("-") // cpuFlagR flag that's always 1
-		printFlag(State.P, cpuFlagB, "b")
-		printFlag(State.P, cpuFlagD, "d")
-		printFlag(State.P, cpuFlagI, "i")
-		printFlag(State.P, cpuFlagZ, "z")
-		printFlag(State.P, cpuFlagC, "c")
+		printFlag(c.State.P, cpuFlagB, "b")
+		printFlag(c.State.P, cpuFlagD, "d")
+		printFlag(c.State.P, cpuFlagI, "i")
+		printFlag(c.State.P, cpuFlagZ, "z")
+		printFlag(c.State.P, cpuFlagC, "c")
 	}
 
 	fmt.Println("")
 }
 
 // PrintInstruction prints the instruction at the current PC
-func PrintInstruction(showRegisters bool) {
-	opcodeValue := mmu.ReadPageTable[(State.PC)>>8][(State.PC)&0xff]
-	opcode := opCodes[opcodeValue]
+func (c *CPU) PrintInstruction(showRegisters bool) {
+	opcodeValue := c.MMU.ReadPageTable[(c.State.PC)>>8][(c.State.PC)&0xff]
+	opcode := c.opCodes[opcodeValue]
 	mnemonic := opcode.mnemonic
 	size := opcode.addressingMode.operandSize
 	stringFormat := opcode.addressingMode.stringFormat
 
 	var value uint16
 	if size == 0 {
-		printInstruction(fmt.Sprintf("%02x           %s", opcodeValue, mnemonic), showRegisters)
+		c.printInstruction(fmt.Sprintf("%02x           %s", opcodeValue, mnemonic), showRegisters)
 		return
 	}
 
@@ -60,53 +58,68 @@ func PrintInstruction(showRegisters bool) {
 	var suffix string
 
 	if opcode.addressingMode.mode == amRelative {
-		value = uint16(mmu.ReadPageTable[(State.PC+1)>>8][(State.PC+1)&0xff])
+		value = uint16(c.MMU.ReadPageTable[(c.State.PC+1)>>8][(c.State.PC+1)&0xff])
 		var relativeAddress uint16
 		if (value & 0x80) == 0 {
-			relativeAddress = State.PC + 2 + uint16(value)
+			relativeAddress = c.State.PC + 2 + uint16(value)
 		} else {
-			relativeAddress = State.PC + 2 + uint16(value) - 0x100
+			relativeAddress = c.State.PC + 2 + uint16(value) - 0x100
 		}
 
 		suffix = fmt.Sprintf(stringFormat, relativeAddress)
 		opcodes = fmt.Sprintf("%02x %02x       ", opcodeValue, value)
 	} else if size == 1 {
-		value = uint16(mmu.ReadPageTable[(State.PC+1)>>8][(State.PC+1)&0xff])
+		value = uint16(c.MMU.ReadPageTable[(c.State.PC+1)>>8][(c.State.PC+1)&0xff])
 		suffix = fmt.Sprintf(stringFormat, value)
 		opcodes = fmt.Sprintf("%02x %02x       ", opcodeValue, value)
 	} else if size == 2 {
-		lsb := mmu.ReadPageTable[(State.PC+1)>>8][(State.PC+1)&0xff]
-		msb := mmu.ReadPageTable[(State.PC+2)>>8][(State.PC+2)&0xff]
+		lsb := c.MMU.ReadPageTable[(c.State.PC+1)>>8][(c.State.PC+1)&0xff]
+		msb := c.MMU.ReadPageTable[(c.State.PC+2)>>8][(c.State.PC+2)&0xff]
 		value = uint16(lsb) + uint16(msb)*0x100
 		suffix = fmt.Sprintf(stringFormat, value)
 		opcodes = fmt.Sprintf("%02x %02x %02x    ", opcodeValue, lsb, msb)
 	}
 
-	printInstruction(fmt.Sprintf("%s %s %s", opcodes, mnemonic, suffix), showRegisters)
+	c.printInstruction(fmt.Sprintf("%s %s %s", opcodes, mnemonic, suffix), showRegisters)
 }
 
 // AdvanceInstruction goes forward one instruction without executing anything
-func AdvanceInstruction() {
-	opcodeValue := mmu.ReadPageTable[(State.PC)>>8][(State.PC)&0xff]
-	opcode := opCodes[opcodeValue]
+func (c *CPU) AdvanceInstruction() {
+	opcodeValue := c.MMU.ReadPageTable[(c.State.PC)>>8][(c.State.PC)&0xff]
+	opcode := c.opCodes[opcodeValue]
 	size := opcode.addressingMode.operandSize + 1
-	State.PC += uint16(size)
+	c.State.PC += uint16(size)
 }
 
 // DumpMemory dumps $100 bytes of memory
-func DumpMemory(offset uint16) {
-	var i uint16
-	for i = 0; i < 0x100; i++ {
+func (c *CPU) DumpMemory(offset uint16) {
+	c.DumpMemoryRange(offset, offset+0xff)
+}
+
+// DumpMemoryRange dumps the memory from start to end, inclusive. Lines start at
+// a multiple of $10. Memory is read from the page tables, so no soft switches
+// are triggered. Unmapped pages such as the $c0 IO page are shown as --.
+func (c *CPU) DumpMemoryRange(start uint16, end uint16) {
+	for i := int(start) &^ 0xf; i <= int(end); i++ {
 		if (i & 0xf) == 8 {
 			fmt.Print(" ")
 		}
 		if (i & 0xf) == 0 {
-			if i > 0 {
+			if i > int(start)&^0xf {
 				fmt.Print("\n")
 			}
-			fmt.Printf("%04x  ", offset+i)
+			fmt.Printf("%04x  ", i)
+		}
+		if i < int(start) {
+			fmt.Print("   ")
+			continue
+		}
+		page := c.MMU.ReadPageTable[i>>8]
+		if page == nil {
+			fmt.Print(" --")
+			continue
 		}
-		fmt.Printf(" %02x", mmu.ReadPageTable[(offset+i)>>8][(offset+i)&0xff])
+		fmt.Printf(" %02x", page[i&0xff])
 	}
 	fmt.Print("\n")
 }
@@ -0,0 +1,152 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// The stable undocumented opcodes of the NMOS 6502.
+// See http://www.oxyron.de/html/opcodes02.html and "No More Secrets" by Groepaz
+// for the details. The unstable opcodes (XAA, LXA, AHX, TAS, SHX, SHY, LAS) and
+// the ones that jam the CPU are not implemented.
+
+// readModifyWrite reads a value from memory, modifies it with fn, writes it back
+// and advances the PC and cycles. The modified value is returned.
+func (c *CPU) readModifyWrite(addressMode byte, fn func(uint8) uint8) uint8 {
+	address, _ := c.getAddressFromAddressMode(addressMode)
+	value := fn(c.MMU.ReadMemory(address))
+	c.MMU.WriteMemory(address, value)
+
+	switch addressMode {
+	case amZeroPage:
+		c.State.PC += 2
+		c.System.FrameCycles += 5
+	case amZeroPageX:
+		c.State.PC += 2
+		c.System.FrameCycles += 6
+	case amAbsolute:
+		c.State.PC += 3
+		c.System.FrameCycles += 6
+	case amAbsoluteX:
+		c.State.PC += 3
+		c.System.FrameCycles += 7
+	case amAbsoluteY:
+		c.State.PC += 3
+		c.System.FrameCycles += 7
+	case amIndirectX:
+		c.State.PC += 2
+		c.System.FrameCycles += 8
+	case amIndirectY:
+		c.State.PC += 2
+		c.System.FrameCycles += 8
+	default:
+		panic(fmt.Sprintf("Unknown address mode %d in c.readModifyWrite()", addressMode))
+	}
+
+	return value
+}
+
+// compare sets the flags like CMP does
+func (c *CPU) compare(regValue uint8, value uint8) {
+	c.setC(regValue >= value)
+	c.setN(regValue - value)
+	c.setZ(regValue - value)
+}
+
+// arr does an AND followed by a ROR, with the weird flags
+func (c *CPU) arr(value uint8) {
+	value &= c.State.A
+	carry := c.State.P & cpuFlagC
+	c.State.A = (value >> 1) | (carry << 7)
+	c.setZ(c.State.A)
+
+	if !c.isD() {
+		c.setN(c.State.A)
+		c.setC((c.State.A & 0x40) != 0)
+		c.setV((((c.State.A >> 6) ^ (c.State.A >> 5)) & 1) != 0)
+		return
+	}
+
+	// In decimal mode, N is the old carry and V is set as in binary mode.
+	// The nibbles are then BCD fixed up, using the value before the shift.
+	c.setN(carry << 7)
+	c.setV(((value ^ c.State.A) & 0x40) != 0)
+	if (value&0xf)+(value&0x1) > 5 {
+		c.State.A = (c.State.A & 0xf0) | ((c.State.A + 6) & 0xf)
+	}
+	c.setC((value>>4)+((value>>4)&0x1) > 5)
+	if c.isC() {
+		c.State.A += 0x60
+	}
+}
+
+// runUndocumentedInstruction runs an undocumented NMOS 6502 instruction. It returns false if
+// the opcode isn't implemented.
+func (c *CPU) runUndocumentedInstruction(opcode uint8, addressMode byte) bool {
+	switch opcode {
+	case 0x07, 0x17, 0x0f, 0x1f, 0x1b, 0x03, 0x13: // SLO
+		c.State.A |= c.readModifyWrite(addressMode, c.asl)
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+	case 0x27, 0x37, 0x2f, 0x3f, 0x3b, 0x23, 0x33: // RLA
+		c.State.A &= c.readModifyWrite(addressMode, c.rol)
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+	case 0x47, 0x57, 0x4f, 0x5f, 0x5b, 0x43, 0x53: // SRE
+		c.State.A ^= c.readModifyWrite(addressMode, c.lsr)
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+	case 0x67, 0x77, 0x6f, 0x7f, 0x7b, 0x63, 0x73: // RRA
+		c.addWithCarry(c.readModifyWrite(addressMode, c.ror))
+	case 0xc7, 0xd7, 0xcf, 0xdf, 0xdb, 0xc3, 0xd3: // DCP
+		c.compare(c.State.A, c.readModifyWrite(addressMode, func(value uint8) uint8 { return value - 1 }))
+	case 0xe7, 0xf7, 0xef, 0xff, 0xfb, 0xe3, 0xf3: // ISC
+		c.subtractWithBorrow(c.readModifyWrite(addressMode, func(value uint8) uint8 { return value + 1 }))
+
+	case 0x87, 0x97, 0x8f, 0x83: // SAX
+		c.store(c.State.A&c.State.X, addressMode)
+	case 0xa7, 0xb7, 0xaf, 0xbf, 0xa3, 0xb3: // LAX
+		c.State.A = c.load(addressMode)
+		c.State.X = c.State.A
+
+	case 0x0b, 0x2b: // ANC #$00
+		c.State.A &= c.MMU.ReadMemory(c.State.PC + 1)
+		c.setN(c.State.A)
+		c.setZ(c.State.A)
+		c.setC(c.isN())
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0x4b: // ALR #$00
+		c.State.A = c.lsr(c.State.A & c.MMU.ReadMemory(c.State.PC+1))
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0x6b: // ARR #$00
+		c.arr(c.MMU.ReadMemory(c.State.PC + 1))
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0xcb: // SBX #$00
+		value := c.MMU.ReadMemory(c.State.PC + 1)
+		c.compare(c.State.A&c.State.X, value)
+		c.State.X = (c.State.A & c.State.X) - value
+		c.State.PC += 2
+		c.System.FrameCycles += 2
+	case 0xeb: // SBC #$00
+		c.sbc(addressMode)
+
+	case 0x1a, 0x3a, 0x5a, 0x7a, 0xda, 0xfa: // NOP
+		c.State.PC++
+		c.System.FrameCycles += 2
+	case 0x80, 0x82, 0x89, 0xc2, 0xe2, // NOP #$00
+		0x04, 0x44, 0x64, // NOP $00
+		0x14, 0x34, 0x54, 0x74, 0xd4, 0xf4, // NOP $00,X
+		0x0c,                               // NOP $0000
+		0x1c, 0x3c, 0x5c, 0x7c, 0xdc, 0xfc: // NOP $0000,X
+		// The memory is read as with any other instruction
+		_, pageBoundaryCrossed := c.readMemoryWithAddressMode(addressMode)
+		c.advanceCyclesForAcculumatorOperation(addressMode, pageBoundaryCrossed)
+
+	default:
+		return false
+	}
+
+	return true
+}
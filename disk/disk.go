@@ -2,15 +2,27 @@ package disk
 
 import (
 	"fmt"
-	"io/ioutil"
+	"math/rand"
+	"os"
 
 	"github.com/freewilll/apple2-go/system"
 )
 
+const drives = 2
+const quarterTracks = 160 // Number of quarter tracks the head can be on
 const tracksPerDisk = 35
 const sectorsPerTrack = 16
 const imageLength = tracksPerDisk * sectorsPerTrack * 0x100 // Number of bytes taken by a disk image
 
+// Geometry of 140K 16 sector disk images, for packages that work on the files
+// in them
+const (
+	TracksPerDisk   = tracksPerDisk
+	SectorsPerTrack = sectorsPerTrack
+	SectorLength    = 0x100
+	ImageLength     = imageLength
+)
+
 // Each sector has
 // Address field prologue               0x003 bytes
 // Volume, Track, Sector, Checksum      0x008 bytes
@@ -20,12 +32,38 @@ const imageLength = tracksPerDisk * sectorsPerTrack * 0x100 // Number of bytes t
 // 6-bits                               0x100 bytes
 // checksum                             0x001 byte
 // Data epilogue                        0x003 bytes
-const diskSectorBytes = 3 + 8 + 3 + 3 + 0x56 + 0x100 + 1 + 3 // Number of bytes one sector takes up on the disk
-const trackDataBytes = sectorsPerTrack * diskSectorBytes     // Number of bytes one track takes up on the disk
+const diskSectorBytes = 3 + 8 + 3 + 3 + 0x56 + 0x100 + 1 + 3 // Number of bytes one sector takes up on the disk, without gaps
+
+// A track is padded with $ff sync bytes to the 6250 bytes that pass under the
+// head in one revolution at 300 RPM. Gap 1 comes before the first sector, gap
+// 2 is between the address field and the data field of each sector and gap 3
+// follows each sector, taking up the rest of the track.
+const trackDataBytes = 6250 // Number of bytes one track takes up on the disk
+const gap1Bytes = 48
+const gap2Bytes = 6
+
+const nibTrackBytes = 6656                           // Number of bytes of a track in a NIB image
+const nibImageLength = tracksPerDisk * nibTrackBytes // Number of bytes taken by a NIB image
+
+// Conversion of a 6 bit byte to a 8 bit "disk" byte
+var sixTwoEncoding = [0x40]uint8{
+	0x96, 0x97, 0x9a, 0x9b, 0x9d, 0x9e, 0x9f, 0xa6,
+	0xa7, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb2, 0xb3,
+	0xb4, 0xb5, 0xb6, 0xb7, 0xb9, 0xba, 0xbb, 0xbc,
+	0xbd, 0xbe, 0xbf, 0xcb, 0xcd, 0xce, 0xcf, 0xd3,
+	0xd6, 0xd7, 0xd9, 0xda, 0xdb, 0xdc, 0xdd, 0xde,
+	0xdf, 0xe5, 0xe6, 0xe7, 0xe9, 0xea, 0xeb, 0xec,
+	0xed, 0xee, 0xef, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6,
+	0xf7, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe, 0xff,
+}
 
-var sectorInterleaving [16]uint8 // A map of physical to logical sector
-var sixTwoEncoding [0x40]uint8   // Conversion of a 6 bit byte to a 8 bit "disk" byte
-var sixTwoDecoding [0x100]uint8  // Conversion of a 8 bit "disk" byte to a 6 bit byte
+var sixTwoDecoding [0x100]uint8 // Conversion of a 8 bit "disk" byte to a 6 bit byte
+
+func init() {
+	for i := uint8(0); i < 0x40; i++ {
+		sixTwoDecoding[sixTwoEncoding[i]] = i
+	}
+}
 
 type sector struct {
 	data [0x100]uint8
@@ -39,18 +77,13 @@ type disk struct {
 	tracks [tracksPerDisk]track
 }
 
-var imagePath string                // Loaded disk image path
-var image disk                      // A loaded disk image
-var imageIsDirty bool               // If an image has been written to and needs a flush
-var trackData [trackDataBytes]uint8 // Converted image data as it it returned by the disk controller for a single track
-
 // vars to keep track of writes
 const (
 	waitingForDataPrologue byte = 1 + iota
 	receivingData
 )
 
-const rawDataBufferSize = diskSectorBytes + 16
+const rawDataBufferSize = diskSectorBytes13 + 16
 
 type addressField struct {
 	volume uint8
@@ -58,115 +91,457 @@ type addressField struct {
 	sector uint8
 }
 
-var lastReadAddress addressField
-var lastReadSectorDataPosition int
-
-// sectorWriteState keeps track of what write data has been received
-var sectorWriteState struct {
-	State           byte                     // waitingforDataPrologue or receivingData
-	RawData         [rawDataBufferSize]uint8 // data as it is being written
-	RawDataPosition uint16                   // position in RawData
-	Address         addressField             // address header of the last sector read
-}
-
-func resetsectorWriteState() {
-	sectorWriteState.State = waitingForDataPrologue
-	sectorWriteState.RawDataPosition = 0
-}
-
-// InitDiskImage sets up some mapping variables and resets the write state
-func InitDiskImage() {
-	// Map DOS 3.3 sector interleaving
-	// Physical sector -> Logical sector
-	sectorInterleaving[0x0] = 0x0
-	sectorInterleaving[0x1] = 0x7
-	sectorInterleaving[0x2] = 0xe
-	sectorInterleaving[0x3] = 0x6
-	sectorInterleaving[0x4] = 0xd
-	sectorInterleaving[0x5] = 0x5
-	sectorInterleaving[0x6] = 0xc
-	sectorInterleaving[0x7] = 0x4
-	sectorInterleaving[0x8] = 0xb
-	sectorInterleaving[0x9] = 0x3
-	sectorInterleaving[0xa] = 0xa
-	sectorInterleaving[0xb] = 0x2
-	sectorInterleaving[0xc] = 0x9
-	sectorInterleaving[0xd] = 0x1
-	sectorInterleaving[0xe] = 0x8
-	sectorInterleaving[0xf] = 0xf
-
-	// Zero disk image data
-	for t := 0; t < tracksPerDisk; t++ {
-		for s := 0; s < sectorsPerTrack; s++ {
-			for i := 0; i < 0x100; i++ {
-				image.tracks[t].sectors[s].data[i] = 0
-			}
-		}
+// drive is a disk drive with its own head and an optional disk image
+type drive struct {
+	imagePath     string                // Loaded disk image path, empty if there is no disk in the drive
+	image         disk                  // A loaded disk image, with the sectors in the same order as in the file
+	order         *sectorOrder          // Order of the sectors in the image
+	nib           []byte                // A loaded NIB image, nil for other images
+	twoIMG        *twoIMG               // The 2IMG file a sector or NIB image is in, nil for other images
+	volume        uint8                 // DOS volume number written in the address fields of a sector image
+	imageIsDirty  bool                  // If an image has been written to and needs a flush
+	imageIsLocked bool                  // If the image is write protected by its file permissions, WOZ or 2IMG header
+	writeMode     WriteMode             // Where writes to the image end up, the controller's mode when it was inserted
+	trackData     []uint8               // Data as it is returned by the disk controller for the track under the head
+	sectorTrack   [trackDataBytes]uint8 // Track converted from a sector image, trackData points at it
+	quarterTrack  int                   // Position of the head in quarter tracks
+
+	woz      *woz      // A loaded WOZ image, nil for other images
+	wozTrack *wozTrack // WOZ track under the head, nil if there is no data
+
+	bitPosition    int    // Position of the head on the current track in bits
+	lastCycle      uint64 // CPU cycle up to which the disk has been rotated
+	cycleRemainder uint64 // Part of a bit time left over from the last rotation, in 1/8 cycles
+}
+
+// Controller is the disk controller in slot 6 with two drives
+type Controller struct {
+	System *system.System
+
+	drives       [drives]drive
+	writeProtect [drives]bool // Drives that write protect any disk in them
+
+	writeMode WriteMode     // Where writes to images end up
+	autosave  autosaveTimer // When to autosave the images
+
+	lastReadAddress            addressField
+	lastReadSectorDataPosition int
+
+	// The sequencer state used to read and write WOZ tracks a bit at a time.
+	// Nibble tracks are always in sync, their data latch is worked out from
+	// the position of the head.
+	shiftRegister uint8      // Bits read since the last complete nibble
+	dataLatch     uint8      // Value returned by reads, the last complete nibble or the bits read so far
+	holdBits      int        // Number of bits a complete nibble stays in dataLatch
+	headWindow    uint8      // The last 4 bits under the head
+	writeRegister uint8      // Bits that are yet to be written
+	random        *rand.Rand // Random bits that are read when there are no flux changes
+
+	// sectorWriteState keeps track of what write data has been received
+	sectorWriteState struct {
+		State           byte                     // waitingforDataPrologue or receivingData
+		RawData         [rawDataBufferSize]uint8 // data as it is being written
+		RawDataPosition uint16                   // position in RawData
+		Address         addressField             // address header of the last sector read
 	}
+}
+
+// NewController creates a disk controller with two empty drives
+func NewController(s *system.System) *Controller {
+	c := &Controller{System: s, random: rand.New(rand.NewSource(1))}
+	c.InitDrives()
+	return c
+}
+
+func (c *Controller) resetsectorWriteState() {
+	c.sectorWriteState.State = waitingForDataPrologue
+	c.sectorWriteState.RawDataPosition = 0
+}
 
-	// Convert a 6 bit "byte" to a 8 bit "disk" byte
-	sixTwoEncoding = [0x40]uint8{
-		0x96, 0x97, 0x9a, 0x9b, 0x9d, 0x9e, 0x9f, 0xa6,
-		0xa7, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb2, 0xb3,
-		0xb4, 0xb5, 0xb6, 0xb7, 0xb9, 0xba, 0xbb, 0xbc,
-		0xbd, 0xbe, 0xbf, 0xcb, 0xcd, 0xce, 0xcf, 0xd3,
-		0xd6, 0xd7, 0xd9, 0xda, 0xdb, 0xdc, 0xdd, 0xde,
-		0xdf, 0xe5, 0xe6, 0xe7, 0xe9, 0xea, 0xeb, 0xec,
-		0xed, 0xee, 0xef, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6,
-		0xf7, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe, 0xff,
+// InitDrives empties both drives, moves the heads to track 0 and resets the
+// write state
+func (c *Controller) InitDrives() {
+	for i := range c.drives {
+		c.drives[i] = drive{}
+		c.drives[i].makeTrackData()
 	}
 
-	for i := uint8(0); i < 0x40; i++ {
-		sixTwoDecoding[sixTwoEncoding[i]] = i
+	c.resetsectorWriteState()
+}
+
+// selectedDrive returns the drive that's selected with $c0ea and $c0eb
+func (c *Controller) selectedDrive() *drive {
+	return &c.drives[c.System.DriveState.Drive-1]
+}
+
+// checkDrive panics if drive isn't 1 or 2
+func checkDrive(drive int) {
+	if drive < 1 || drive > drives {
+		panic(fmt.Sprintf("Invalid drive %d", drive))
 	}
+}
 
-	resetsectorWriteState()
+// ReadDiskImage reads a disk image from file into drive 1
+func (c *Controller) ReadDiskImage(path string) {
+	if err := c.InsertDiskImage(1, path); err != nil {
+		panic(fmt.Sprintf("Unable to read disk image: %s", err))
+	}
 }
 
-// ReadDiskImage reads a disk image from file
-func ReadDiskImage(path string) {
-	imagePath = path
+// InsertDiskImage reads a disk image from file into drive 1 or 2. Any image
+// already in the drive is ejected first. Writes go back to the file, or its
+// overlay, in the same sector order as the image was read in.
+func (c *Controller) InsertDiskImage(drive int, path string) error {
+	checkDrive(drive)
 
-	bytes, err := ioutil.ReadFile(path)
+	bytes, err := readImageFile(c.writeMode, path)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to read disk image: %s", err))
+		return err
+	}
+
+	image, err := parseDiskImage(path, bytes, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.EjectDiskImage(drive); err != nil {
+		return err
+	}
+
+	d := &c.drives[drive-1]
+	d.setImage(path, image)
+	d.writeMode = c.writeMode
+	d.imageIsDirty = false
+	d.imageIsLocked = (c.writeMode == WriteToImage && !isWritable(path)) || image.locked
+	d.makeTrackData()
+
+	return nil
+}
+
+// diskImage is a parsed disk image file
+type diskImage struct {
+	sectors []byte       // Sectors of a 13 or 16 sector image, nil for other images
+	order   *sectorOrder // Order of the sectors
+	nib     []byte       // A NIB image, nil for other images
+	woz     *woz         // A WOZ image, nil for other images
+	twoIMG  *twoIMG      // The 2IMG file a sector or NIB image is in
+	locked  bool         // If the WOZ or 2IMG header write protects the image
+	volume  uint8        // DOS volume number of a sector image
+}
+
+// parseDiskImage parses the bytes of a disk image file. A 16 sector image
+// without a 2IMG header is in order, or if order is nil, the order is
+// detected from its path and contents.
+func parseDiskImage(path string, bytes []byte, order *sectorOrder) (*diskImage, error) {
+	image := &diskImage{}
+
+	if isWOZ(bytes) {
+		w, err := parseWOZ(bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		image.woz = w
+		image.locked = w.writeProtected
+		return image, nil
+	}
+
+	data := bytes
+	if is2IMG(bytes) {
+		t, err := parse2IMG(bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		image.twoIMG = t
+		image.locked = t.locked
+		data = t.data()
+
+		expected := imageLength
+		if t.format == twoIMGNIB {
+			expected = nibImageLength
+		}
+		if len(data) != expected {
+			return nil, fmt.Errorf("2IMG disk data has invalid length %d, expected %d", len(data), expected)
+		}
+	} else if !validImageLength(len(bytes)) {
+		return nil, fmt.Errorf("Disk image has invalid length %d, expected %d, %d or %d", len(bytes), imageLength, imageLength13, nibImageLength)
 	}
 
-	if len(bytes) != imageLength {
-		panic(fmt.Sprintf("Disk image has invalid length %d, expected %d", len(bytes), imageLength))
+	switch {
+	case len(data) == nibImageLength:
+		image.nib = data
+		return image, nil
+	case len(data) == imageLength13:
+		image.order = &dos32Order
+	case image.twoIMG != nil && image.twoIMG.format == twoIMGDOSOrder:
+		image.order = &dosOrder
+	case image.twoIMG != nil:
+		image.order = &prodosOrder
+	case order != nil:
+		image.order = order
+	default:
+		image.order = detectSectorOrder(path, data)
 	}
 
+	image.sectors = data
+	image.volume = dosVolume(data, image.order)
+	if image.twoIMG != nil && image.twoIMG.volume >= 0 {
+		image.volume = uint8(image.twoIMG.volume)
+	}
+
+	return image, nil
+}
+
+// setImage puts a parsed image in the drive
+func (d *drive) setImage(path string, image *diskImage) {
+	d.imagePath = path
+	d.image = disk{}
+	d.order = image.order
+	d.nib = image.nib
+	d.woz = image.woz
+	d.wozTrack = nil
+	d.twoIMG = image.twoIMG
+	d.volume = image.volume
+
+	if image.sectors != nil {
+		d.setImageBytes(image.sectors)
+	}
+}
+
+// validImageLength returns true if length is the length of a 16 sector, 13
+// sector or NIB image
+func validImageLength(length int) bool {
+	return length == imageLength || length == imageLength13 || length == nibImageLength
+}
+
+// EjectDiskImage flushes the image in drive 1 or 2 if it's been written to
+// and empties the drive. The image stays in the drive if the flush fails.
+func (c *Controller) EjectDiskImage(drive int) error {
+	checkDrive(drive)
+
+	d := &c.drives[drive-1]
+	if err := c.flushImage(d); err != nil {
+		return err
+	}
+
+	d.setImage("", &diskImage{})
+	d.makeTrackData()
+
+	return nil
+}
+
+// SwapDiskImages swaps the images in drives 1 and 2. The heads stay where
+// they are.
+func (c *Controller) SwapDiskImages() {
+	d1 := &c.drives[0]
+	d2 := &c.drives[1]
+
+	d1.imagePath, d2.imagePath = d2.imagePath, d1.imagePath
+	d1.image, d2.image = d2.image, d1.image
+	d1.imageIsDirty, d2.imageIsDirty = d2.imageIsDirty, d1.imageIsDirty
+	d1.imageIsLocked, d2.imageIsLocked = d2.imageIsLocked, d1.imageIsLocked
+	d1.writeMode, d2.writeMode = d2.writeMode, d1.writeMode
+	d1.order, d2.order = d2.order, d1.order
+	d1.nib, d2.nib = d2.nib, d1.nib
+	d1.woz, d2.woz = d2.woz, d1.woz
+	d1.twoIMG, d2.twoIMG = d2.twoIMG, d1.twoIMG
+	d1.volume, d2.volume = d2.volume, d1.volume
+	d1.wozTrack, d2.wozTrack = nil, nil
+
+	d1.makeTrackData()
+	d2.makeTrackData()
+}
+
+// ImagePath returns the path of the image in drive 1 or 2, or an empty string
+// if the drive is empty
+func (c *Controller) ImagePath(drive int) string {
+	checkDrive(drive)
+	return c.drives[drive-1].imagePath
+}
+
+// SectorOrder returns the name of the sector order of the image in drive 1 or
+// 2, or an empty string if the drive is empty or has a NIB or WOZ image
+func (c *Controller) SectorOrder(drive int) string {
+	checkDrive(drive)
+
+	d := &c.drives[drive-1]
+	if d.imagePath == "" || d.nib != nil || d.woz != nil {
+		return ""
+	}
+
+	return d.order.name
+}
+
+// ImageFormat returns a description of the format of the image in drive 1 or
+// 2, or an empty string if the drive is empty
+func (c *Controller) ImageFormat(drive int) string {
+	checkDrive(drive)
+
+	d := &c.drives[drive-1]
+	switch {
+	case d.imagePath == "":
+		return ""
+	case d.woz != nil:
+		return fmt.Sprintf("WOZ %d", d.woz.version)
+	case d.twoIMG != nil && d.nib != nil:
+		return "2IMG NIB"
+	case d.twoIMG != nil:
+		return "2IMG " + d.order.name + " order"
+	case d.nib != nil:
+		return "NIB"
+	case d.order.sectors == sectorsPerTrack13:
+		return "DOS 3.2 13 sector"
+	default:
+		return d.order.name + " order"
+	}
+}
+
+// Volume returns the DOS volume number that's written in the address fields
+// of the image in drive 1 or 2, or 0 if the drive is empty or has a NIB or WOZ
+// image
+func (c *Controller) Volume(drive int) int {
+	checkDrive(drive)
+
+	d := &c.drives[drive-1]
+	if d.imagePath == "" || d.nib != nil || d.woz != nil {
+		return 0
+	}
+
+	return int(d.volume)
+}
+
+// ImageComment returns the comment in the 2IMG header of the image in drive 1
+// or 2, or an empty string if it doesn't have one
+func (c *Controller) ImageComment(drive int) string {
+	checkDrive(drive)
+
+	d := &c.drives[drive-1]
+	if d.twoIMG == nil {
+		return ""
+	}
+
+	return d.twoIMG.comment
+}
+
+// isWritable returns true if the file at path has write permissions and can
+// be opened for writing
+func isWritable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || (info.Mode().Perm()&0222) == 0 {
+		return false
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+
+	return true
+}
+
+// SetWriteProtected write protects drive 1 or 2, or removes the protection.
+// Images that are write protected by their file permissions or WOZ header
+// stay protected.
+func (c *Controller) SetWriteProtected(drive int, on bool) {
+	checkDrive(drive)
+	c.writeProtect[drive-1] = on
+}
+
+// WriteProtected returns true if the disk in drive 1 or 2 can't be written to
+func (c *Controller) WriteProtected(drive int) bool {
+	checkDrive(drive)
+	return c.writeProtect[drive-1] || c.drives[drive-1].imageIsLocked
+}
+
+// selectedDriveIsWriteProtected returns true if the disk in the selected drive
+// can't be written to
+func (c *Controller) selectedDriveIsWriteProtected() bool {
+	return c.WriteProtected(int(c.System.DriveState.Drive))
+}
+
+// Phase returns the position of the head of drive 1 or 2 in half tracks,
+// rounded down
+func (c *Controller) Phase(drive int) int8 {
+	checkDrive(drive)
+	return int8(c.drives[drive-1].quarterTrack / 2)
+}
+
+// QuarterTrack returns the position of the head of drive 1 or 2 in quarter
+// tracks
+func (c *Controller) QuarterTrack(drive int) int {
+	checkDrive(drive)
+	return c.drives[drive-1].quarterTrack
+}
+
+// imageBytes returns the disk image as it's stored in a file
+func (d *drive) imageBytes() []byte {
+	bytes := make([]byte, tracksPerDisk*d.order.sectors*0x100)
+
 	pos := 0
 	for t := 0; t < tracksPerDisk; t++ {
-		for s := 0; s < sectorsPerTrack; s++ {
+		for s := 0; s < d.order.sectors; s++ {
 			for i := 0; i < 0x100; i++ {
-				image.tracks[t].sectors[s].data[i] = bytes[pos]
+				bytes[pos] = byte(d.image.tracks[t].sectors[s].data[i])
 				pos++
 			}
 		}
 	}
 
-	imageIsDirty = false
+	return bytes
 }
 
-// writeDiskImage writes a disk image to file
-func writeDiskImage() {
-	bytes := make([]byte, tracksPerDisk*sectorsPerTrack*0x100)
-
+// setImageBytes replaces the disk image with bytes as they're stored in a file
+func (d *drive) setImageBytes(bytes []byte) {
 	pos := 0
 	for t := 0; t < tracksPerDisk; t++ {
-		for s := 0; s < sectorsPerTrack; s++ {
+		for s := 0; s < d.order.sectors; s++ {
 			for i := 0; i < 0x100; i++ {
-				bytes[pos] = byte(image.tracks[t].sectors[s].data[i])
+				d.image.tracks[t].sectors[s].data[i] = bytes[pos]
 				pos++
 			}
 		}
 	}
+}
 
-	err := ioutil.WriteFile(imagePath, bytes, 0644)
-	if err != nil {
-		panic(fmt.Sprintf("Unable to write disk image: %s", err))
+// fileBytes returns the image as it's stored in a file, whatever its format
+func (d *drive) fileBytes() []byte {
+	var bytes []byte
+	switch {
+	case d.nib != nil:
+		bytes = d.nib
+	case d.woz != nil:
+		return d.woz.bytes()
+	default:
+		bytes = d.imageBytes()
 	}
+
+	if d.twoIMG != nil {
+		return d.twoIMG.bytes(bytes)
+	}
+
+	return bytes
+}
+
+// flushImage writes the disk image file, or its overlay, if it's been written
+// to. Write protected images are never written.
+func (c *Controller) flushImage(d *drive) error {
+	if !d.imageIsDirty || d.imageIsLocked {
+		return nil
+	}
+
+	path := flushPath(d.writeMode, d.imagePath)
+	if path == "" {
+		return nil
+	}
+
+	if err := WriteFileAtomically(path, d.fileBytes()); err != nil {
+		return err
+	}
+
+	d.imageIsDirty = false
+	return nil
 }
 
 // Encode a byte into two 4-bit bytes with odd-even encoding. This is used
@@ -235,88 +610,247 @@ func sectorDataDecode(data []uint8) (sector [0x100]uint8) {
 	return
 }
 
-func clearTrackData() {
+func (d *drive) clearTrackData() {
 	for i := 0; i < trackDataBytes; i++ {
-		trackData[i] = 0
+		d.sectorTrack[i] = 0
+	}
+	d.trackData = d.sectorTrack[:]
+}
+
+// track returns the track of a sector or NIB image under the head, or -1 if
+// the head isn't on a track. Tracks are on every 4th quarter track and can
+// also be read from the quarter tracks next to them.
+func (d *drive) track() int {
+	if d.quarterTrack%4 == 2 {
+		return -1
 	}
+
+	track := (d.quarterTrack + 1) / 4
+	if track >= tracksPerDisk {
+		return -1
+	}
+
+	return track
+}
+
+// nibTrack returns the nibbles of the NIB image track under the head, or nil
+// if the head isn't on a track
+func (d *drive) nibTrack() []uint8 {
+	track := d.track()
+	if track < 0 {
+		return nil
+	}
+
+	return d.nib[track*nibTrackBytes : (track+1)*nibTrackBytes]
+}
+
+// sectorSpacing returns the number of bytes from the start of one sector to
+// the next, which is the sector with gaps 2 and 3
+func (d *drive) sectorSpacing() int {
+	return (trackDataBytes - gap1Bytes) / d.order.sectors
+}
+
+// addressPrologue returns the last byte of the address field prologue of the
+// sector image, d5 aa 96 for 16 sectors and d5 aa b5 for 13 sectors
+func (d *drive) addressPrologue() uint8 {
+	if d.order != nil && d.order.sectors == sectorsPerTrack13 {
+		return 0xb5
+	}
+
+	return 0x96
 }
 
 // makeSectorData converts the in-memory image data to disk encoded data in
-// trackData for a given track and sector.
-func makeSectorData(track uint8, physicalSector uint8) {
-	logicalSector := sectorInterleaving[physicalSector]
-	offset := int(physicalSector) * diskSectorBytes
+// sectorTrack for a given track and sector. 16 sector images use 6-and-2
+// encoding and 13 sector images 5-and-3 encoding.
+func (d *drive) makeSectorData(track uint8, physicalSector uint8) {
+	logicalSector := d.order.physicalToFile[physicalSector]
+	offset := gap1Bytes + int(physicalSector)*d.sectorSpacing()
 
-	volume := uint8(254) // Volume numbers aren't implemented
-	checksum := volume ^ track ^ uint8(physicalSector)
+	checksum := d.volume ^ track ^ uint8(physicalSector)
 
-	volL, volH := oddEvenEncode(volume)
+	volL, volH := oddEvenEncode(d.volume)
 	trL, trH := oddEvenEncode(track)
 	seL, seH := oddEvenEncode(uint8(physicalSector))
 	csL, csH := oddEvenEncode(checksum)
 
 	// Address field prologue
-	trackData[offset+0] = 0xd5
-	trackData[offset+1] = 0xaa
-	trackData[offset+2] = 0x96
+	d.sectorTrack[offset+0] = 0xd5
+	d.sectorTrack[offset+1] = 0xaa
+	d.sectorTrack[offset+2] = d.addressPrologue()
 
 	// Volume, track, sector and checksum
-	trackData[offset+3] = volL
-	trackData[offset+4] = volH
-	trackData[offset+5] = trL
-	trackData[offset+6] = trH
-	trackData[offset+7] = seL
-	trackData[offset+8] = seH
-	trackData[offset+9] = csL
-	trackData[offset+10] = csH
+	d.sectorTrack[offset+3] = volL
+	d.sectorTrack[offset+4] = volH
+	d.sectorTrack[offset+5] = trL
+	d.sectorTrack[offset+6] = trH
+	d.sectorTrack[offset+7] = seL
+	d.sectorTrack[offset+8] = seH
+	d.sectorTrack[offset+9] = csL
+	d.sectorTrack[offset+10] = csH
 
 	// Address epilogue
-	trackData[offset+11] = 0xde
-	trackData[offset+12] = 0xaa
-	trackData[offset+13] = 0xeb
+	d.sectorTrack[offset+11] = 0xde
+	d.sectorTrack[offset+12] = 0xaa
+	d.sectorTrack[offset+13] = 0xeb
+
+	// Data field prologue, after gap 2
+	offset += 14 + gap2Bytes
+	d.sectorTrack[offset+0] = 0xd5
+	d.sectorTrack[offset+1] = 0xaa
+	d.sectorTrack[offset+2] = 0xad
+
+	// The data and checksum, followed by the data epilogue
+	end := offset + 3
+	if d.order.sectors == sectorsPerTrack13 {
+		nibbles := fiveThreeEncode(d.image.tracks[track].sectors[logicalSector].data)
+		end += copy(d.sectorTrack[end:], nibbles[:])
+	} else {
+		sectorData := sectorDataEncode(d.image.tracks[track].sectors[logicalSector])
+
+		// a is the previous byte's value
+		a := uint8(0)
+		for i := 0; i < 0x56+0x100; i++ {
+			a ^= sectorData[i]
+			b := sixTwoEncoding[a]
+			d.sectorTrack[end+i] = b
+			a = sectorData[i]
+		}
 
-	// Data field prologue
-	trackData[offset+14] = 0xd5
-	trackData[offset+15] = 0xaa
-	trackData[offset+16] = 0xad
+		// Set the checksum byte
+		d.sectorTrack[end+0x56+0x100] = sixTwoEncoding[a]
+		end += 0x56 + 0x100 + 1
+	}
+
+	// Data epilogue
+	d.sectorTrack[end+0] = 0xde
+	d.sectorTrack[end+1] = 0xaa
+	d.sectorTrack[end+2] = 0xeb
+}
 
-	sectorData := sectorDataEncode(image.tracks[track].sectors[logicalSector])
+// makeTrackData makes disk encoded data for the whole track under the head.
+// There is no data between tracks, beyond the last track or if the drive is
+// empty. The nibbles of NIB images are used as they are and WOZ images have
+// their own map of quarter tracks. The head keeps its position relative to
+// the start of the track when the track lengths differ.
+func (d *drive) makeTrackData() {
+	oldBitCount := d.trackBitCount()
+	track := uint8(d.track())
+
+	switch {
+	case d.woz != nil:
+		d.wozTrack = d.woz.track(d.quarterTrack)
+
+	case d.imagePath == "" || d.track() < 0:
+		d.clearTrackData()
+
+	case d.nib != nil:
+		d.trackData = d.nibTrack()
+
+	default:
+		// Fill the track with sync bytes and encode each sector over them
+		d.trackData = d.sectorTrack[:gap1Bytes+d.order.sectors*d.sectorSpacing()]
+		for i := range d.trackData {
+			d.trackData[i] = 0xff
+		}
+		for physicalSector := uint8(0); int(physicalSector) < d.order.sectors; physicalSector++ {
+			d.makeSectorData(track, physicalSector)
+		}
+	}
 
-	// a is the previous byte's value
-	a := uint8(0)
-	for i := 0; i < 0x56+0x100; i++ {
-		a ^= sectorData[i]
-		b := sixTwoEncoding[a]
-		trackData[offset+17+i] = b
-		a = sectorData[i]
+	bitCount := d.trackBitCount()
+	if oldBitCount != 0 && bitCount != oldBitCount {
+		d.bitPosition = d.bitPosition * bitCount / oldBitCount
 	}
+	if d.woz == nil {
+		d.bitPosition &^= 7 // Stay on a nibble boundary
+	}
+	if d.bitPosition >= bitCount {
+		d.bitPosition = 0
+	}
+}
 
-	// Set the checksum byte
-	trackData[offset+17+0x56+0x100] = sixTwoEncoding[a]
+// trackBitCount returns the number of bits in the track under the head
+func (d *drive) trackBitCount() int {
+	if d.woz != nil {
+		if d.wozTrack == nil {
+			return 0
+		}
+		return d.wozTrack.bitCount
+	}
 
-	// Data epilogue
-	trackData[offset+17+0x56+0x100+1] = 0xde
-	trackData[offset+17+0x56+0x100+2] = 0xaa
-	trackData[offset+17+0x56+0x100+3] = 0xeb
+	return len(d.trackData) * 8
 }
 
-// MakeTrackData makes disk encoded data for a whole track when armPosition is even.
-func MakeTrackData(armPosition uint8) {
-	// Tracks are present on even arm positions.
-	track := uint8(armPosition / 2)
+// magnetPulls has the direction each of the 4 stepper motor magnets pulls the
+// head in. The magnets are 2 quarter tracks apart and repeat every 8 quarter
+// tracks, so they're at right angles to each other on a circle.
+var magnetPulls = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+// pullPositions has the quarter track on the circle that the sum of the pulls
+// of the magnets that are on moves the head to, indexed by x+1 and y+1. There
+// is no pull if the magnets cancel each other out.
+var pullPositions = [3][3]int{
+	{5, 4, 3},
+	{6, -1, 2},
+	{7, 0, 1},
+}
 
-	// If it's an odd arm position or a track beyond the image, zero the data
-	if (armPosition >= (tracksPerDisk * 2)) || ((armPosition % 2) == 1) {
-		clearTrackData()
-		return
+// SetPhase switches one of the 4 stepper motor magnets of the selected drive
+// on or off. The head moves to the closest quarter track that the magnets
+// that are on pull it to. A single magnet pulls the head onto a half track
+// and two adjacent magnets pull it onto the quarter track between them.
+// Returns true if the head has moved.
+func (c *Controller) SetPhase(magnet uint8, on bool) bool {
+	if on {
+		c.System.DriveState.Phases |= (1 << magnet)
+	} else {
+		c.System.DriveState.Phases &= ^(1 << magnet)
 	}
 
-	system.DriveState.BytePosition = 0 // Point the head at the first sector
+	x, y := 0, 0
+	for i, pull := range magnetPulls {
+		if (c.System.DriveState.Phases & (1 << uint(i))) != 0 {
+			x += pull[0]
+			y += pull[1]
+		}
+	}
 
-	// For each sector, encode the data and add it to trackData
-	for physicalSector := uint8(0); physicalSector < sectorsPerTrack; physicalSector++ {
-		makeSectorData(track, physicalSector)
+	position := pullPositions[x+1][y+1]
+	if position < 0 {
+		return false
+	}
+
+	d := c.selectedDrive()
+
+	// Move at most 3 quarter tracks. A magnet half way around the circle pulls
+	// the head both ways, so it stays put.
+	delta := (position - d.quarterTrack) & 7
+	if delta > 4 {
+		delta -= 8
+	}
+	if delta == 0 || delta == 4 {
+		return false
 	}
+
+	quarterTrack := d.quarterTrack + delta
+	if quarterTrack < 0 {
+		quarterTrack = 0
+	}
+	if quarterTrack >= quarterTracks {
+		quarterTrack = quarterTracks - 1
+	}
+	if quarterTrack == d.quarterTrack {
+		return false
+	}
+
+	// Catch up with the disk rotation before the head moves
+	c.Sync()
+
+	d.quarterTrack = quarterTrack
+	d.makeTrackData()
+
+	return true
 }
 
 // decodeAddressField decodes the 6 bytes from a disk encoded sector address
@@ -329,35 +863,64 @@ func decodeAddressField(data []uint8) addressField {
 	return af
 }
 
-// ReadTrackData reads a byte from the disk head and spins the disk along
-func ReadTrackData() (result uint8) {
-	result = trackData[system.DriveState.BytePosition]
+// ReadTrackData returns the data latch after rotating the disk in the selected
+// drive up to the current CPU cycle
+func (c *Controller) ReadTrackData() uint8 {
+	d := c.selectedDrive()
+	c.Sync()
+
+	if d.woz != nil {
+		return c.dataLatch
+	}
+
+	// A nibble is complete once its 8 bits have been read. It stays in the
+	// latch for two more bits, after that the latch has the bits of the next
+	// nibble read so far.
+	nibble := d.bitPosition / 8
+	bits := uint(d.bitPosition % 8)
 
 	// If the head is far along enough in the track, see if the head is on a
 	// sector header and decode it. This is used by the write code since the
 	// write code has to know what track and sector the head has just gone
 	// past.
-	if system.DriveState.BytePosition >= 9 {
-		if trackData[system.DriveState.BytePosition-9] == 0xd5 &&
-			trackData[system.DriveState.BytePosition-8] == 0xaa &&
-			trackData[system.DriveState.BytePosition-7] == 0x96 {
+	if nibble >= 9 {
+		if d.trackData[nibble-9] == 0xd5 &&
+			d.trackData[nibble-8] == 0xaa &&
+			d.trackData[nibble-7] == d.addressPrologue() {
 			var addressData []uint8
-			addressData = trackData[system.DriveState.BytePosition-6 : system.DriveState.BytePosition]
-			lastReadAddress = decodeAddressField(addressData)
-			lastReadSectorDataPosition = system.DriveState.BytePosition + 8
+			addressData = d.trackData[nibble-6 : nibble]
+			c.lastReadAddress = decodeAddressField(addressData)
+			c.lastReadSectorDataPosition = nibble + 8
 		}
 	}
 
-	// Go forward one byte and loop around.
-	system.DriveState.BytePosition++
-	if system.DriveState.BytePosition == trackDataBytes {
-		system.DriveState.BytePosition = 0
+	if bits < 2 {
+		if nibble == 0 {
+			nibble = len(d.trackData)
+		}
+		return d.trackData[nibble-1]
 	}
 
-	return
+	return d.trackData[nibble] >> (8 - bits)
+}
+
+// ReadStatus handles a read of the Q7 off address. With Q6 on, the high bit
+// has the write protect switch of the selected drive. Otherwise it's a read
+// of the data latch.
+func (c *Controller) ReadStatus() uint8 {
+	if !c.System.DriveState.Q6 {
+		return c.ReadTrackData()
+	}
+
+	if c.selectedDriveIsWriteProtected() {
+		return 0x80
+	}
+
+	return 0
 }
 
 // WriteTrackData gets called whenever a byte is written to the write address.
+// Writes to an empty drive or a write protected disk are ignored.
 // Reads are done at the same time by the OS to await the drive to be in the
 // right position. The last read address determines the track and sector. The expeted sequence of writes are:
 // - up to 5 bytes of 0xff padding (ignored)
@@ -368,62 +931,188 @@ func ReadTrackData() (result uint8) {
 // - data epilogue (ignored)
 //
 // The sector is decoded and updated in memory once the 0x156 data  bytes have
-// been read. The image is flagged as dirty and flushed on exit.
-func WriteTrackData(value uint8) {
-	if sectorWriteState.State == waitingForDataPrologue {
-		if sectorWriteState.RawDataPosition >= 16 {
-			resetsectorWriteState()
+// been read. The image is flagged as dirty and flushed on exit or eject.
+func (c *Controller) WriteTrackData(value uint8) {
+	d := c.selectedDrive()
+	if d.imagePath == "" {
+		return
+	}
+
+	c.Sync()
+
+	if d.woz != nil {
+		c.writeRegister = value
+		return
+	}
+
+	if c.selectedDriveIsWriteProtected() {
+		return
+	}
+
+	// Nibbles are written to NIB images as they are, over the nibble that is
+	// passing under the head
+	if d.nib != nil {
+		if t := d.nibTrack(); t != nil {
+			t[d.bitPosition/8] = value
+			d.imageIsDirty = true
+		}
+		return
+	}
+
+	if c.sectorWriteState.State == waitingForDataPrologue {
+		if c.sectorWriteState.RawDataPosition >= 16 {
+			c.resetsectorWriteState()
 			return
 		}
 
-		sectorWriteState.RawData[sectorWriteState.RawDataPosition] = value
-		sectorWriteState.RawDataPosition++
+		c.sectorWriteState.RawData[c.sectorWriteState.RawDataPosition] = value
+		c.sectorWriteState.RawDataPosition++
 
 		// Check for address prologue
-		if sectorWriteState.RawDataPosition > 2 && sectorWriteState.RawData[sectorWriteState.RawDataPosition-3] == 0xd5 &&
-			sectorWriteState.RawData[sectorWriteState.RawDataPosition-2] == 0xaa &&
-			sectorWriteState.RawData[sectorWriteState.RawDataPosition-1] == 0xad {
+		if c.sectorWriteState.RawDataPosition > 2 && c.sectorWriteState.RawData[c.sectorWriteState.RawDataPosition-3] == 0xd5 &&
+			c.sectorWriteState.RawData[c.sectorWriteState.RawDataPosition-2] == 0xaa &&
+			c.sectorWriteState.RawData[c.sectorWriteState.RawDataPosition-1] == 0xad {
 
 			// We got it, record the last read address field and reset RawDataPosition
-			sectorWriteState.State = receivingData
-			sectorWriteState.Address = lastReadAddress
-			sectorWriteState.RawDataPosition = 0
+			c.sectorWriteState.State = receivingData
+			c.sectorWriteState.Address = c.lastReadAddress
+			c.sectorWriteState.RawDataPosition = 0
 			return
 		}
 
-	} else if sectorWriteState.State == receivingData {
-		sectorWriteState.RawData[sectorWriteState.RawDataPosition] = value
-		sectorWriteState.RawDataPosition++
+	} else if c.sectorWriteState.State == receivingData {
+		c.sectorWriteState.RawData[c.sectorWriteState.RawDataPosition] = value
+		c.sectorWriteState.RawDataPosition++
+
+		dataBytes := 0x56 + 0x100
+		if d.order.sectors == sectorsPerTrack13 {
+			dataBytes = fiveThreeDataBytes
+		}
 
-		if sectorWriteState.RawDataPosition == 0x56+0x100 {
+		if int(c.sectorWriteState.RawDataPosition) == dataBytes {
 			// We have the full sector data
-			physicalSector := lastReadAddress.sector
-			logicalSector := sectorInterleaving[physicalSector]
-
-			// transform the data from disk bytes to 6-bytes and EOR it
-			a := uint8(0)
-			for i := 0; i < 0x56+0x100; i++ {
-				b := sixTwoDecoding[sectorWriteState.RawData[i]]
-				a ^= b
-				sectorWriteState.RawData[i] = a
+			physicalSector := c.lastReadAddress.sector
+			if int(physicalSector) >= d.order.sectors || int(c.lastReadAddress.track) >= tracksPerDisk {
+				c.resetsectorWriteState()
+				return
+			}
+			logicalSector := d.order.physicalToFile[physicalSector]
+
+			var sectorData [0x100]uint8
+			if d.order.sectors == sectorsPerTrack13 {
+				sectorData = fiveThreeDecode(c.sectorWriteState.RawData[:dataBytes])
+			} else {
+				// transform the data from disk bytes to 6-bytes and EOR it
+				a := uint8(0)
+				for i := 0; i < 0x56+0x100; i++ {
+					b := sixTwoDecoding[c.sectorWriteState.RawData[i]]
+					a ^= b
+					c.sectorWriteState.RawData[i] = a
+				}
+
+				// Transform the 0x156 bytes into the final 0x100 bytes
+				sectorData = sectorDataDecode(c.sectorWriteState.RawData[0:0x156])
 			}
-
-			// Transform the 0x156 bytes into the final 0x100 bytes
-			sectorData := sectorDataDecode(sectorWriteState.RawData[0:0x156])
 
 			// Save the data to memory & recreate the raw sector data
-			image.tracks[lastReadAddress.track].sectors[logicalSector].data = sectorData
-			makeSectorData(lastReadAddress.track, physicalSector)
+			d.image.tracks[c.lastReadAddress.track].sectors[logicalSector].data = sectorData
+			d.makeSectorData(c.lastReadAddress.track, physicalSector)
 
-			resetsectorWriteState()
-			imageIsDirty = true
+			c.resetsectorWriteState()
+			d.imageIsDirty = true
 		}
 	}
 }
 
-// FlushImage writes the disk image file if it's been written to.
-func FlushImage() {
-	if imageIsDirty {
-		writeDiskImage()
+// FlushImage writes the disk image files of both drives if they've been
+// written to. It returns the first error, after trying both drives.
+func (c *Controller) FlushImage() error {
+	var err error
+	for i := range c.drives {
+		if flushErr := c.flushImage(&c.drives[i]); flushErr != nil && err == nil {
+			err = flushErr
+		}
 	}
+
+	return err
+}
+
+// DriveState is the part of a drive that is kept in a save state
+type DriveState struct {
+	ImagePath     string // Path the image is flushed to, empty if there is no disk in the drive
+	Image         []byte // Contents of the image, including any unflushed writes
+	ImageIsDirty  bool   // If the image needs a flush
+	ImageIsLocked bool   // If the image is write protected by its file permissions, WOZ or 2IMG header
+	SectorOrder   string // Order of the sectors in Image, DOS 3.3 if empty
+	QuarterTrack  int    // Position of the head in quarter tracks
+	BitPosition   int    // Position of the head on the current track in bits
+}
+
+// State is the part of the disk controller that is kept in a save state
+type State struct {
+	Drives [drives]DriveState
+}
+
+// SaveState returns the disk controller's state
+func (c *Controller) SaveState() *State {
+	state := &State{}
+
+	for i := range c.drives {
+		d := &c.drives[i]
+		state.Drives[i] = DriveState{
+			ImagePath:     d.imagePath,
+			ImageIsDirty:  d.imageIsDirty,
+			ImageIsLocked: d.imageIsLocked,
+			QuarterTrack:  d.quarterTrack,
+			BitPosition:   d.bitPosition,
+		}
+
+		if d.imagePath != "" {
+			state.Drives[i].Image = append([]byte(nil), d.fileBytes()...)
+		}
+		if d.imagePath != "" && d.nib == nil && d.woz == nil {
+			state.Drives[i].SectorOrder = d.order.name
+		}
+	}
+
+	return state
+}
+
+// LoadState restores the disk controller's state. The images are parsed
+// first, so the controller is left alone if one of them is invalid.
+func (c *Controller) LoadState(state *State) error {
+	var images [drives]*diskImage
+	for i := range state.Drives {
+		ds := &state.Drives[i]
+		if ds.ImagePath == "" {
+			images[i] = &diskImage{}
+			continue
+		}
+
+		image, err := parseDiskImage(ds.ImagePath, append([]byte(nil), ds.Image...), sectorOrderByName(ds.SectorOrder))
+		if err != nil {
+			return fmt.Errorf("Unable to load the disk image in drive %d: %s", i+1, err)
+		}
+		images[i] = image
+	}
+
+	for i := range c.drives {
+		ds := &state.Drives[i]
+		d := &c.drives[i]
+
+		*d = drive{
+			imageIsDirty:  ds.ImageIsDirty,
+			imageIsLocked: ds.ImageIsLocked,
+			writeMode:     c.writeMode,
+			quarterTrack:  ds.QuarterTrack,
+			bitPosition:   ds.BitPosition,
+			lastCycle:     c.System.Cycles,
+		}
+		d.setImage(ds.ImagePath, images[i])
+
+		d.makeTrackData()
+	}
+
+	c.resetsectorWriteState()
+	return nil
 }
@@ -0,0 +1,112 @@
+package disk
+
+// DOS 3.2 disks have 13 sectors per track. A sector is encoded with 5-and-3
+// encoding: each byte is split into its top 5 bits and its bottom 3 bits,
+// which are written as 410 disk bytes of 5 bits each. The address field
+// prologue is d5 aa b5 instead of d5 aa 96.
+
+const sectorsPerTrack13 = 13
+const imageLength13 = tracksPerDisk * sectorsPerTrack13 * 0x100 // Number of bytes taken by a 13 sector disk image
+
+const fiveThreeChunk = 0x33                                          // Number of bytes in each of the 5 groups the first 255 bytes are split into
+const fiveThreeThrees = 3*fiveThreeChunk + 1                         // Number of disk bytes with the bottom 3 bits
+const fiveThreeDataBytes = fiveThreeThrees + 0x100                   // Number of disk bytes in a data field, without the checksum
+const diskSectorBytes13 = 3 + 8 + 3 + 3 + fiveThreeDataBytes + 1 + 3 // Number of bytes a sector of a 13 sector disk takes up on the disk
+
+// Conversion of a 5 bit byte to a 8 bit "disk" byte
+var fiveThreeEncoding = [0x20]uint8{
+	0xab, 0xad, 0xae, 0xaf, 0xb5, 0xb6, 0xb7, 0xba,
+	0xbb, 0xbd, 0xbe, 0xbf, 0xd6, 0xd7, 0xda, 0xdb,
+	0xdd, 0xde, 0xdf, 0xea, 0xeb, 0xed, 0xee, 0xef,
+	0xf5, 0xf6, 0xf7, 0xfa, 0xfb, 0xfd, 0xfe, 0xff,
+}
+
+var fiveThreeDecoding [0x100]uint8 // Conversion of a 8 bit "disk" byte to a 5 bit byte
+
+func init() {
+	for i := uint8(0); i < 0x20; i++ {
+		fiveThreeDecoding[fiveThreeEncoding[i]] = i
+	}
+}
+
+// fiveThreeEncode converts 256 sector bytes to the disk bytes of a data
+// field, followed by the checksum. Bytes 0-254 are split into 5 groups. The
+// top 5 bits of each byte go into the tops, the bottom 3 bits of the first
+// three groups go into the threes together with the bits of the last two
+// groups. Each disk byte is exclusive-ored with the previous one.
+func fiveThreeEncode(data [0x100]uint8) (nibbles [fiveThreeDataBytes + 1]uint8) {
+	var top [0x100]uint8
+	var threes [fiveThreeThrees]uint8
+
+	chunk := fiveThreeChunk - 1
+	for i := 0; i < fiveThreeChunk*5; i += 5 {
+		b := data[i : i+5]
+		for j := 0; j < 5; j++ {
+			top[chunk+j*fiveThreeChunk] = b[j] >> 3
+		}
+
+		threes[chunk] = (b[0]&7)<<2 | (b[3]&4)>>1 | (b[4]&4)>>2
+		threes[chunk+fiveThreeChunk] = (b[1]&7)<<2 | (b[3] & 2) | (b[4]&2)>>1
+		threes[chunk+2*fiveThreeChunk] = (b[2]&7)<<2 | (b[3]&1)<<1 | (b[4] & 1)
+		chunk--
+	}
+
+	top[0xff] = data[0xff] >> 3
+	threes[fiveThreeThrees-1] = data[0xff] & 7
+
+	// The threes are written backwards, followed by the tops
+	previous := uint8(0)
+	n := 0
+	for i := fiveThreeThrees - 1; i >= 0; i-- {
+		nibbles[n] = fiveThreeEncoding[threes[i]^previous]
+		previous = threes[i]
+		n++
+	}
+	for i := 0; i < 0x100; i++ {
+		nibbles[n] = fiveThreeEncoding[top[i]^previous]
+		previous = top[i]
+		n++
+	}
+
+	nibbles[n] = fiveThreeEncoding[previous]
+
+	return
+}
+
+// fiveThreeDecode converts the disk bytes of a data field, without the
+// checksum, to 256 sector bytes
+func fiveThreeDecode(nibbles []uint8) (data [0x100]uint8) {
+	var top [0x100]uint8
+	var threes [fiveThreeThrees]uint8
+
+	previous := uint8(0)
+	n := 0
+	for i := fiveThreeThrees - 1; i >= 0; i-- {
+		previous ^= fiveThreeDecoding[nibbles[n]]
+		threes[i] = previous
+		n++
+	}
+	for i := 0; i < 0x100; i++ {
+		previous ^= fiveThreeDecoding[nibbles[n]]
+		top[i] = previous
+		n++
+	}
+
+	chunk := fiveThreeChunk - 1
+	for i := 0; i < fiveThreeChunk*5; i += 5 {
+		t0 := threes[chunk]
+		t1 := threes[chunk+fiveThreeChunk]
+		t2 := threes[chunk+2*fiveThreeChunk]
+
+		data[i] = top[chunk]<<3 | t0>>2
+		data[i+1] = top[chunk+fiveThreeChunk]<<3 | t1>>2
+		data[i+2] = top[chunk+2*fiveThreeChunk]<<3 | t2>>2
+		data[i+3] = top[chunk+3*fiveThreeChunk]<<3 | (t0&2)<<1 | (t1 & 2) | (t2&2)>>1
+		data[i+4] = top[chunk+4*fiveThreeChunk]<<3 | (t0&1)<<2 | (t1&1)<<1 | (t2 & 1)
+		chunk--
+	}
+
+	data[0xff] = top[0xff]<<3 | threes[fiveThreeThrees-1]
+
+	return
+}
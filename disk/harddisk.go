@@ -0,0 +1,454 @@
+package disk
+
+// The hard disk card is a ProDOS block device with two drives. Its slot ROM
+// boots from drive 1 and has a ProDOS driver entry point, which hands the
+// command in $42-$47 to the emulator by writing to the card's first IO
+// address. The emulator then moves the block to or from memory in one go and
+// the driver picks up the result from the other IO addresses.
+
+import (
+	"fmt"
+	"time"
+)
+
+const blockLength = 512                    // Number of bytes in a ProDOS block
+const maxHardDiskLength = 32 * 1024 * 1024 // Largest hard disk image, the most a ProDOS volume can hold
+const maxHardDiskBlocks = 0xffff           // ProDOS block numbers are 16 bits
+
+// ProDOS block device commands in $42
+const (
+	blockStatus = 0
+	blockRead   = 1
+	blockWrite  = 2
+	blockFormat = 3
+)
+
+// ProDOS error codes returned by the driver
+const (
+	proDOSNoError        = 0x00
+	proDOSBadCall        = 0x01
+	proDOSIOError        = 0x27
+	proDOSNoDevice       = 0x28
+	proDOSWriteProtected = 0x2b
+)
+
+// Zero page addresses of the parameters of a ProDOS block device call
+const (
+	hardDiskCommandZP     = 0x42 // The command
+	hardDiskUnitZP        = 0x43 // The unit number, drive 2 if bit 7 is set
+	hardDiskBufferZP      = 0x44 // Address of the buffer
+	hardDiskBlockNumberZP = 0x46 // Block number
+)
+
+const hardDiskDriverOffset = 0x80 // Offset of the ProDOS driver in the slot ROM
+const hardDiskStatusByte = 0x17   // Two volumes that can be read, written and queried for their status
+
+// Registers of the card relative to its first IO address, $C080 + slot * $10
+const (
+	HardDiskCommandRegister    = 0 // A write runs the command in $42-$47
+	HardDiskBlocksLowRegister  = 1 // Low byte of the number of blocks after a status command
+	HardDiskBlocksHighRegister = 2 // High byte of the number of blocks after a status command
+	HardDiskErrorRegister      = 3 // ProDOS error code of the last command, zero if it succeeded
+)
+
+// Memory is the address space the hard disk card transfers blocks to and from
+type Memory interface {
+	ReadMemory(address uint16) uint8
+	WriteMemory(address uint16, value uint8)
+}
+
+// hardDiskDrive is one of the drives of the hard disk card
+type hardDiskDrive struct {
+	imagePath     string    // Loaded image path, empty if there is no image in the drive
+	file          []byte    // The image file, including any 2IMG header
+	blocks        []byte    // The blocks in file
+	imageIsDirty  bool      // If the image has been written to and needs a flush
+	imageIsLocked bool      // If the image is write protected by its file permissions or 2IMG header
+	writeMode     WriteMode // Where writes to the image end up, the card's mode when it was inserted
+}
+
+// HardDisk is a ProDOS block device card with two drives that hold .po, .hdv
+// or .2mg images of up to 32MB
+type HardDisk struct {
+	drives [drives]hardDiskDrive
+
+	writeMode WriteMode     // Where writes to images end up
+	autosave  autosaveTimer // When to autosave the images
+
+	blockCount uint16 // Number of blocks returned by the last status command
+	errorCode  uint8  // ProDOS error code of the last command
+}
+
+// NewHardDisk creates a hard disk card with two empty drives
+func NewHardDisk() *HardDisk {
+	return &HardDisk{}
+}
+
+// HardDiskROM returns the slot ROM of a hard disk card in a slot. The bytes
+// at $Cn01, $Cn03, $Cn05 and $Cn07 make the autostart ROM boot from it and
+// the bytes at $CnFC-$CnFF tell ProDOS where the driver is.
+func HardDiskROM(slot int) [0x100]uint8 {
+	var rom [0x100]uint8
+
+	unit := uint8(slot << 4)
+	page := uint8(0xc0 + slot)
+	io := uint8(0x80 + slot<<4)
+
+	boot := []uint8{
+		// ID bytes
+		0xa2, 0x20, // LDX #$20
+		0xa0, 0x00, // LDY #$00
+		0xa2, 0x03, // LDX #$03
+		0xa2, 0x3c, // LDX #$3C
+
+		// Read block 0 of drive 1 into $0800 and run it. The boot block
+		// finds the slot in X.
+		0xa9, blockRead, // LDA #$01
+		0x85, hardDiskCommandZP, // STA $42
+		0xa9, unit, // LDA #$n0
+		0x85, hardDiskUnitZP, // STA $43
+		0xa9, 0x00, // LDA #$00
+		0x85, hardDiskBufferZP, // STA $44
+		0x85, hardDiskBlockNumberZP, // STA $46
+		0x85, hardDiskBlockNumberZP + 1, // STA $47
+		0xa9, 0x08, // LDA #$08
+		0x85, hardDiskBufferZP + 1, // STA $45
+		0x20, hardDiskDriverOffset, page, // JSR $Cn80
+		0xb0, 0x05, // BCS FAIL
+		0xa2, unit, // LDX #$n0
+		0x4c, 0x01, 0x08, // JMP $0801
+
+		// FAIL: carry on with the next slot at SLOOP if the autostart ROM's
+		// slot scan got here, otherwise go to BASIC
+		0xa5, 0x00, // LDA $00
+		0xd0, 0x09, // BNE BASIC
+		0xa5, 0x01, // LDA $01
+		0xc9, page, // CMP #$Cn
+		0xd0, 0x03, // BNE BASIC
+		0x4c, 0xba, 0xfa, // JMP $FABA
+		0x4c, 0x00, 0xe0, // BASIC: JMP $E000
+	}
+
+	// The driver sets the carry if there was an error
+	driver := []uint8{
+		0x8d, io + HardDiskCommandRegister, 0xc0, // STA $C0n0
+		0xae, io + HardDiskBlocksLowRegister, 0xc0, // LDX $C0n1
+		0xac, io + HardDiskBlocksHighRegister, 0xc0, // LDY $C0n2
+		0xad, io + HardDiskErrorRegister, 0xc0, // LDA $C0n3
+		0xc9, 0x01, // CMP #$01
+		0x60, // RTS
+	}
+
+	copy(rom[:], boot)
+	copy(rom[hardDiskDriverOffset:], driver)
+
+	// $CnFC-$CnFD is the number of blocks, zero means it's returned by the
+	// status command
+	rom[0xfe] = hardDiskStatusByte
+	rom[0xff] = hardDiskDriverOffset
+
+	return rom
+}
+
+// checkHardDiskBlocks returns an error if blocks can't be used as a hard
+// disk
+func checkHardDiskBlocks(blocks []byte) error {
+	if len(blocks) == 0 || len(blocks)%blockLength != 0 || len(blocks) > maxHardDiskLength {
+		return fmt.Errorf("Hard disk image has invalid length %d, expected a multiple of %d up to %d", len(blocks), blockLength, maxHardDiskLength)
+	}
+
+	return nil
+}
+
+// loadHardDiskImage returns the blocks of a .po, .hdv or .2mg image and
+// whether its header write protects it
+func loadHardDiskImage(bytes []byte) ([]byte, bool, error) {
+	blocks, locked := bytes, false
+	if is2IMG(bytes) {
+		t, err := parse2IMG(bytes)
+		if err != nil {
+			return nil, false, err
+		}
+		if t.format != twoIMGProDOSOrder {
+			return nil, false, fmt.Errorf("Unsupported 2IMG image format %d, expected ProDOS order", t.format)
+		}
+		blocks, locked = t.data(), t.locked
+	}
+
+	if err := checkHardDiskBlocks(blocks); err != nil {
+		return nil, false, err
+	}
+
+	return blocks, locked, nil
+}
+
+// InsertImage reads a hard disk image from file into drive 1 or 2. Any image
+// already in the drive is ejected first.
+func (h *HardDisk) InsertImage(drive int, path string) error {
+	checkDrive(drive)
+
+	bytes, err := readImageFile(h.writeMode, path)
+	if err != nil {
+		return err
+	}
+
+	blocks, locked, err := loadHardDiskImage(bytes)
+	if err != nil {
+		return err
+	}
+
+	if err := h.EjectImage(drive); err != nil {
+		return err
+	}
+
+	h.drives[drive-1] = hardDiskDrive{
+		imagePath:     path,
+		file:          bytes,
+		blocks:        blocks,
+		imageIsLocked: locked || (h.writeMode == WriteToImage && !isWritable(path)),
+		writeMode:     h.writeMode,
+	}
+
+	return nil
+}
+
+// EjectImage flushes the image in drive 1 or 2 if it's been written to and
+// empties the drive. The image stays in the drive if the flush fails.
+func (h *HardDisk) EjectImage(drive int) error {
+	checkDrive(drive)
+
+	d := &h.drives[drive-1]
+	if err := h.flushImage(d); err != nil {
+		return err
+	}
+
+	*d = hardDiskDrive{}
+	return nil
+}
+
+// ImagePath returns the path of the image in drive 1 or 2, or an empty string
+// if the drive is empty
+func (h *HardDisk) ImagePath(drive int) string {
+	checkDrive(drive)
+	return h.drives[drive-1].imagePath
+}
+
+// BlockCount returns the number of blocks of the image in drive 1 or 2, zero
+// if the drive is empty
+func (h *HardDisk) BlockCount(drive int) int {
+	checkDrive(drive)
+	return h.drives[drive-1].blockCount()
+}
+
+// WriteProtected returns true if the image in drive 1 or 2 can't be written to
+func (h *HardDisk) WriteProtected(drive int) bool {
+	checkDrive(drive)
+	return h.drives[drive-1].imageIsLocked
+}
+
+// blockCount returns the number of blocks in the drive's image
+func (d *hardDiskDrive) blockCount() int {
+	count := len(d.blocks) / blockLength
+	if count > maxHardDiskBlocks {
+		count = maxHardDiskBlocks
+	}
+
+	return count
+}
+
+// SetWriteMode sets where writes to hard disk images end up. It applies to
+// images inserted after the call.
+func (h *HardDisk) SetWriteMode(mode WriteMode) {
+	h.writeMode = mode
+}
+
+// SetAutosaveInterval makes Autosave flush the images at most once per
+// interval. Zero turns autosaving off.
+func (h *HardDisk) SetAutosaveInterval(interval time.Duration) {
+	h.autosave.set(interval)
+}
+
+// Autosave flushes the images if the autosave interval has passed since the
+// last autosave. It's called once per frame.
+func (h *HardDisk) Autosave() error {
+	if !h.autosave.due() {
+		return nil
+	}
+
+	return h.FlushImages()
+}
+
+// FlushImages writes the image files of both drives if they've been written
+// to. It returns the first error, after trying both drives.
+func (h *HardDisk) FlushImages() error {
+	var err error
+	for i := range h.drives {
+		if flushErr := h.flushImage(&h.drives[i]); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+
+	return err
+}
+
+// flushImage writes a drive's image file, or its overlay, if it's been
+// written to
+func (h *HardDisk) flushImage(d *hardDiskDrive) error {
+	if !d.imageIsDirty || d.imageIsLocked {
+		return nil
+	}
+
+	path := flushPath(d.writeMode, d.imagePath)
+	if path == "" {
+		return nil
+	}
+
+	if err := WriteFileAtomically(path, d.file); err != nil {
+		return err
+	}
+
+	d.imageIsDirty = false
+	return nil
+}
+
+// Execute runs the ProDOS block device command in $42-$47. Blocks are read
+// into and written from mem. The result is left in the registers.
+func (h *HardDisk) Execute(mem Memory) {
+	command := mem.ReadMemory(hardDiskCommandZP)
+	unit := mem.ReadMemory(hardDiskUnitZP)
+	buffer := uint16(mem.ReadMemory(hardDiskBufferZP)) | uint16(mem.ReadMemory(hardDiskBufferZP+1))<<8
+	block := int(mem.ReadMemory(hardDiskBlockNumberZP)) | int(mem.ReadMemory(hardDiskBlockNumberZP+1))<<8
+
+	h.blockCount = 0
+	h.errorCode = h.execute(mem, &h.drives[unit>>7], command, buffer, block)
+}
+
+// execute runs a command on a drive and returns the ProDOS error code
+func (h *HardDisk) execute(mem Memory, d *hardDiskDrive, command uint8, buffer uint16, block int) uint8 {
+	if d.imagePath == "" {
+		return proDOSNoDevice
+	}
+
+	switch command {
+	case blockStatus:
+		h.blockCount = uint16(d.blockCount())
+		if d.imageIsLocked {
+			return proDOSWriteProtected
+		}
+		return proDOSNoError
+
+	case blockRead:
+		if block >= d.blockCount() {
+			return proDOSIOError
+		}
+
+		data := d.blocks[block*blockLength : (block+1)*blockLength]
+		for i, b := range data {
+			mem.WriteMemory(buffer+uint16(i), b)
+		}
+		return proDOSNoError
+
+	case blockWrite:
+		if block >= d.blockCount() {
+			return proDOSIOError
+		}
+		if d.imageIsLocked {
+			return proDOSWriteProtected
+		}
+
+		data := d.blocks[block*blockLength : (block+1)*blockLength]
+		for i := range data {
+			data[i] = mem.ReadMemory(buffer + uint16(i))
+		}
+		d.imageIsDirty = true
+		return proDOSNoError
+
+	case blockFormat:
+		// Images don't need formatting
+		if d.imageIsLocked {
+			return proDOSWriteProtected
+		}
+		return proDOSNoError
+
+	default:
+		return proDOSBadCall
+	}
+}
+
+// ReadRegister returns the value of one of the card's registers
+func (h *HardDisk) ReadRegister(register int) uint8 {
+	switch register {
+	case HardDiskBlocksLowRegister:
+		return uint8(h.blockCount)
+	case HardDiskBlocksHighRegister:
+		return uint8(h.blockCount >> 8)
+	case HardDiskErrorRegister:
+		return h.errorCode
+	default:
+		return 0
+	}
+}
+
+// HardDiskDriveState is the part of a hard disk drive that is kept in a save
+// state
+type HardDiskDriveState struct {
+	ImagePath     string // Path the image is flushed to, empty if there is no image in the drive
+	Image         []byte // Contents of the image file, including any unflushed writes
+	ImageIsDirty  bool   // If the image needs a flush
+	ImageIsLocked bool   // If the image is write protected by its file permissions or 2IMG header
+}
+
+// HardDiskState is the part of the hard disk card that is kept in a save
+// state
+type HardDiskState struct {
+	Drives [drives]HardDiskDriveState
+}
+
+// SaveState returns the hard disk card's state
+func (h *HardDisk) SaveState() *HardDiskState {
+	state := &HardDiskState{}
+
+	for i := range h.drives {
+		d := &h.drives[i]
+		state.Drives[i] = HardDiskDriveState{
+			ImagePath:     d.imagePath,
+			Image:         append([]byte(nil), d.file...),
+			ImageIsDirty:  d.imageIsDirty,
+			ImageIsLocked: d.imageIsLocked,
+		}
+	}
+
+	return state
+}
+
+// LoadState restores the hard disk card's state. The card is left alone if
+// one of the images is invalid.
+func (h *HardDisk) LoadState(state *HardDiskState) error {
+	var drives [drives]hardDiskDrive
+	for i := range state.Drives {
+		ds := &state.Drives[i]
+		drives[i] = hardDiskDrive{
+			imagePath:     ds.ImagePath,
+			imageIsDirty:  ds.ImageIsDirty,
+			imageIsLocked: ds.ImageIsLocked,
+			writeMode:     h.writeMode,
+		}
+
+		if ds.ImagePath == "" {
+			continue
+		}
+
+		file := append([]byte(nil), ds.Image...)
+		blocks, _, err := loadHardDiskImage(file)
+		if err != nil {
+			return fmt.Errorf("Unable to load the hard disk image in drive %d: %s", i+1, err)
+		}
+		drives[i].file = file
+		drives[i].blocks = blocks
+	}
+
+	h.drives = drives
+	h.blockCount = 0
+	h.errorCode = 0
+	return nil
+}
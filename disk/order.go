@@ -0,0 +1,201 @@
+package disk
+
+// Disk images store the sectors of each track in either DOS 3.3 or ProDOS
+// logical order. The order is taken from the file extension: .do for DOS 3.3
+// and .po for ProDOS. For other extensions like .dsk, the order is detected by
+// looking for a DOS 3.3 catalog or a ProDOS volume directory. 13 sector DOS
+// 3.2 images store the sectors in physical order. The volume number that goes
+// in the address fields is taken from the DOS VTOC.
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const defaultVolume = 254 // Volume number of images without a DOS VTOC, the DOS default
+
+// sectorOrder maps physical sectors to the order they're stored in an image file
+type sectorOrder struct {
+	name           string
+	sectors        int // Number of sectors per track
+	physicalToFile [sectorsPerTrack]uint8
+}
+
+var (
+	// dosOrder is the DOS 3.3 sector interleaving, a map of physical to logical sector
+	dosOrder = sectorOrder{"DOS 3.3", sectorsPerTrack, [sectorsPerTrack]uint8{
+		0x0, 0x7, 0xe, 0x6, 0xd, 0x5, 0xc, 0x4,
+		0xb, 0x3, 0xa, 0x2, 0x9, 0x1, 0x8, 0xf,
+	}}
+
+	// prodosOrder is the ProDOS sector interleaving, a map of physical to logical sector
+	prodosOrder = sectorOrder{"ProDOS", sectorsPerTrack, [sectorsPerTrack]uint8{
+		0x0, 0x8, 0x1, 0x9, 0x2, 0xa, 0x3, 0xb,
+		0x4, 0xc, 0x5, 0xd, 0x6, 0xe, 0x7, 0xf,
+	}}
+
+	// dos32Order is the order of 13 sector images, which have no interleaving
+	dos32Order = sectorOrder{"DOS 3.2", sectorsPerTrack13, [sectorsPerTrack]uint8{
+		0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7,
+		0x8, 0x9, 0xa, 0xb, 0xc,
+	}}
+
+	sectorOrders = []*sectorOrder{&dosOrder, &prodosOrder} // The orders of 16 sector images
+)
+
+// toPhysical returns the physical sector of a logical sector
+func (o *sectorOrder) toPhysical(logicalSector uint8) uint8 {
+	for p, l := range o.physicalToFile {
+		if l == logicalSector {
+			return uint8(p)
+		}
+	}
+
+	panic("Invalid logical sector")
+}
+
+// sectorOrderByName returns the sector order with a name. An empty name is
+// the DOS 3.3 order.
+func sectorOrderByName(name string) *sectorOrder {
+	for _, o := range append(sectorOrders, &dos32Order) {
+		if o.name == name {
+			return o
+		}
+	}
+
+	return &dosOrder
+}
+
+// imageSector returns the 256 bytes of a sector in an image stored in
+// fileOrder. The sector is numbered in the logicalOrder's numbering.
+func imageSector(bytes []byte, fileOrder *sectorOrder, logicalOrder *sectorOrder, track uint8, logicalSector uint8) []byte {
+	fileSector := int(fileOrder.physicalToFile[logicalOrder.toPhysical(logicalSector)])
+	offset := (int(track)*sectorsPerTrack + fileSector) * 0x100
+	return bytes[offset : offset+0x100]
+}
+
+// hasDOSCatalog returns true if an image stored in fileOrder has a DOS 3.3
+// VTOC on track 17 with at least 3 linked catalog sectors
+func hasDOSCatalog(bytes []byte, fileOrder *sectorOrder) bool {
+	vtoc := imageSector(bytes, fileOrder, &dosOrder, 17, 0)
+
+	if vtoc[0x27] != 122 || vtoc[0x34] != tracksPerDisk || vtoc[0x35] != sectorsPerTrack {
+		return false
+	}
+
+	track, sector := vtoc[0x01], vtoc[0x02]
+	for i := 0; i < 3; i++ {
+		if track == 0 || int(track) >= tracksPerDisk || int(sector) >= sectorsPerTrack {
+			return false
+		}
+
+		catalog := imageSector(bytes, fileOrder, &dosOrder, track, sector)
+		nextTrack, nextSector := catalog[0x01], catalog[0x02]
+
+		// DOS 3.3 lays out the catalog backwards on the same track
+		if nextTrack != track || nextSector+1 != sector {
+			return false
+		}
+
+		track, sector = nextTrack, nextSector
+	}
+
+	return true
+}
+
+// prodosBlock returns the 512 bytes of a block in an image stored in fileOrder
+func prodosBlock(bytes []byte, fileOrder *sectorOrder, block int) []byte {
+	track := uint8(block / 8)
+	sector := uint8(block%8) * 2
+
+	data := make([]byte, 0, 0x200)
+	data = append(data, imageSector(bytes, fileOrder, &prodosOrder, track, sector)...)
+	data = append(data, imageSector(bytes, fileOrder, &prodosOrder, track, sector+1)...)
+	return data
+}
+
+// hasProDOSVolumeDirectory returns true if an image stored in fileOrder has a
+// ProDOS volume directory header in block 2, linked to block 3
+func hasProDOSVolumeDirectory(bytes []byte, fileOrder *sectorOrder) bool {
+	key := prodosBlock(bytes, fileOrder, 2)
+	next := prodosBlock(bytes, fileOrder, 3)
+
+	return key[0x00] == 0 && key[0x01] == 0 && // No previous block
+		key[0x02] == 3 && key[0x03] == 0 && // Next block
+		(key[0x04]&0xf0) == 0xf0 && // Volume directory header storage type
+		key[0x23] == 0x27 && key[0x24] == 0x0d && // Entry length and entries per block
+		next[0x00] == 2 && next[0x01] == 0 // The next block links back
+}
+
+// detectSectorOrder returns the sector order of an image from the extension
+// of its path or, failing that, by looking for a DOS 3.3 catalog or a ProDOS
+// volume directory. DOS 3.3 order is used if neither is found.
+func detectSectorOrder(path string, bytes []byte) *sectorOrder {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".do":
+		return &dosOrder
+	case ".po":
+		return &prodosOrder
+	}
+
+	for _, o := range sectorOrders {
+		if hasDOSCatalog(bytes, o) || hasProDOSVolumeDirectory(bytes, o) {
+			return o
+		}
+	}
+
+	return &dosOrder
+}
+
+// dosVolume returns the volume number in the DOS VTOC of a 13 or 16 sector
+// image stored in fileOrder, or 254 if there isn't a VTOC. The VTOC is in
+// sector 0 of track 17, which comes first in the track in all orders.
+func dosVolume(bytes []byte, fileOrder *sectorOrder) uint8 {
+	offset := 17 * fileOrder.sectors * 0x100
+	vtoc := bytes[offset : offset+0x100]
+
+	if vtoc[0x06] == 0 || vtoc[0x27] != 122 || vtoc[0x34] != tracksPerDisk || int(vtoc[0x35]) != fileOrder.sectors {
+		return defaultVolume
+	}
+
+	return vtoc[0x06]
+}
+
+// reorder returns a copy of a 16 sector image with its sectors moved from
+// one order to another
+func reorder(bytes []byte, from *sectorOrder, to *sectorOrder) []byte {
+	reordered := make([]byte, len(bytes))
+	for t := 0; t < tracksPerDisk; t++ {
+		for p := 0; p < sectorsPerTrack; p++ {
+			fromOffset := (t*sectorsPerTrack + int(from.physicalToFile[p])) * 0x100
+			toOffset := (t*sectorsPerTrack + int(to.physicalToFile[p])) * 0x100
+			copy(reordered[toOffset:toOffset+0x100], bytes[fromOffset:fromOffset+0x100])
+		}
+	}
+
+	return reordered
+}
+
+// ProDOSOrderBlocks returns the blocks of a ProDOS volume in an image file.
+// The sectors of a 140K image in DOS 3.3 order are rearranged, its order is
+// detected like when it's inserted into a drive. Other images are returned as
+// they are. dosOrdered is true if the sectors were rearranged.
+func ProDOSOrderBlocks(path string, bytes []byte) (blocks []byte, dosOrdered bool) {
+	if len(bytes) != imageLength || detectSectorOrder(path, bytes) != &dosOrder {
+		return bytes, false
+	}
+
+	return reorder(bytes, &dosOrder, &prodosOrder), true
+}
+
+// DOSOrderImage returns the sectors of a 140K ProDOS volume's blocks in DOS
+// 3.3 order
+func DOSOrderImage(blocks []byte) []byte {
+	return reorder(blocks, &prodosOrder, &dosOrder)
+}
+
+// ProDOSOrderImage returns the sectors of a 140K image in DOS 3.3 order in
+// ProDOS order
+func ProDOSOrderImage(bytes []byte) []byte {
+	return reorder(bytes, &dosOrder, &prodosOrder)
+}
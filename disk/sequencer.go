@@ -0,0 +1,147 @@
+package disk
+
+// The disk rotates in step with the CPU, a bit passes under the head every 4
+// cycles. Rather than rotating the disk on every cycle, it's caught up with the
+// CPU whenever the controller is accessed.
+
+const defaultBitTiming = 32 // 4us per bit in 125ns units
+
+// Sync rotates the disk in the selected drive up to the current CPU cycle,
+// reading or writing the bits that pass under the head. The disk doesn't
+// rotate while the motor is off.
+func (c *Controller) Sync() {
+	d := c.selectedDrive()
+	now := c.System.Cycles + c.System.FrameCycles
+
+	// The cycle count goes backwards if the CPU is run without updating
+	// System.Cycles, as the tests do
+	elapsed := uint64(0)
+	if now > d.lastCycle {
+		elapsed = now - d.lastCycle
+	}
+	d.lastCycle = now
+
+	if c.System.DriveState.Spinning {
+		c.rotate(d, elapsed)
+	}
+}
+
+// SetMotor turns the motor of the selected drive on or off
+func (c *Controller) SetMotor(on bool) {
+	c.Sync()
+	c.System.DriveState.Spinning = on
+}
+
+// SelectDrive selects drive 1 or 2. The disk in the other drive stops.
+func (c *Controller) SelectDrive(drive uint8) {
+	c.Sync()
+	c.System.DriveState.Drive = drive
+	c.selectedDrive().lastCycle = c.System.Cycles + c.System.FrameCycles
+}
+
+// SetQ7 switches between read mode and write mode
+func (c *Controller) SetQ7(on bool) {
+	c.Sync()
+	if on && !c.System.DriveState.Q7 {
+		c.writeRegister = 0
+	}
+	c.System.DriveState.Q7 = on
+}
+
+// rotate rotates the disk in a drive for a number of CPU cycles. A CPU cycle
+// is taken to be 1us. When reading, only the last few bits matter, the rest
+// are skipped.
+func (c *Controller) rotate(d *drive, cycles uint64) {
+	timing := uint64(defaultBitTiming)
+	if d.woz != nil {
+		timing = uint64(d.woz.bitTiming)
+	}
+
+	eighths := cycles*8 + d.cycleRemainder
+	bits := eighths / timing
+	d.cycleRemainder = eighths % timing
+
+	writing := c.System.DriveState.Q7
+	bitCount := d.trackBitCount()
+
+	if bitCount == 0 {
+		// Only noise is read on quarter tracks without data
+		if bits > 64 {
+			bits = 64
+		}
+		for i := uint64(0); i < bits && !writing; i++ {
+			c.readBit(c.weakBit(0))
+		}
+		return
+	}
+
+	// Nibble tracks don't need the sequencer, only the position of the head
+	if d.woz == nil {
+		d.bitPosition = int((uint64(d.bitPosition) + bits) % uint64(bitCount))
+		return
+	}
+
+	if !writing && bits > 64 {
+		d.bitPosition = int((uint64(d.bitPosition) + bits - 64) % uint64(bitCount))
+		bits = 64
+	}
+	if bits > uint64(bitCount) {
+		bits = uint64(bitCount)
+	}
+
+	t := d.wozTrack
+	writeProtected := c.selectedDriveIsWriteProtected()
+	for i := uint64(0); i < bits; i++ {
+		if writing {
+			// Write the high bit of the write register, unless the write
+			// protect switch has turned off the write circuitry
+			if !writeProtected {
+				t.setBit(d.bitPosition, c.writeRegister>>7)
+				d.imageIsDirty = true
+			}
+			c.writeRegister <<= 1
+		} else {
+			c.readBit(c.weakBit(t.bit(d.bitPosition)))
+		}
+
+		d.bitPosition++
+		if d.bitPosition == bitCount {
+			d.bitPosition = 0
+		}
+	}
+}
+
+// weakBit returns the bit the drive reads for a bit on the disk. Without flux
+// changes the drive amplifies noise, so more than 3 zero bits in a row read
+// as random bits.
+func (c *Controller) weakBit(bit uint8) uint8 {
+	c.headWindow = ((c.headWindow << 1) | bit) & 0xf
+	if c.headWindow == 0 && c.random.Intn(10) < 3 {
+		return 1
+	}
+
+	return bit
+}
+
+// readBit shifts a bit read from the disk into the data register. A nibble is
+// complete when its high bit is set. It stays in the data latch for two more
+// bit times, after which reads see the next nibble being shifted in. Leading
+// zeroes are ignored, which is what makes sync bytes work.
+func (c *Controller) readBit(bit uint8) {
+	c.shiftRegister = (c.shiftRegister << 1) | bit
+	if (c.shiftRegister & 0x80) != 0 {
+		c.dataLatch = c.shiftRegister
+		c.shiftRegister = 0
+		c.holdBits = 2
+		return
+	}
+
+	if c.holdBits > 0 {
+		c.holdBits--
+		if c.holdBits > 0 {
+			return
+		}
+	}
+
+	c.dataLatch = c.shiftRegister
+}
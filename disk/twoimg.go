@@ -0,0 +1,98 @@
+package disk
+
+// 2IMG images have a 64 byte header in front of the disk data, which is a DOS
+// 3.3 order, ProDOS order or NIB image. The header has flags for write
+// protection and the DOS volume number, and can point at a comment and creator
+// data after the disk data. Writes only replace the disk data, so the header,
+// comment and creator data are saved as they were read.
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const twoIMGHeaderLength = 64 // Length of a 2IMG header
+
+// 2IMG image formats
+const (
+	twoIMGDOSOrder    = 0
+	twoIMGProDOSOrder = 1
+	twoIMGNIB         = 2
+)
+
+const twoIMGLocked = 1 << 31   // 2IMG flag for images that are write protected
+const twoIMGHasVolume = 1 << 8 // 2IMG flag for images with a DOS volume number in the low byte of the flags
+
+// twoIMG is a 2IMG image file
+type twoIMG struct {
+	file    []byte // The whole file
+	format  uint32 // twoIMGDOSOrder, twoIMGProDOSOrder or twoIMGNIB
+	offset  int    // Offset of the disk data in file
+	length  int    // Length of the disk data
+	locked  bool   // If the header write protects the image
+	volume  int    // DOS volume number, -1 if the header doesn't have one
+	comment string // Comment after the disk data
+}
+
+// is2IMG returns true if bytes start with a 2IMG header
+func is2IMG(bytes []byte) bool {
+	return len(bytes) >= twoIMGHeaderLength && string(bytes[:4]) == "2IMG"
+}
+
+// parse2IMG parses a 2IMG image. The disk data, comment and creator data
+// must be inside the file.
+func parse2IMG(bytes []byte) (*twoIMG, error) {
+	if !is2IMG(bytes) {
+		return nil, fmt.Errorf("Not a 2IMG image")
+	}
+
+	t := &twoIMG{
+		file:   bytes,
+		format: binary.LittleEndian.Uint32(bytes[12:]),
+		offset: int(binary.LittleEndian.Uint32(bytes[24:])),
+		length: int(binary.LittleEndian.Uint32(bytes[28:])),
+		volume: -1,
+	}
+
+	if t.format > twoIMGNIB {
+		return nil, fmt.Errorf("Unsupported 2IMG image format %d", t.format)
+	}
+
+	// Some images only have the number of blocks
+	if t.length == 0 && t.format == twoIMGProDOSOrder {
+		t.length = int(binary.LittleEndian.Uint32(bytes[20:])) * blockLength
+	}
+
+	if t.offset < twoIMGHeaderLength || t.length < 0 || t.offset+t.length > len(bytes) {
+		return nil, fmt.Errorf("2IMG image data at %d with length %d is outside the file", t.offset, t.length)
+	}
+
+	flags := binary.LittleEndian.Uint32(bytes[16:])
+	t.locked = (flags & twoIMGLocked) != 0
+	if (flags & twoIMGHasVolume) != 0 {
+		t.volume = int(flags & 0xff)
+	}
+
+	commentOffset := int(binary.LittleEndian.Uint32(bytes[32:]))
+	commentLength := int(binary.LittleEndian.Uint32(bytes[36:]))
+	if commentOffset != 0 && commentLength != 0 {
+		if commentOffset < twoIMGHeaderLength || commentLength < 0 || commentOffset+commentLength > len(bytes) {
+			return nil, fmt.Errorf("2IMG comment at %d with length %d is outside the file", commentOffset, commentLength)
+		}
+		t.comment = string(bytes[commentOffset : commentOffset+commentLength])
+	}
+
+	return t, nil
+}
+
+// data returns the disk data in the file
+func (t *twoIMG) data() []byte {
+	return t.file[t.offset : t.offset+t.length]
+}
+
+// bytes returns the file with its disk data replaced by data, which has the
+// same length
+func (t *twoIMG) bytes(data []byte) []byte {
+	copy(t.file[t.offset:t.offset+t.length], data)
+	return t.file
+}
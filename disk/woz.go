@@ -0,0 +1,196 @@
+package disk
+
+// WOZ images store each track as the stream of bits on the disk, so that copy
+// protected disks can be preserved. See https://applesaucefdc.com/woz/ for
+// the format. The image is kept as it's stored in the file and the tracks are
+// slices into it, so a flush only needs to update the CRC.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	wozHeaderLength      = 12   // Magic, $ff $0a $0d $0a and CRC
+	wozQuarterTracks     = 160  // Number of entries in the TMAP
+	wozEmptyTrack        = 0xff // TMAP entry of a quarter track without data
+	wozV1TrackLength     = 6656 // Length of a WOZ1 TRKS entry
+	wozV1BitstreamLength = 6646 // Bytes of a WOZ1 TRKS entry used for the bitstream
+	wozBlockLength       = 512  // WOZ2 tracks are stored in blocks of 512 bytes
+)
+
+// wozTrack is a track as a stream of bits
+type wozTrack struct {
+	bits     []byte // The bits, starting with the high bit of the first byte
+	bitCount int    // Number of bits in the track
+}
+
+// bit returns bit i of the track
+func (t *wozTrack) bit(i int) uint8 {
+	return (t.bits[i>>3] >> (7 - uint(i&7))) & 1
+}
+
+// setBit sets bit i of the track to value
+func (t *wozTrack) setBit(i int, value uint8) {
+	mask := uint8(0x80) >> uint(i&7)
+	if value != 0 {
+		t.bits[i>>3] |= mask
+	} else {
+		t.bits[i>>3] &^= mask
+	}
+}
+
+// woz is a WOZ 1 or 2 image
+type woz struct {
+	version        int                     // 1 or 2
+	raw            []byte                  // The image file
+	tmap           [wozQuarterTracks]uint8 // Index in tracks of each quarter track
+	tracks         []wozTrack              // The tracks, as slices of raw
+	writeProtected bool                    // Write protected flag in the INFO chunk
+	bitTiming      int                     // Time per bit in 125ns units
+}
+
+// isWOZ returns true if raw starts with a WOZ header
+func isWOZ(raw []byte) bool {
+	return len(raw) >= wozHeaderLength &&
+		(string(raw[0:4]) == "WOZ1" || string(raw[0:4]) == "WOZ2") &&
+		string(raw[4:8]) == "\xff\n\r\n"
+}
+
+// parseWOZ parses a WOZ image from the contents of its file
+func parseWOZ(raw []byte) (*woz, error) {
+	if !isWOZ(raw) {
+		return nil, fmt.Errorf("Not a WOZ image")
+	}
+
+	w := &woz{raw: raw, bitTiming: defaultBitTiming}
+	if raw[3] == '1' {
+		w.version = 1
+	} else {
+		w.version = 2
+	}
+
+	crc := binary.LittleEndian.Uint32(raw[8:12])
+	if crc != 0 && crc != crc32.ChecksumIEEE(raw[wozHeaderLength:]) {
+		return nil, fmt.Errorf("WOZ image has an invalid CRC")
+	}
+
+	var info, tmap, trks []byte
+
+	// Find the chunks that are needed
+	for pos := wozHeaderLength; pos+8 <= len(raw); {
+		id := string(raw[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(raw[pos+4 : pos+8]))
+		pos += 8
+
+		if size < 0 || pos+size > len(raw) {
+			return nil, fmt.Errorf("WOZ chunk %s is truncated", id)
+		}
+
+		switch id {
+		case "INFO":
+			info = raw[pos : pos+size]
+		case "TMAP":
+			tmap = raw[pos : pos+size]
+		case "TRKS":
+			trks = raw[pos : pos+size]
+		}
+
+		pos += size
+	}
+
+	if len(info) < 37 || len(tmap) < wozQuarterTracks || trks == nil {
+		return nil, fmt.Errorf("WOZ image is missing the INFO, TMAP or TRKS chunk")
+	}
+
+	if info[1] != 1 {
+		return nil, fmt.Errorf("WOZ image isn't a 5.25 inch disk")
+	}
+
+	w.writeProtected = info[2] == 1
+	if w.version == 2 && len(info) >= 40 && info[39] != 0 {
+		w.bitTiming = int(info[39])
+	}
+
+	copy(w.tmap[:], tmap)
+
+	var err error
+	if w.version == 1 {
+		err = w.parseV1Tracks(trks)
+	} else {
+		err = w.parseV2Tracks(trks)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range w.tmap {
+		if t != wozEmptyTrack && int(t) >= len(w.tracks) {
+			return nil, fmt.Errorf("WOZ TMAP refers to missing track %d", t)
+		}
+	}
+
+	return w, nil
+}
+
+// parseV1Tracks parses the TRKS chunk of a WOZ1 image, which has fixed size tracks
+func (w *woz) parseV1Tracks(trks []byte) error {
+	for pos := 0; pos+wozV1TrackLength <= len(trks); pos += wozV1TrackLength {
+		entry := trks[pos : pos+wozV1TrackLength]
+		bitCount := int(binary.LittleEndian.Uint16(entry[6648:6650]))
+
+		if bitCount > wozV1BitstreamLength*8 {
+			return fmt.Errorf("WOZ track %d has too many bits", len(w.tracks))
+		}
+
+		w.tracks = append(w.tracks, wozTrack{bits: entry[:wozV1BitstreamLength], bitCount: bitCount})
+	}
+
+	return nil
+}
+
+// parseV2Tracks parses the TRKS chunk of a WOZ2 image, which has 160 track
+// entries pointing at the blocks with the track data
+func (w *woz) parseV2Tracks(trks []byte) error {
+	if len(trks) < wozQuarterTracks*8 {
+		return fmt.Errorf("WOZ TRKS chunk is truncated")
+	}
+
+	for i := 0; i < wozQuarterTracks; i++ {
+		entry := trks[i*8 : i*8+8]
+		startBlock := int(binary.LittleEndian.Uint16(entry[0:2]))
+		blockCount := int(binary.LittleEndian.Uint16(entry[2:4]))
+		bitCount := int(binary.LittleEndian.Uint32(entry[4:8]))
+
+		start := startBlock * wozBlockLength
+		end := start + blockCount*wozBlockLength
+		if end > len(w.raw) || bitCount > blockCount*wozBlockLength*8 {
+			return fmt.Errorf("WOZ track %d is invalid", i)
+		}
+
+		w.tracks = append(w.tracks, wozTrack{bits: w.raw[start:end], bitCount: bitCount})
+	}
+
+	return nil
+}
+
+// track returns the track at a quarter track, or nil if there is no data
+func (w *woz) track(quarterTrack int) *wozTrack {
+	if quarterTrack < 0 || quarterTrack >= wozQuarterTracks || w.tmap[quarterTrack] == wozEmptyTrack {
+		return nil
+	}
+
+	t := &w.tracks[w.tmap[quarterTrack]]
+	if t.bitCount == 0 {
+		return nil
+	}
+
+	return t
+}
+
+// bytes returns the image file with an updated CRC
+func (w *woz) bytes() []byte {
+	binary.LittleEndian.PutUint32(w.raw[8:12], crc32.ChecksumIEEE(w.raw[wozHeaderLength:]))
+	return w.raw
+}
@@ -0,0 +1,156 @@
+package disk
+
+// Writes to disk images are kept in memory until the image is flushed. A
+// flush replaces the file with a temporary file, so a crash never leaves a
+// partly written image behind.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteMode says where writes to disk images end up
+type WriteMode int
+
+const (
+	// WriteToImage writes back to the image file
+	WriteToImage WriteMode = iota
+
+	// WriteToOverlay writes a copy of the image with the writes to a file
+	// next to it, which is read instead of the image from then on. The image
+	// itself is never written.
+	WriteToOverlay
+
+	// WriteToMemory only keeps writes in memory, they are lost when the image
+	// is ejected
+	WriteToMemory
+)
+
+// overlaySuffix is added to an image's path to make the path of its overlay
+const overlaySuffix = ".overlay"
+
+// ParseWriteMode parses a write mode from the command line, one of image,
+// overlay or memory
+func ParseWriteMode(s string) (WriteMode, error) {
+	switch s {
+	case "image":
+		return WriteToImage, nil
+	case "overlay":
+		return WriteToOverlay, nil
+	case "memory":
+		return WriteToMemory, nil
+	default:
+		return WriteToImage, fmt.Errorf("Invalid disk write mode %q, expected image, overlay or memory", s)
+	}
+}
+
+// autosaveTimer says when it's time to autosave images
+type autosaveTimer struct {
+	interval time.Duration // Time between autosaves, zero if autosaving is off
+	last     time.Time     // Time of the last autosave
+}
+
+// set sets the autosave interval and starts counting from now
+func (t *autosaveTimer) set(interval time.Duration) {
+	t.interval = interval
+	t.last = time.Now()
+}
+
+// due returns true if the interval has passed since the last autosave and
+// starts counting again
+func (t *autosaveTimer) due() bool {
+	if t.interval == 0 || time.Since(t.last) < t.interval {
+		return false
+	}
+
+	t.last = time.Now()
+	return true
+}
+
+// SetWriteMode sets where writes to disk images end up. It applies to images
+// inserted after the call.
+func (c *Controller) SetWriteMode(mode WriteMode) {
+	c.writeMode = mode
+}
+
+// SetAutosaveInterval makes Autosave flush the images at most once per
+// interval. Zero turns autosaving off.
+func (c *Controller) SetAutosaveInterval(interval time.Duration) {
+	c.autosave.set(interval)
+}
+
+// Autosave flushes the images if the autosave interval has passed since the
+// last autosave. It's called once per frame.
+func (c *Controller) Autosave() error {
+	if !c.autosave.due() {
+		return nil
+	}
+
+	return c.FlushImage()
+}
+
+// readImageFile reads an image, or its overlay if there is one in overlay
+// mode
+func readImageFile(mode WriteMode, path string) ([]byte, error) {
+	if mode == WriteToOverlay {
+		bytes, err := ioutil.ReadFile(path + overlaySuffix)
+		if err == nil {
+			return bytes, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// flushPath returns the path writes to an image are flushed to, or an empty
+// string if they aren't flushed
+func flushPath(mode WriteMode, imagePath string) string {
+	switch mode {
+	case WriteToOverlay:
+		return imagePath + overlaySuffix
+	case WriteToMemory:
+		return ""
+	default:
+		return imagePath
+	}
+}
+
+// WriteFileAtomically writes data to a temporary file next to path and
+// renames it to path. The file keeps its permissions.
+func WriteFileAtomically(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
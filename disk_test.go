@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestDiskImage writes a disk image filled with value to dir and returns its path
+func writeTestDiskImage(t *testing.T, dir string, name string, value byte) string {
+	bytes := make([]byte, 35*16*0x100)
+	for i := range bytes {
+		bytes[i] = value
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, bytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// readTrackBytes turns on the motor of the selected drive and polls the disk
+// every 4 cycles until n nibbles have been read. Fewer nibbles are returned if
+// there is no data.
+func readTrackBytes(m *machine.Machine, n int) []uint8 {
+	m.MMU.ReadMemory(0xc0e9) // Motor on
+
+	var nibbles []uint8
+	previous := m.MMU.ReadMemory(0xc0ec)
+	for i := 0; i < n*64 && len(nibbles) < n; i++ {
+		m.System.FrameCycles += 4
+		value := m.MMU.ReadMemory(0xc0ec)
+		if (value&0x80) != 0 && (previous&0x80) == 0 {
+			nibbles = append(nibbles, value)
+		}
+		previous = value
+	}
+
+	return nibbles
+}
+
+// sector is a sector read from a track
+type sector struct {
+	address []byte // Address field, from the prologue to the checksum
+	data    []byte // Data field, from the prologue to the epilogue
+}
+
+// readSectors reads a bit more than a revolution of the track under the head
+// of the selected drive and returns its sectors by physical sector. The head
+// can be anywhere on the track. The address fields start with d5 aa and
+// addressPrologue and the data fields have dataBytes disk bytes, including
+// the checksum.
+func readSectors(m *machine.Machine, addressPrologue uint8, dataBytes int) map[uint8]sector {
+	track := readTrackBytes(m, 6250+1000)
+
+	sectors := make(map[uint8]sector)
+	for i := 0; i+11 <= len(track); i++ {
+		if !bytes.Equal(track[i:i+3], []byte{0xd5, 0xaa, addressPrologue}) {
+			continue
+		}
+
+		data := bytes.Index(track[i+11:], []byte{0xd5, 0xaa, 0xad})
+		if data < 0 || i+11+data+3+dataBytes+3 > len(track) {
+			continue
+		}
+
+		address := track[i : i+11]
+		number := ((address[7] << 1) | 1) & address[8]
+		sectors[number] = sector{address, track[i+11+data : i+11+data+3+dataBytes+3]}
+	}
+
+	return sectors
+}
+
+// waitForSector reads the track until the address field of a sector has
+// passed under the head
+func waitForSector(m *machine.Machine, s sector) {
+	var nibbles []byte
+	for len(nibbles) < len(s.address) || !bytes.Equal(nibbles[len(nibbles)-len(s.address):], s.address) {
+		nibbles = append(nibbles, readTrackBytes(m, 1)...)
+	}
+}
+
+// TestTwoDrives tests that the two drives have their own images and heads and
+// that images can be inserted, swapped and ejected
+func TestTwoDrives(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// An empty drive has no data
+	assert.Empty(t, readTrackBytes(m, 0x10))
+
+	path1 := writeTestDiskImage(t, dir, "1.dsk", 0x11)
+	path2 := writeTestDiskImage(t, dir, "2.dsk", 0x22)
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path1))
+	assert.Nil(t, m.Disk.InsertDiskImage(2, path2))
+	assert.NotNil(t, m.Disk.InsertDiskImage(2, filepath.Join(dir, "missing.dsk")))
+	assert.Equal(t, path2, m.Disk.ImagePath(2))
+
+	data1 := readTrackBytes(m, 0x200)
+	m.MMU.ReadMemory(0xc0eb) // SELDRV2
+	data2 := readTrackBytes(m, 0x200)
+	assert.NotEqual(t, data1, data2)
+
+	// Move the head of drive 2 to track 1
+	m.MMU.ReadMemory(0xc0e3) // Phase 1 on
+	m.MMU.ReadMemory(0xc0e2) // Phase 1 off
+	m.MMU.ReadMemory(0xc0e5) // Phase 2 on
+	m.MMU.ReadMemory(0xc0e4) // Phase 2 off
+	assert.Equal(t, int8(0), m.Disk.Phase(1))
+	assert.Equal(t, int8(2), m.Disk.Phase(2))
+
+	// Swapping moves the images, the heads stay put
+	m.Disk.SwapDiskImages()
+	assert.Equal(t, path2, m.Disk.ImagePath(1))
+	assert.Equal(t, path1, m.Disk.ImagePath(2))
+	assert.Equal(t, int8(2), m.Disk.Phase(2))
+
+	assert.Nil(t, m.Disk.EjectDiskImage(2))
+	assert.Equal(t, "", m.Disk.ImagePath(2))
+	assert.Empty(t, readTrackBytes(m, 0x10))
+}
+
+// TestSectorOrderDetection tests detecting the sector order of disk images
+// from their extension and contents
+func TestSectorOrderDetection(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// A DOS 3.3 disk with a VTOC at track 17 sector 0 and the catalog on
+	// sectors 15 to 1. Sectors 0 and 15 are in the same place in both orders,
+	// sector 14 is file sector 14 in DOS order and 1 in ProDOS order.
+	dos := make([]byte, 35*16*0x100)
+	vtoc := dos[17*16*0x100:]
+	vtoc[0x01], vtoc[0x02], vtoc[0x27], vtoc[0x34], vtoc[0x35] = 17, 15, 122, 35, 16
+	for s := 15; s > 0; s-- {
+		catalog := dos[(17*16+s)*0x100:]
+		catalog[0x01], catalog[0x02] = 17, byte(s-1)
+	}
+	catalog := dos[(17*16+1)*0x100:]
+	catalog[0x01], catalog[0x02] = 0, 0
+
+	// A ProDOS disk with a volume directory in blocks 2 and 3
+	prodos := make([]byte, 35*16*0x100)
+	key := prodos[2*0x200:]
+	key[0x02], key[0x04], key[0x23], key[0x24] = 3, 0xf5, 0x27, 0x0d
+	prodos[3*0x200] = 2
+
+	tests := []struct {
+		name  string
+		data  []byte
+		order string
+	}{
+		{"dos.dsk", dos, "DOS 3.3"},
+		{"prodos.dsk", prodos, "ProDOS"},
+		{"blank.dsk", make([]byte, 35*16*0x100), "DOS 3.3"},
+		{"blank.po", make([]byte, 35*16*0x100), "ProDOS"},
+		{"prodos.do", prodos, "DOS 3.3"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+		assert.Equal(t, test.order, m.Disk.SectorOrder(1), test.name)
+	}
+}
+
+// TestNIB tests that the nibbles of NIB images are read and written as they are
+func TestNIB(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// Track 0 has a non-standard address prologue
+	nib := make([]byte, 35*6656)
+	for i := range nib {
+		nib[i] = 0xff
+	}
+	copy(nib[0x100:], []byte{0xd4, 0xaa, 0x96})
+
+	path := filepath.Join(dir, "test.nib")
+	if err := ioutil.WriteFile(path, nib, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Equal(t, "NIB", m.Disk.ImageFormat(1))
+	assert.Equal(t, nib[:6656], readTrackBytes(m, 6656))
+
+	// The head is back at the start of the track, write a nibble every 32
+	// cycles
+	m.MMU.ReadMemory(0xc0ef) // Write mode
+	for _, value := range []uint8{0xd5, 0xaa, 0xad} {
+		m.MMU.WriteMemory(0xc0ed, value)
+		m.System.FrameCycles += 32
+	}
+	m.MMU.ReadMemory(0xc0ee) // Read mode
+
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(nib, []byte{0xd5, 0xaa, 0xad})
+	assert.Equal(t, nib, written)
+}
+
+// TestDiskTiming tests that the disk rotates in step with the CPU
+func TestDiskTiming(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// Each nibble is one more than the previous one
+	next := func(value uint8) uint8 { return 0x80 | (value + 1) }
+	nib := make([]byte, 35*6656)
+	for i := range nib {
+		nib[i] = 0x80 | uint8(i)
+	}
+
+	path := filepath.Join(dir, "test.nib")
+	if err := ioutil.WriteFile(path, nib, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+
+	// A read loop like the ones in RWTS reads every nibble once
+	program := []uint8{
+		0xa2, 0x60, // LDX #$60
+		0xbd, 0x89, 0xc0, // LDA $C089,X  Motor on
+		0xa0, 0x00, // LDY #$00
+		0xbd, 0x8c, 0xc0, // LDA $C08C,X  Read
+		0x10, 0xfb, // BPL $0307
+		0x99, 0x00, 0x10, // STA $1000,Y
+		0xc8,       // INY
+		0xd0, 0xf5, // BNE $0307
+		0x4c, 0x12, 0x03, // JMP $0312
+	}
+	for i, b := range program {
+		m.MMU.WriteMemory(0x300+uint16(i), b)
+	}
+
+	breakAddress := uint16(0x312)
+	m.CPU.State.PC = 0x300
+	m.CPU.Run(false, &breakAddress, false, false, false, 100000)
+	assert.Equal(t, breakAddress, m.CPU.State.PC)
+
+	for i := uint16(0); i < 0xff; i++ {
+		assert.Equal(t, next(m.MMU.ReadMemory(0x1000+i)), m.MMU.ReadMemory(0x1000+i+1))
+	}
+
+	// Nibbles are skipped if the disk isn't read often enough
+	value := readTrackBytes(m, 1)[0]
+	m.System.FrameCycles += 64
+	assert.Equal(t, next(next(value)), m.MMU.ReadMemory(0xc0ec))
+
+	// The latch has the bits of the next nibble read so far
+	m.System.FrameCycles += 12
+	assert.Equal(t, next(next(next(value)))>>5, m.MMU.ReadMemory(0xc0ec))
+
+	// The disk stops with the motor
+	m.System.FrameCycles += 20
+	value = m.MMU.ReadMemory(0xc0ec)
+	m.MMU.ReadMemory(0xc0e8) // Motor off
+	m.System.FrameCycles += 1000
+	assert.Equal(t, value, m.MMU.ReadMemory(0xc0ec))
+
+	// Sector tracks are padded with sync bytes, so that a revolution takes
+	// close to the 200000 cycles of a disk spinning at 300 RPM
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeTestDiskImage(t, dir, "test.dsk", 0)))
+	s := readSectors(m, 0x96, 0x157)[0]
+	track := readTrackBytes(m, 2*6250)
+	first := bytes.Index(track, s.address)
+	second := bytes.Index(track[first+1:], s.address) + first + 1
+	assert.InDelta(t, 200000, (second-first)*32, 1000)
+	gap := track[first+14 : first+14+bytes.Index(track[first+14:], []byte{0xd5, 0xaa, 0xad})]
+	assert.Equal(t, bytes.Repeat([]byte{0xff}, 6), gap)
+}
+
+// TestWriteProtect tests that write protected disks are reported as such by
+// the controller and that they aren't written to
+func TestWriteProtect(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// senseWriteProtect reads the write protect switch like RWTS
+	senseWriteProtect := func() bool {
+		m.MMU.ReadMemory(0xc0ed) // Q6 on
+		protected := (m.MMU.ReadMemory(0xc0ee) & 0x80) != 0
+		m.MMU.ReadMemory(0xc0ec) // Q6 off
+		return protected
+	}
+
+	// writeNibbles writes nibbles to the selected drive, 32 cycles apart
+	writeNibbles := func(nibbles ...uint8) {
+		m.MMU.ReadMemory(0xc0e9) // Motor on
+		m.MMU.ReadMemory(0xc0ef) // Write mode
+		for _, n := range nibbles {
+			m.MMU.WriteMemory(0xc0ed, n)
+			m.System.FrameCycles += 32
+		}
+		m.MMU.ReadMemory(0xc0ee) // Read mode
+	}
+
+	nib := make([]byte, 35*6656)
+	path := filepath.Join(dir, "test.nib")
+	if err := ioutil.WriteFile(path, nib, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writable image
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.False(t, m.Disk.WriteProtected(1))
+	assert.False(t, senseWriteProtect())
+
+	// Write protecting the drive
+	m.Disk.SetWriteProtected(1, true)
+	assert.True(t, m.Disk.WriteProtected(1))
+	assert.True(t, senseWriteProtect())
+	writeNibbles(0xd5, 0xaa, 0xad)
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, nib, written)
+	m.Disk.SetWriteProtected(1, false)
+
+	// A read-only file
+	if err := os.Chmod(path, 0444); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.True(t, m.Disk.WriteProtected(1))
+	assert.True(t, senseWriteProtect())
+	assert.False(t, m.Disk.WriteProtected(2))
+
+	// A WOZ image with the write protected flag in its header
+	wozPath := writeTestWOZImage(t, dir, "test.woz", []uint8{0}, []uint8{0xd5, 0xaa, 0x96})
+	woz, err := ioutil.ReadFile(wozPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	woz[22] = 1 // INFO write protected
+	binary.LittleEndian.PutUint32(woz[8:], crc32.ChecksumIEEE(woz[12:]))
+	if err := ioutil.WriteFile(wozPath, woz, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, m.Disk.InsertDiskImage(2, wozPath))
+	m.MMU.ReadMemory(0xc0eb) // Drive 2
+	assert.True(t, senseWriteProtect())
+	writeNibbles(0xff, 0xff, 0xff, 0xff)
+	assert.Nil(t, m.Disk.EjectDiskImage(2))
+	written, err = ioutil.ReadFile(wozPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, woz, written)
+}
+
+// TestDiskWriteModes tests that writes go to the image, to an overlay next to
+// it or nowhere, depending on the write mode
+func TestDiskWriteModes(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nibbles := []uint8{0xd5, 0xaa, 0xad, 0x96, 0x97}
+	nib := make([]byte, 35*6656)
+
+	// writeImage inserts a fresh NIB image in a write mode and writes nibbles
+	// to it
+	writeImage := func(name string, mode disk.WriteMode) (*machine.Machine, string) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nib, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		m := machine.New(cpu.Model6502)
+		m.Disk.SetWriteMode(mode)
+		assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+
+		m.MMU.ReadMemory(0xc0e9) // Motor on
+		m.MMU.ReadMemory(0xc0ef) // Write mode
+		for _, n := range nibbles {
+			m.MMU.WriteMemory(0xc0ed, n)
+			m.System.FrameCycles += 32
+		}
+		m.MMU.ReadMemory(0xc0ee) // Read mode
+
+		return m, path
+	}
+
+	readFile := func(path string) []byte {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// Image mode replaces the image, keeping its permissions and leaving no
+	// temporary files behind
+	m, path := writeImage("image.nib", disk.WriteToImage)
+	assert.Nil(t, m.Disk.FlushImage())
+	assert.True(t, bytes.Contains(readFile(path), nibbles))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, files, 1)
+
+	// Overlay mode leaves the image alone and reads the overlay when the image
+	// is inserted again
+	m, path = writeImage("overlay.nib", disk.WriteToOverlay)
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	assert.Equal(t, nib, readFile(path))
+	assert.True(t, bytes.Contains(readFile(path+".overlay"), nibbles))
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Contains(t, string(readTrackBytes(m, 1000)), string(nibbles))
+
+	// Changing the mode doesn't change where writes to an image that's
+	// already inserted go
+	m, path = writeImage("inserted.nib", disk.WriteToOverlay)
+	m.Disk.SetWriteMode(disk.WriteToImage)
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	assert.Equal(t, nib, readFile(path))
+	assert.True(t, bytes.Contains(readFile(path+".overlay"), nibbles))
+
+	// Memory mode writes nothing
+	m, path = writeImage("memory.nib", disk.WriteToMemory)
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	assert.Equal(t, nib, readFile(path))
+	_, err = os.Stat(path + ".overlay")
+	assert.True(t, os.IsNotExist(err))
+
+	// The write mode moves with the image when the drives are swapped
+	m, path = writeImage("swapped.nib", disk.WriteToMemory)
+	m.Disk.SwapDiskImages()
+	assert.Nil(t, m.Disk.FlushImage())
+	assert.Equal(t, nib, readFile(path))
+
+	// Autosave flushes once the interval has passed
+	m, path = writeImage("autosave.nib", disk.WriteToImage)
+	m.Disk.SetAutosaveInterval(time.Hour)
+	assert.Nil(t, m.Disk.Autosave())
+	assert.Equal(t, nib, readFile(path))
+	m.Disk.SetAutosaveInterval(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, m.Disk.Autosave())
+	assert.True(t, bytes.Contains(readFile(path), nibbles))
+}
+
+// TestThirteenSectors tests that 13 sector images are encoded with the DOS 3.2
+// address prologue and 5-and-3 encoding and that written sectors are decoded
+func TestThirteenSectors(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// Sector 0 of track 0 is zeroes, the others have a pattern
+	image := make([]byte, 35*13*0x100)
+	for i := 0x100; i < len(image); i++ {
+		image[i] = uint8(i*7 + i/0x100)
+	}
+	path := filepath.Join(dir, "test.d13")
+	if err := ioutil.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Equal(t, "DOS 3.2 13 sector", m.Disk.ImageFormat(1))
+
+	// Each sector has an address field with the DOS 3.2 prologue, followed by
+	// a gap and a data field with 410 disk bytes and a checksum
+	sectors := readSectors(m, 0xb5, 411)
+	assert.Len(t, sectors, 13)
+	for number, s := range sectors {
+		address := s.address[3:]
+		volume := ((address[0] << 1) | 1) & address[1]
+		assert.Equal(t, uint8(254), volume)
+		assert.Equal(t, []byte{0xd5, 0xaa, 0xad}, s.data[:3])
+		assert.Equal(t, []byte{0xde, 0xaa, 0xeb}, s.data[3+411:], number)
+	}
+
+	// A sector of zeroes is all the disk byte for zero
+	assert.Equal(t, bytes.Repeat([]byte{0xab}, 411), sectors[0].data[3:3+411])
+
+	// Wait for the address field of sector 5 and write the data field of
+	// sector 7 after it
+	waitForSector(m, sectors[5])
+
+	m.MMU.ReadMemory(0xc0ef) // Write mode
+	data := append([]byte{0xff, 0xff, 0xff, 0xff, 0xff}, sectors[7].data...)
+	for _, n := range data {
+		m.MMU.WriteMemory(0xc0ed, n)
+		m.System.FrameCycles += 32
+	}
+	m.MMU.ReadMemory(0xc0ee) // Read mode
+
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, image[7*0x100:8*0x100], written[5*0x100:6*0x100])
+	copy(written[5*0x100:], image[5*0x100:6*0x100])
+	assert.Equal(t, image, written)
+
+	// A 13 sector boot ROM can be put in slot 6
+	rom := filepath.Join(dir, "p5a.rom")
+	if err := ioutil.WriteFile(rom, append([]byte{0xa2}, make([]byte, 0xff)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, m.MMU.LoadDiskROM(rom))
+	assert.Equal(t, uint8(0xa2), m.MMU.ReadMemory(0xc600))
+	if err := ioutil.WriteFile(rom, make([]byte, 0x200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, m.MMU.LoadDiskROM(rom))
+}
+
+// make2IMG returns a 2IMG file with data in format, followed by comment
+func make2IMG(format uint32, flags uint32, data []byte, comment string) []byte {
+	header := make([]byte, 64)
+	copy(header, "2IMG")
+	binary.LittleEndian.PutUint16(header[8:], 64)
+	binary.LittleEndian.PutUint32(header[12:], format)
+	binary.LittleEndian.PutUint32(header[16:], flags)
+	binary.LittleEndian.PutUint32(header[24:], 64)
+	binary.LittleEndian.PutUint32(header[28:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[32:], uint32(64+len(data)))
+	binary.LittleEndian.PutUint32(header[36:], uint32(len(comment)))
+
+	file := append(header, data...)
+	return append(file, comment...)
+}
+
+// TestVolumeAnd2IMG tests that the volume number comes from the DOS VTOC or
+// the 2IMG header and that 2IMG images are read and written with their header
+// and comment
+func TestVolumeAnd2IMG(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The volume in the address field of physical sector 0 of track 0
+	readVolume := func() uint8 {
+		address := readSectors(m, 0x96, 0x157)[0].address
+		return ((address[3] << 1) | 1) & address[4]
+	}
+
+	// Each file sector of track 0 is filled with its number plus one and the
+	// VTOC has volume 10
+	image := make([]byte, 35*16*0x100)
+	for s := 0; s < 16; s++ {
+		for i := 0; i < 0x100; i++ {
+			image[s*0x100+i] = uint8(s + 1)
+		}
+	}
+	vtoc := image[17*16*0x100:]
+	vtoc[0x06], vtoc[0x27], vtoc[0x34], vtoc[0x35] = 10, 122, 35, 16
+
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeFile("vtoc.do", image)))
+	assert.Equal(t, 10, m.Disk.Volume(1))
+	assert.Equal(t, uint8(10), readVolume())
+
+	// Without a VTOC, the volume is 254
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeFile("blank.do", make([]byte, 35*16*0x100))))
+	assert.Equal(t, 254, m.Disk.Volume(1))
+
+	// The 2IMG header's volume number overrides the VTOC
+	file := make2IMG(0, 0x100|42, image, "A comment")
+	path := writeFile("dos.2mg", file)
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Equal(t, "2IMG DOS 3.3 order", m.Disk.ImageFormat(1))
+	assert.Equal(t, "A comment", m.Disk.ImageComment(1))
+	assert.Equal(t, 42, m.Disk.Volume(1))
+	assert.False(t, m.Disk.WriteProtected(1))
+	assert.Equal(t, file, m.Disk.SaveState().Drives[0].Image)
+
+	// Wait for the address field of physical sector 5 and write the data
+	// field of physical sector 7 after it
+	assert.Equal(t, uint8(42), readVolume())
+	sectors := readSectors(m, 0x96, 0x157)
+	waitForSector(m, sectors[5])
+
+	m.MMU.ReadMemory(0xc0ef) // Write mode
+	for _, n := range append([]byte{0xff, 0xff, 0xff, 0xff, 0xff}, sectors[7].data...) {
+		m.MMU.WriteMemory(0xc0ed, n)
+		m.System.FrameCycles += 32
+	}
+	m.MMU.ReadMemory(0xc0ee) // Read mode
+
+	// Physical sectors 5 and 7 are file sectors 5 and 4 in DOS order. The
+	// header and comment are saved as they were.
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(file[64+5*0x100:], image[4*0x100:5*0x100])
+	assert.Equal(t, file, written)
+
+	// A locked ProDOS order 2IMG image is write protected
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeFile("prodos.2mg", make2IMG(1, 1<<31, image, ""))))
+	assert.Equal(t, "ProDOS", m.Disk.SectorOrder(1))
+	assert.Equal(t, "", m.Disk.ImageComment(1))
+	assert.True(t, m.Disk.WriteProtected(1))
+
+	// A NIB 2IMG image is read as it is
+	nib := make([]byte, 35*6656)
+	for i := range nib {
+		nib[i] = uint8(0x80 | i)
+	}
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeFile("nib.2mg", make2IMG(2, 0, nib, ""))))
+	assert.Equal(t, "2IMG NIB", m.Disk.ImageFormat(1))
+	nibTrack := append(nib[:6656:6656], nib[:6656]...) // The head can be anywhere on the track
+	assert.True(t, bytes.Contains(nibTrack, readTrackBytes(m, 0x100)))
+
+	// 2IMG images with the wrong amount of data or a bad format are refused
+	assert.NotNil(t, m.Disk.InsertDiskImage(1, writeFile("short.2mg", make2IMG(0, 0, image[:0x100], ""))))
+	assert.NotNil(t, m.Disk.InsertDiskImage(1, writeFile("bad.2mg", make2IMG(3, 0, image, ""))))
+	assert.Equal(t, filepath.Join(dir, "nib.2mg"), m.Disk.ImagePath(1))
+}
@@ -0,0 +1,191 @@
+package main
+
+// Disk image handling while the emulator is running. Images can be inserted,
+// ejected and swapped with commands on stdin. Dropping a file onto most
+// terminals types its path, which inserts it into drive 1.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	diskImages       []string    // Disk images from the command line
+	nextDiskImage    [2]int      // Index in diskImages of the next image for ctrl-alt-1 and ctrl-alt-2
+	diskCommands     chan string // Commands read from stdin
+	diskCommandsHelp = "Disk commands: insert [1|2] PATH, eject [1|2], swap, protect [1|2], unprotect [1|2] or a path to insert into drive 1"
+)
+
+// insertDiskImage inserts an image into a drive and reports the result
+func insertDiskImage(drive int, path string) {
+	if err := apple2.Disk.InsertDiskImage(drive, path); err != nil {
+		fmt.Printf("Unable to insert %s into drive %d: %s\n", path, drive, err)
+		return
+	}
+
+	details := ""
+	if volume := apple2.Disk.Volume(drive); volume != 0 {
+		details += fmt.Sprintf(", volume %d", volume)
+	}
+	if apple2.Disk.WriteProtected(drive) {
+		details += ", write protected"
+	}
+
+	fmt.Printf("Inserted %s into drive %d, %s%s\n", path, drive, apple2.Disk.ImageFormat(drive), details)
+	if comment := apple2.Disk.ImageComment(drive); comment != "" {
+		fmt.Printf("Comment: %s\n", comment)
+	}
+}
+
+// insertNextDiskImage inserts the next disk image from the command line into
+// a drive, going back to the first one after the last one
+func insertNextDiskImage(drive int) {
+	if len(diskImages) == 0 {
+		fmt.Println("There are no disk images on the command line")
+		return
+	}
+
+	i := &nextDiskImage[drive-1]
+	insertDiskImage(drive, diskImages[*i%len(diskImages)])
+	*i = (*i + 1) % len(diskImages)
+}
+
+// ejectDiskImage ejects the image from a drive and reports the result
+func ejectDiskImage(drive int) {
+	if err := apple2.Disk.EjectDiskImage(drive); err != nil {
+		fmt.Printf("Unable to eject drive %d: %s\n", drive, err)
+		return
+	}
+
+	fmt.Printf("Ejected drive %d\n", drive)
+}
+
+// swapDiskImages swaps the images in the drives and reports the result
+func swapDiskImages() {
+	apple2.Disk.SwapDiskImages()
+	fmt.Printf("Drive 1: %s, drive 2: %s\n", apple2.Disk.ImagePath(1), apple2.Disk.ImagePath(2))
+}
+
+// setWriteProtected write protects a drive or removes the protection and
+// reports the result
+func setWriteProtected(drive int, on bool) {
+	apple2.Disk.SetWriteProtected(drive, on)
+
+	if apple2.Disk.WriteProtected(drive) {
+		fmt.Printf("Drive %d is write protected\n", drive)
+	} else {
+		fmt.Printf("Drive %d is write enabled\n", drive)
+	}
+}
+
+// unquotePath removes the quotes and backslash escapes that terminals add to
+// a dropped file's path
+func unquotePath(path string) string {
+	path = strings.TrimSpace(path)
+
+	if len(path) >= 2 && (path[0] == '\'' || path[0] == '"') && path[len(path)-1] == path[0] {
+		return path[1 : len(path)-1]
+	}
+
+	return strings.Replace(path, "\\ ", " ", -1)
+}
+
+// parseDrive returns the drive number if s is 1 or 2
+func parseDrive(s string) (int, bool) {
+	switch s {
+	case "1":
+		return 1, true
+	case "2":
+		return 2, true
+	default:
+		return 0, false
+	}
+}
+
+// optionalDrive returns the drive in the command's second field, or drive 1 if
+// there isn't one
+func optionalDrive(fields []string) (int, bool) {
+	if len(fields) < 2 {
+		return 1, true
+	}
+
+	return parseDrive(fields[1])
+}
+
+// runDiskCommand runs a disk command read from stdin
+func runDiskCommand(line string) {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return
+	}
+
+	switch fields[0] {
+	case "insert":
+		if len(fields) < 2 {
+			fmt.Println(diskCommandsHelp)
+			return
+		}
+
+		rest := strings.TrimSpace(strings.TrimPrefix(line, "insert"))
+		drive, ok := parseDrive(fields[1])
+		if ok {
+			rest = strings.TrimSpace(rest[1:])
+		} else {
+			drive = 1
+		}
+		insertDiskImage(drive, unquotePath(rest))
+
+	case "eject", "protect", "unprotect":
+		drive, ok := optionalDrive(fields)
+		if !ok {
+			fmt.Println(diskCommandsHelp)
+			return
+		}
+
+		if fields[0] == "eject" {
+			ejectDiskImage(drive)
+		} else {
+			setWriteProtected(drive, fields[0] == "protect")
+		}
+
+	case "swap":
+		swapDiskImages()
+
+	case "help":
+		fmt.Println(diskCommandsHelp)
+
+	default:
+		insertDiskImage(1, unquotePath(line))
+	}
+}
+
+// readDiskCommands reads disk commands from stdin and sends them to
+// diskCommands. The commands are run by the main loop, between frames.
+func readDiskCommands() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		diskCommands <- scanner.Text()
+	}
+}
+
+// startDiskCommands starts reading disk commands from stdin
+func startDiskCommands() {
+	diskCommands = make(chan string, 16)
+	go readDiskCommands()
+}
+
+// runDiskCommands runs the disk commands that have come in since the last frame
+func runDiskCommands() {
+	for {
+		select {
+		case command := <-diskCommands:
+			runDiskCommand(command)
+		default:
+			return
+		}
+	}
+}
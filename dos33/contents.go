@@ -0,0 +1,95 @@
+package dos33
+
+// The data sectors of binary files start with the load address and length,
+// those of BASIC programs with the length. Text files have high bit ASCII
+// with a carriage return at the end of each line and end at the first zero.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// EncodeBinary returns the contents of a binary file that loads data at
+// address. The length is 16 bits, so data can't be longer than $FFFF bytes.
+func EncodeBinary(address uint16, data []byte) ([]byte, error) {
+	if len(data) > 0xffff {
+		return nil, fmt.Errorf("Binary file has %d bytes of data, more than the maximum of %d", len(data), 0xffff)
+	}
+
+	contents := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint16(contents[0:], address)
+	binary.LittleEndian.PutUint16(contents[2:], uint16(len(data)))
+	return append(contents, data...), nil
+}
+
+// DecodeBinary returns the load address and data of a binary file's contents
+func DecodeBinary(contents []byte) (uint16, []byte, error) {
+	if len(contents) < 4 {
+		return 0, nil, fmt.Errorf("Binary file is too short")
+	}
+
+	address := binary.LittleEndian.Uint16(contents[0:])
+	length := int(binary.LittleEndian.Uint16(contents[2:]))
+	if 4+length > len(contents) {
+		return 0, nil, fmt.Errorf("Binary file has length %d, more than its %d bytes of data", length, len(contents)-4)
+	}
+
+	return address, contents[4 : 4+length], nil
+}
+
+// EncodeProgram returns the contents of an Integer or Applesoft BASIC file
+// with a tokenized program
+func EncodeProgram(program []byte) []byte {
+	contents := make([]byte, 2, 2+len(program))
+	binary.LittleEndian.PutUint16(contents, uint16(len(program)))
+	return append(contents, program...)
+}
+
+// DecodeProgram returns the tokenized program in the contents of an Integer
+// or Applesoft BASIC file
+func DecodeProgram(contents []byte) ([]byte, error) {
+	if len(contents) < 2 {
+		return nil, fmt.Errorf("Program file is too short")
+	}
+
+	length := int(binary.LittleEndian.Uint16(contents))
+	if 2+length > len(contents) {
+		return nil, fmt.Errorf("Program file has length %d, more than its %d bytes of data", length, len(contents)-2)
+	}
+
+	return contents[2 : 2+length], nil
+}
+
+// EncodeText returns the contents of a text file with lines separated by
+// newlines
+func EncodeText(text []byte) []byte {
+	contents := make([]byte, len(text))
+	for i, c := range text {
+		if c == '\n' {
+			c = '\r'
+		}
+		contents[i] = c | 0x80
+	}
+
+	return contents
+}
+
+// DecodeText returns the lines of a text file's contents separated by
+// newlines
+func DecodeText(contents []byte) []byte {
+	if end := bytes.IndexByte(contents, 0); end >= 0 {
+		contents = contents[:end]
+	}
+
+	text := make([]byte, len(contents))
+	for i, c := range contents {
+		c &= 0x7f
+		if c == '\r' {
+			c = '\n'
+		}
+		text[i] = c
+	}
+
+	return text
+}
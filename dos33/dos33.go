@@ -0,0 +1,159 @@
+package dos33
+
+// Reading and writing files on DOS 3.3 disk images. An image is 35
+// tracks of 16 sectors of 256 bytes in DOS 3.3 order. The VTOC on track 17
+// sector 0 points at the catalog and has a bitmap of the free sectors. Each
+// file has a chain of track/sector list sectors with the sectors of its data.
+
+import (
+	"fmt"
+
+	"github.com/freewilll/apple2-go/disk"
+)
+
+const tracksPerDisk = disk.TracksPerDisk
+const sectorsPerTrack = disk.SectorsPerTrack
+const sectorLength = disk.SectorLength
+const imageLength = disk.ImageLength
+
+const vtocTrack = 17 // Track with the VTOC and catalog
+const maxTrackSectorPairs = 122
+
+// VTOC fields
+const (
+	vtocCatalogTrack    = 0x01
+	vtocCatalogSector   = 0x02
+	vtocDOSRelease      = 0x03
+	vtocVolume          = 0x06
+	vtocMaxPairs        = 0x27
+	vtocLastTrack       = 0x30 // Last track sectors were allocated on
+	vtocDirection       = 0x31 // Direction of allocation, 1 or -1
+	vtocTracksPerDisk   = 0x34
+	vtocSectorsPerTrack = 0x35
+	vtocBytesPerSector  = 0x36
+	vtocBitmap          = 0x38 // 4 bytes for each track, a set bit is a free sector
+)
+
+// Disk is a DOS 3.3 disk image
+type Disk struct {
+	Bytes []byte // The image in DOS 3.3 order
+}
+
+// New returns the disk in a DOS 3.3 order image. The image must have a DOS 3.3
+// VTOC.
+func New(bytes []byte) (*Disk, error) {
+	if len(bytes) != imageLength {
+		return nil, fmt.Errorf("Disk image has invalid length %d, expected %d", len(bytes), imageLength)
+	}
+
+	d := &Disk{Bytes: bytes}
+	vtoc := d.vtoc()
+	if vtoc[vtocMaxPairs] != maxTrackSectorPairs || vtoc[vtocTracksPerDisk] != tracksPerDisk || vtoc[vtocSectorsPerTrack] != sectorsPerTrack {
+		return nil, fmt.Errorf("Disk image doesn't have a DOS 3.3 VTOC")
+	}
+
+	return d, nil
+}
+
+// sector returns the 256 bytes of a sector
+func (d *Disk) sector(track uint8, sector uint8) []byte {
+	offset := (int(track)*sectorsPerTrack + int(sector)) * sectorLength
+	return d.Bytes[offset : offset+sectorLength]
+}
+
+// validSector returns true if track and sector are on the disk and not on
+// track 0, which is never used for files
+func validSector(track uint8, sector uint8) bool {
+	return track > 0 && track < tracksPerDisk && sector < sectorsPerTrack
+}
+
+// vtoc returns the VTOC sector
+func (d *Disk) vtoc() []byte {
+	return d.sector(vtocTrack, 0)
+}
+
+// Volume returns the volume number in the VTOC
+func (d *Disk) Volume() int {
+	return int(d.vtoc()[vtocVolume])
+}
+
+// bitmapBit returns the byte of the VTOC bitmap with a sector's bit and the
+// bit's mask
+func (d *Disk) bitmapBit(track uint8, sector uint8) (*byte, uint8) {
+	bitmap := d.vtoc()[vtocBitmap+4*int(track):]
+	if sector >= 8 {
+		return &bitmap[0], 1 << (sector - 8)
+	}
+
+	return &bitmap[1], 1 << sector
+}
+
+// isFree returns true if the bitmap has a sector as free
+func (d *Disk) isFree(track uint8, sector uint8) bool {
+	b, mask := d.bitmapBit(track, sector)
+	return (*b & mask) != 0
+}
+
+// setFree marks a sector as free or in use in the bitmap
+func (d *Disk) setFree(track uint8, sector uint8, free bool) {
+	b, mask := d.bitmapBit(track, sector)
+	if free {
+		*b |= mask
+	} else {
+		*b &^= mask
+	}
+}
+
+// FreeSectors returns the number of free sectors
+func (d *Disk) FreeSectors() int {
+	free := 0
+	for t := uint8(0); t < tracksPerDisk; t++ {
+		for s := uint8(0); s < sectorsPerTrack; s++ {
+			if d.isFree(t, s) {
+				free++
+			}
+		}
+	}
+
+	return free
+}
+
+// allocate marks a free sector as in use, clears it and returns it. Like DOS,
+// it carries on from the last track it allocated on, moving away from the
+// VTOC track, and takes the highest free sector on a track.
+func (d *Disk) allocate() (uint8, uint8, error) {
+	vtoc := d.vtoc()
+	track := int(vtoc[vtocLastTrack])
+	direction := int(int8(vtoc[vtocDirection]))
+	if direction != 1 && direction != -1 {
+		direction = 1
+	}
+
+	for i := 0; i < 2*tracksPerDisk; i++ {
+		if track > 0 && track < tracksPerDisk && track != vtocTrack {
+			for s := sectorsPerTrack - 1; s >= 0; s-- {
+				if d.isFree(uint8(track), uint8(s)) {
+					d.setFree(uint8(track), uint8(s), false)
+					vtoc[vtocLastTrack] = uint8(track)
+					vtoc[vtocDirection] = uint8(int8(direction))
+
+					sector := d.sector(uint8(track), uint8(s))
+					for j := range sector {
+						sector[j] = 0
+					}
+
+					return uint8(track), uint8(s), nil
+				}
+			}
+		}
+
+		// Turn around at either end of the disk and start next to the VTOC
+		track += direction
+		if track <= 0 || track >= tracksPerDisk {
+			direction = -direction
+			track = vtocTrack + direction
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Disk full")
+}
@@ -0,0 +1,367 @@
+package dos33
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Catalog sector and file entry fields
+const (
+	catalogNextTrack  = 0x01
+	catalogNextSector = 0x02
+	catalogEntries    = 0x0b // Offset of the first file entry
+	entriesPerSector  = 7
+	entryLength       = 0x23
+
+	entryTrack       = 0x00 // Track of the first track/sector list, 0 if the entry has never been used
+	entrySector      = 0x01
+	entryType        = 0x02
+	entryName        = 0x03
+	entrySectorCount = 0x21 // Number of sectors used by the file, including track/sector lists
+
+	deletedTrack  = 0xff // Track of a deleted file, whose track moves to the last name byte
+	maxNameLength = entrySectorCount - entryName
+	lockedFlag    = 0x80 // Set in the type of a locked file
+)
+
+// Track/sector list fields
+const (
+	listNextTrack  = 0x01
+	listNextSector = 0x02
+	listOffset     = 0x05 // Number of data sectors in the earlier lists
+	listPairs      = 0x0c
+)
+
+const maxCatalogSectors = tracksPerDisk * sectorsPerTrack // Catalogs longer than this have a loop
+
+// FileType is the type of a DOS 3.3 file
+type FileType uint8
+
+// DOS 3.3 file types
+const (
+	Text        FileType = 0x00
+	Integer     FileType = 0x01 // Integer BASIC program
+	Applesoft   FileType = 0x02 // Applesoft BASIC program
+	Binary      FileType = 0x04
+	SType       FileType = 0x08
+	Relocatable FileType = 0x10
+	AType       FileType = 0x20
+	BType       FileType = 0x40
+)
+
+var fileTypeLetters = []struct {
+	fileType FileType
+	letter   string
+}{
+	{Text, "T"}, {Integer, "I"}, {Applesoft, "A"}, {Binary, "B"},
+	{SType, "S"}, {Relocatable, "R"}, {AType, "A"}, {BType, "B"},
+}
+
+// String returns the letter DOS shows in the catalog for the type
+func (t FileType) String() string {
+	for _, l := range fileTypeLetters {
+		if l.fileType == t {
+			return l.letter
+		}
+	}
+
+	return "?"
+}
+
+// ParseFileType returns the file type for one of the letters T, I, A, B, S or
+// R
+func ParseFileType(s string) (FileType, error) {
+	for _, l := range fileTypeLetters {
+		if strings.EqualFold(l.letter, s) {
+			return l.fileType, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid file type %q, expected T, I, A, B, S or R", s)
+}
+
+// File is a file in the catalog
+type File struct {
+	Name    string
+	Type    FileType
+	Locked  bool
+	Sectors int // Number of sectors used by the file, including track/sector lists
+
+	entry []byte // The file's catalog entry
+}
+
+// catalogEntries returns the file entries of all catalog sectors, including
+// unused and deleted ones
+func (d *Disk) catalogEntries() ([][]byte, error) {
+	var entries [][]byte
+
+	vtoc := d.vtoc()
+	track, sector := vtoc[vtocCatalogTrack], vtoc[vtocCatalogSector]
+	for i := 0; track != 0; i++ {
+		if !validSector(track, sector) || i == maxCatalogSectors {
+			return nil, fmt.Errorf("Invalid catalog sector T%d S%d", track, sector)
+		}
+
+		catalog := d.sector(track, sector)
+		for e := 0; e < entriesPerSector; e++ {
+			offset := catalogEntries + e*entryLength
+			entries = append(entries, catalog[offset:offset+entryLength])
+		}
+
+		track, sector = catalog[catalogNextTrack], catalog[catalogNextSector]
+	}
+
+	return entries, nil
+}
+
+// entryFileName returns the name in a file entry, without the padding
+func entryFileName(entry []byte) string {
+	name := make([]byte, maxNameLength)
+	for i := range name {
+		name[i] = entry[entryName+i] & 0x7f
+	}
+
+	return strings.TrimRight(string(name), " ")
+}
+
+// Catalog returns the files on the disk in catalog order
+func (d *Disk) Catalog() ([]File, error) {
+	entries, err := d.catalogEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, entry := range entries {
+		if entry[entryTrack] == 0 || entry[entryTrack] == deletedTrack {
+			continue
+		}
+
+		files = append(files, File{
+			Name:    entryFileName(entry),
+			Type:    FileType(entry[entryType] &^ lockedFlag),
+			Locked:  (entry[entryType] & lockedFlag) != 0,
+			Sectors: int(entry[entrySectorCount]) | int(entry[entrySectorCount+1])<<8,
+			entry:   entry,
+		})
+	}
+
+	return files, nil
+}
+
+// findFile returns the file with a name
+func (d *Disk) findFile(name string) (*File, error) {
+	files, err := d.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		if files[i].Name == name {
+			return &files[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("File %q not found", name)
+}
+
+// trackSectorList is a track/sector list sector and the sectors of the data
+// it lists
+type trackSectorList struct {
+	track, sector uint8
+	pairs         [][2]uint8 // Track and sector of each data sector, track 0 for a hole
+}
+
+// trackSectorLists returns the track/sector lists of a file
+func (d *Disk) trackSectorLists(f *File) ([]trackSectorList, error) {
+	var lists []trackSectorList
+
+	track, sector := f.entry[entryTrack], f.entry[entrySector]
+	for i := 0; track != 0; i++ {
+		if !validSector(track, sector) || i == maxCatalogSectors {
+			return nil, fmt.Errorf("Invalid track/sector list sector T%d S%d in %q", track, sector, f.Name)
+		}
+
+		list := trackSectorList{track: track, sector: sector}
+		data := d.sector(track, sector)
+		for p := 0; p < maxTrackSectorPairs; p++ {
+			t, s := data[listPairs+2*p], data[listPairs+2*p+1]
+			if t != 0 && !validSector(t, s) {
+				return nil, fmt.Errorf("Invalid data sector T%d S%d in %q", t, s, f.Name)
+			}
+			list.pairs = append(list.pairs, [2]uint8{t, s})
+		}
+		lists = append(lists, list)
+
+		track, sector = data[listNextTrack], data[listNextSector]
+	}
+
+	return lists, nil
+}
+
+// ReadFile returns a file's type and the contents of its data sectors, up to
+// the last one that's in use. Holes in random access text files are zeroes.
+func (d *Disk) ReadFile(name string) (FileType, []byte, error) {
+	f, err := d.findFile(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lists, err := d.trackSectorLists(f)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var data []byte
+	length := 0
+	for _, list := range lists {
+		for _, pair := range list.pairs {
+			if pair[0] == 0 {
+				data = append(data, make([]byte, sectorLength)...)
+				continue
+			}
+
+			data = append(data, d.sector(pair[0], pair[1])...)
+			length = len(data)
+		}
+	}
+
+	return f.Type, data[:length], nil
+}
+
+// checkFileName returns an error if a name can't be used for a DOS 3.3 file
+func checkFileName(name string) error {
+	if len(name) == 0 || len(name) > maxNameLength {
+		return fmt.Errorf("Invalid file name %q, it must have 1 to %d characters", name, maxNameLength)
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c >= 0x7f || c == ',' {
+			return fmt.Errorf("Invalid file name %q, it must be printable ASCII without commas", name)
+		}
+	}
+
+	if c := name[0] &^ 0x20; c < 'A' || c > 'Z' {
+		return fmt.Errorf("Invalid file name %q, it must start with a letter", name)
+	}
+
+	return nil
+}
+
+// WriteFile writes a file with a type and the contents of its data sectors. An
+// existing file with the same name is replaced, unless it's locked. Nothing is
+// changed if there isn't enough room.
+func (d *Disk) WriteFile(name string, fileType FileType, data []byte) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+
+	// Work on a copy, so a full disk or catalog leaves the image as it was
+	image := &Disk{Bytes: append([]byte(nil), d.Bytes...)}
+
+	if _, err := image.findFile(name); err == nil {
+		if err := image.DeleteFile(name); err != nil {
+			return err
+		}
+	}
+
+	entries, err := image.catalogEntries()
+	if err != nil {
+		return err
+	}
+
+	var entry []byte
+	for _, e := range entries {
+		if e[entryTrack] == 0 || e[entryTrack] == deletedTrack {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("Catalog full")
+	}
+
+	// Write the data sectors, starting a new track/sector list every 122 sectors
+	var list []byte
+	var firstTrack, firstSector uint8
+	sectors := 0
+	for i := 0; i == 0 || i*sectorLength < len(data); i++ {
+		if i%maxTrackSectorPairs == 0 {
+			track, sector, err := image.allocate()
+			if err != nil {
+				return err
+			}
+			sectors++
+
+			if list == nil {
+				firstTrack, firstSector = track, sector
+			} else {
+				list[listNextTrack], list[listNextSector] = track, sector
+			}
+			list = image.sector(track, sector)
+			list[listOffset] = uint8(i)
+			list[listOffset+1] = uint8(i >> 8)
+		}
+
+		if i*sectorLength == len(data) {
+			break // An empty file has a track/sector list and no data
+		}
+
+		track, sector, err := image.allocate()
+		if err != nil {
+			return err
+		}
+		sectors++
+
+		pair := listPairs + 2*(i%maxTrackSectorPairs)
+		list[pair], list[pair+1] = track, sector
+		copy(image.sector(track, sector), data[i*sectorLength:])
+	}
+
+	entry[entryTrack] = firstTrack
+	entry[entrySector] = firstSector
+	entry[entryType] = uint8(fileType)
+	for i := 0; i < maxNameLength; i++ {
+		entry[entryName+i] = ' ' | 0x80
+		if i < len(name) {
+			entry[entryName+i] = name[i] | 0x80
+		}
+	}
+	entry[entrySectorCount] = uint8(sectors)
+	entry[entrySectorCount+1] = uint8(sectors >> 8)
+
+	copy(d.Bytes, image.Bytes)
+	return nil
+}
+
+// DeleteFile deletes an unlocked file and frees its sectors. Like DOS, the
+// track of the first track/sector list is kept in the last byte of the name.
+func (d *Disk) DeleteFile(name string) error {
+	f, err := d.findFile(name)
+	if err != nil {
+		return err
+	}
+
+	if f.Locked {
+		return fmt.Errorf("File %q is locked", name)
+	}
+
+	lists, err := d.trackSectorLists(f)
+	if err != nil {
+		return err
+	}
+
+	for _, list := range lists {
+		for _, pair := range list.pairs {
+			if pair[0] != 0 {
+				d.setFree(pair[0], pair[1], true)
+			}
+		}
+		d.setFree(list.track, list.sector, true)
+	}
+
+	f.entry[entryName+maxNameLength-1] = f.entry[entryTrack]
+	f.entry[entryTrack] = deletedTrack
+
+	return nil
+}
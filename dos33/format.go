@@ -0,0 +1,54 @@
+package dos33
+
+import (
+	"fmt"
+)
+
+const dosTracks = 3 // Tracks 0-2 have DOS itself
+const dosRelease = 3
+
+// Format returns a DOS 3.3 image like one made by INIT, with an empty catalog
+// on track 17 and tracks 0-2 in use. If master isn't nil, tracks 0-2 are
+// copied from it, so the image boots the DOS on the master. master is a DOS
+// 3.3 order image.
+func Format(volume uint8, master []byte) ([]byte, error) {
+	if volume == 0 {
+		return nil, fmt.Errorf("Invalid volume number 0, expected 1 to 254")
+	}
+
+	image := make([]byte, imageLength)
+	if master != nil {
+		if len(master) != imageLength {
+			return nil, fmt.Errorf("DOS master image has invalid length %d, expected %d", len(master), imageLength)
+		}
+		copy(image, master[:dosTracks*sectorsPerTrack*sectorLength])
+	}
+
+	d := &Disk{Bytes: image}
+	vtoc := d.vtoc()
+	vtoc[vtocCatalogTrack] = vtocTrack
+	vtoc[vtocCatalogSector] = sectorsPerTrack - 1
+	vtoc[vtocDOSRelease] = dosRelease
+	vtoc[vtocVolume] = volume
+	vtoc[vtocMaxPairs] = maxTrackSectorPairs
+	vtoc[vtocLastTrack] = vtocTrack
+	vtoc[vtocDirection] = 1
+	vtoc[vtocTracksPerDisk] = tracksPerDisk
+	vtoc[vtocSectorsPerTrack] = sectorsPerTrack
+	vtoc[vtocBytesPerSector+1] = sectorLength >> 8
+
+	for t := uint8(dosTracks); t < tracksPerDisk; t++ {
+		for s := uint8(0); s < sectorsPerTrack && t != vtocTrack; s++ {
+			d.setFree(t, s, true)
+		}
+	}
+
+	// The catalog goes backwards from sector 15 to sector 1
+	for s := uint8(sectorsPerTrack - 1); s > 1; s-- {
+		catalog := d.sector(vtocTrack, s)
+		catalog[catalogNextTrack] = vtocTrack
+		catalog[catalogNextSector] = s - 1
+	}
+
+	return image, nil
+}
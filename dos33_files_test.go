@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/freewilll/apple2-go/dos33"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDOS33Files tests listing, reading, writing and deleting files on a DOS
+// 3.3 disk image
+func TestDOS33Files(t *testing.T) {
+	t.Parallel()
+
+	_, err := dos33.New(make([]byte, 35*16*0x100))
+	assert.NotNil(t, err)
+
+	image, err := dos33.Format(254, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dos33.New(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 254, d.Volume())
+	assert.Equal(t, 31*16, d.FreeSectors())
+	files, err := d.Catalog()
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+
+	// A binary file with 300 bytes of data takes a track/sector list and two
+	// data sectors
+	program := make([]byte, 300)
+	for i := range program {
+		program[i] = uint8(i)
+	}
+	contents, err := dos33.EncodeBinary(0x0803, program)
+	assert.Nil(t, err)
+	assert.Nil(t, d.WriteFile("HELLO", dos33.Binary, contents))
+	assert.Equal(t, 31*16-3, d.FreeSectors())
+
+	fileType, contents, err := d.ReadFile("HELLO")
+	assert.Nil(t, err)
+	assert.Equal(t, dos33.Binary, fileType)
+	address, data, err := dos33.DecodeBinary(contents)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(0x0803), address)
+	assert.Equal(t, program, data)
+
+	// The length of a binary file is 16 bits
+	_, err = dos33.EncodeBinary(0x0803, make([]byte, 0x10000))
+	assert.NotNil(t, err)
+
+	// A file with more than 122 sectors has two track/sector lists
+	big := bytes.Repeat([]byte{0xa5}, 130*0x100)
+	assert.Nil(t, d.WriteFile("BIG FILE", dos33.SType, big))
+	_, contents, err = d.ReadFile("BIG FILE")
+	assert.Nil(t, err)
+	assert.Equal(t, big, contents)
+
+	text := []byte("LINE 1\nLINE 2\n")
+	assert.Nil(t, d.WriteFile("NOTES", dos33.Text, dos33.EncodeText(text)))
+	_, contents, err = d.ReadFile("NOTES")
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x8d), contents[6])
+	assert.Equal(t, text, dos33.DecodeText(contents))
+
+	files, err = d.Catalog()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, "BIG FILE", files[1].Name)
+	assert.Equal(t, "S", files[1].Type.String())
+	assert.Equal(t, 132, files[1].Sectors)
+	assert.Equal(t, 31*16-3-132-2, d.FreeSectors())
+
+	// Writing a file again replaces it
+	contents, err = dos33.EncodeBinary(0x0803, program[:10])
+	assert.Nil(t, err)
+	assert.Nil(t, d.WriteFile("HELLO", dos33.Binary, contents))
+	files, err = d.Catalog()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, 31*16-2-132-2, d.FreeSectors())
+
+	// Deleting a file frees its sectors
+	assert.Nil(t, d.DeleteFile("BIG FILE"))
+	assert.Equal(t, 31*16-2-2, d.FreeSectors())
+	_, _, err = d.ReadFile("BIG FILE")
+	assert.NotNil(t, err)
+	assert.NotNil(t, d.DeleteFile("BIG FILE"))
+
+	// A file that doesn't fit leaves the disk as it was
+	before := append([]byte(nil), d.Bytes...)
+	assert.NotNil(t, d.WriteFile("HUGE", dos33.Binary, make([]byte, 31*16*0x100)))
+	assert.Equal(t, before, d.Bytes)
+
+	// Locked files can't be deleted or replaced
+	d.Bytes[(17*16+15)*0x100+0x0b+2] |= 0x80
+	files, err = d.Catalog()
+	assert.Nil(t, err)
+	assert.True(t, files[0].Locked)
+	assert.NotNil(t, d.DeleteFile("HELLO"))
+	assert.NotNil(t, d.WriteFile("HELLO", dos33.Binary, nil))
+
+	// Invalid names
+	assert.NotNil(t, d.WriteFile("1ST", dos33.Text, nil))
+	assert.NotNil(t, d.WriteFile("A,B", dos33.Text, nil))
+	assert.NotNil(t, d.WriteFile(string(bytes.Repeat([]byte{'A'}, 31)), dos33.Text, nil))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/dos33"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/freewilll/apple2-go/prodos"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFormat tests creating blank DOS 3.3 and ProDOS images and that they're
+// recognized when they're inserted into a drive
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	insert := func(name string, image []byte) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, image, 0644); err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	}
+
+	// DOS 3.3 with DOS copied from tracks 0-2 of a master
+	master := make([]byte, disk.ImageLength)
+	for i := range master {
+		master[i] = byte(i / disk.SectorLength)
+	}
+	image, err := dos33.Format(10, master)
+	assert.Nil(t, err)
+	assert.Equal(t, master[:3*16*0x100], image[:3*16*0x100])
+	assert.Equal(t, make([]byte, 0x100), image[3*16*0x100:3*16*0x100+0x100])
+
+	insert("dos.dsk", image)
+	assert.Equal(t, "DOS 3.3", m.Disk.SectorOrder(1))
+	assert.Equal(t, 10, m.Disk.Volume(1))
+
+	d, err := dos33.New(image)
+	assert.Nil(t, err)
+	files, err := d.Catalog()
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+
+	_, err = dos33.Format(0, nil)
+	assert.NotNil(t, err)
+	_, err = dos33.Format(254, master[:100])
+	assert.NotNil(t, err)
+
+	// ProDOS with a boot loader, in DOS 3.3 and ProDOS order images
+	boot := make([]byte, 1024)
+	boot[0] = 0x01
+	volume, err := prodos.Format("work", 280, boot)
+	assert.Nil(t, err)
+	assert.Equal(t, boot, volume[:1024])
+
+	v, err := prodos.New(volume)
+	assert.Nil(t, err)
+	assert.Equal(t, "WORK", v.Name())
+	assert.Equal(t, 280-7, v.FreeBlocks())
+
+	insert("prodos.dsk", disk.DOSOrderImage(volume))
+	assert.Equal(t, "DOS 3.3", m.Disk.SectorOrder(1))
+	insert("prodos.po", volume)
+	assert.Equal(t, "ProDOS", m.Disk.SectorOrder(1))
+
+	// Hard disk volumes have a bitmap block for every 4096 blocks
+	volume, err = prodos.Format("HARD.DISK", 5000, nil)
+	assert.Nil(t, err)
+	v, err = prodos.New(volume)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000-8, v.FreeBlocks())
+
+	_, err = prodos.Format("1ST", 280, nil)
+	assert.NotNil(t, err)
+	_, err = prodos.Format("SMALL", 7, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/freewilll/apple2-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestHardDiskImage writes a hard disk image with blocks blocks to dir.
+// Each byte of a block is the block number. If header is set, the image has a
+// 2IMG header.
+func writeTestHardDiskImage(t *testing.T, dir string, name string, blocks int, header []byte) string {
+	data := make([]byte, blocks*512)
+	for i := range data {
+		data[i] = uint8(i / 512)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, append(header, data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// callHardDiskDriver calls the ProDOS driver in the hard disk card's ROM and
+// returns the A, X and Y registers and the carry flag
+func callHardDiskDriver(t *testing.T, m *machine.Machine, command uint8, unit uint8, buffer uint16, block uint16) (uint8, uint8, uint8, bool) {
+	m.MMU.WriteMemory(0x42, command)
+	m.MMU.WriteMemory(0x43, unit)
+	m.MMU.WriteMemory(0x44, uint8(buffer))
+	m.MMU.WriteMemory(0x45, uint8(buffer>>8))
+	m.MMU.WriteMemory(0x46, uint8(block))
+	m.MMU.WriteMemory(0x47, uint8(block>>8))
+
+	// JSR $C780, JMP *
+	for i, b := range []uint8{0x20, 0x80, 0xc7, 0x4c, 0x03, 0x03} {
+		m.MMU.WriteMemory(0x300+uint16(i), b)
+	}
+
+	m.CPU.State.PC = 0x300
+	utils.RunUntilBreakPoint(t, m.CPU, 0x303, 1, false, "hard disk driver call")
+
+	return m.CPU.State.A, m.CPU.State.X, m.CPU.State.Y, (m.CPU.State.P & 1) != 0
+}
+
+// TestHardDiskBoot tests that the hard disk card's ROM loads block 0 and runs
+// it, and that it carries on with the next slot if there is no image
+func TestHardDiskBoot(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// Without an image, the autostart ROM's slot scan continues at slot 6
+	m.MMU.WriteMemory(0x00, 0x00)
+	m.MMU.WriteMemory(0x01, 0xc7)
+	m.CPU.State.PC = 0xc700
+	utils.RunUntilBreakPoint(t, m.CPU, 0xfaba, 1, false, "slot scan")
+
+	assert.Nil(t, m.HardDisk.InsertImage(1, writeTestHardDiskImage(t, dir, "test.po", 1600, nil)))
+	assert.Equal(t, 1600, m.HardDisk.BlockCount(1))
+
+	m.CPU.State.PC = 0xc700
+	utils.RunUntilBreakPoint(t, m.CPU, 0x0801, 1, false, "boot block")
+	assert.Equal(t, uint8(0x70), m.CPU.State.X)
+	assert.Equal(t, uint8(0), m.MMU.ReadMemory(0x0800))
+	assert.Equal(t, uint8(0), m.MMU.ReadMemory(0x09ff))
+}
+
+// TestHardDisk tests the status, read and write calls of the ProDOS driver
+func TestHardDisk(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	// Drive 2 has a 2IMG image with a comment after the data
+	header := make([]byte, 64)
+	copy(header, "2IMG")
+	binary.LittleEndian.PutUint16(header[8:], 64)
+	binary.LittleEndian.PutUint32(header[12:], 1) // ProDOS order
+	binary.LittleEndian.PutUint32(header[20:], 280)
+	binary.LittleEndian.PutUint32(header[24:], 64)
+	binary.LittleEndian.PutUint32(header[28:], 280*512)
+	twoIMG := writeTestHardDiskImage(t, dir, "test.2mg", 280, header)
+	f, err := os.OpenFile(twoIMG, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("comment")
+	f.Close()
+
+	po := writeTestHardDiskImage(t, dir, "test.po", 0x1234, nil)
+	assert.Nil(t, m.HardDisk.InsertImage(1, po))
+	assert.Nil(t, m.HardDisk.InsertImage(2, twoIMG))
+
+	// Status returns the number of blocks
+	a, x, y, carry := callHardDiskDriver(t, m, 0, 0x70, 0, 0)
+	assert.Equal(t, []uint8{0, 0x34, 0x12}, []uint8{a, x, y})
+	assert.False(t, carry)
+	a, x, y, carry = callHardDiskDriver(t, m, 0, 0xf0, 0, 0)
+	assert.Equal(t, []uint8{0, 0x18, 0x01}, []uint8{a, x, y})
+	assert.False(t, carry)
+
+	// Read a block from each drive
+	a, _, _, carry = callHardDiskDriver(t, m, 1, 0x70, 0x2000, 0x0105)
+	assert.Equal(t, uint8(0), a)
+	assert.False(t, carry)
+	assert.Equal(t, uint8(0x05), m.MMU.ReadMemory(0x2000))
+	assert.Equal(t, uint8(0x05), m.MMU.ReadMemory(0x21ff))
+	callHardDiskDriver(t, m, 1, 0xf0, 0x2000, 279)
+	assert.Equal(t, uint8(23), m.MMU.ReadMemory(0x2000))
+
+	// Blocks past the end of the image are an IO error
+	a, _, _, carry = callHardDiskDriver(t, m, 1, 0xf0, 0x2000, 280)
+	assert.Equal(t, uint8(0x27), a)
+	assert.True(t, carry)
+
+	// Write a block to each drive and read it back
+	for i := uint16(0); i < 0x200; i++ {
+		m.MMU.WriteMemory(0x2000+i, uint8(i)^0xa5)
+	}
+	for _, unit := range []uint8{0x70, 0xf0} {
+		a, _, _, carry = callHardDiskDriver(t, m, 2, unit, 0x2000, 3)
+		assert.Equal(t, uint8(0), a)
+		assert.False(t, carry)
+		callHardDiskDriver(t, m, 1, unit, 0x4000, 3)
+		assert.Equal(t, m.MMU.ReadMemory(0x2000), m.MMU.ReadMemory(0x4000))
+		assert.Equal(t, m.MMU.ReadMemory(0x21ff), m.MMU.ReadMemory(0x41ff))
+	}
+
+	// The writes are flushed on eject, keeping the 2IMG header and comment
+	assert.Nil(t, m.HardDisk.EjectImage(1))
+	assert.Nil(t, m.HardDisk.EjectImage(2))
+	written, err := ioutil.ReadFile(po)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint8(0xa5), written[3*512])
+	assert.Equal(t, uint8(4), written[4*512])
+	written, err = ioutil.ReadFile(twoIMG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, header, written[:64])
+	assert.Equal(t, uint8(0xa5), written[64+3*512])
+	assert.Equal(t, "comment", string(written[len(written)-7:]))
+
+	// An empty drive isn't connected
+	a, _, _, carry = callHardDiskDriver(t, m, 0, 0x70, 0, 0)
+	assert.Equal(t, uint8(0x28), a)
+	assert.True(t, carry)
+
+	// A locked 2IMG image is write protected
+	binary.LittleEndian.PutUint32(header[16:], 1<<31)
+	locked := writeTestHardDiskImage(t, dir, "locked.2mg", 280, header)
+	assert.Nil(t, m.HardDisk.InsertImage(1, locked))
+	assert.True(t, m.HardDisk.WriteProtected(1))
+	a, _, _, carry = callHardDiskDriver(t, m, 2, 0x70, 0x2000, 0)
+	assert.Equal(t, uint8(0x2b), a)
+	assert.True(t, carry)
+
+	// The unused IO addresses of the card read as 0 and ignore writes
+	for address := uint16(0xc0f4); address <= 0xc0ff; address++ {
+		assert.Equal(t, uint8(0), m.MMU.ReadMemory(address))
+		m.MMU.WriteMemory(address, 0xff)
+	}
+	assert.Equal(t, uint8(0), m.MMU.ReadMemory(0xc0f0))
+	m.MMU.WriteMemory(0xc0f1, 0xff)
+
+	// Images that aren't a whole number of blocks or are too big are refused
+	bad := filepath.Join(dir, "bad.po")
+	if err := ioutil.WriteFile(bad, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, m.HardDisk.InsertImage(1, bad))
+	if err := ioutil.WriteFile(bad, make([]byte, 32*1024*1024+512), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, m.HardDisk.InsertImage(1, bad))
+}
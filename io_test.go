@@ -4,28 +4,97 @@ import (
 	"testing"
 
 	"github.com/freewilll/apple2-go/cpu"
-	"github.com/freewilll/apple2-go/keyboard"
-	"github.com/freewilll/apple2-go/mmu"
-	"github.com/freewilll/apple2-go/system"
-	"github.com/freewilll/apple2-go/video"
+	"github.com/freewilll/apple2-go/machine"
 	"github.com/stretchr/testify/assert"
 )
 
 // TestIoBankSwitching tests the switching of the IO memory ROM at $c000-$c7ff
 func TestIoBankSwitching(t *testing.T) {
-	cpu.InitInstructionDecoder()
-	mmu.InitRAM()
-	mmu.InitApple2eROM()
-	mmu.InitIO()
-	cpu.Init()
-	keyboard.Init()
-	video.Init()
-	system.Init()
-	cpu.SetColdStartReset()
-	cpu.Reset()
-
-	mmu.MapFirstHalfOfIO()
-	assert.Equal(t, uint8(0xa2), mmu.ReadMemory(0xc600)) // read from Primary Slot 6 ROM
-	mmu.MapSecondHalfOfIO()
-	assert.Equal(t, uint8(0x8d), mmu.ReadMemory(0xc600)) // read from Primary Slot 6 ROM
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	m.MMU.MapFirstHalfOfIO()
+	assert.Equal(t, uint8(0xa2), m.MMU.ReadMemory(0xc600)) // read from Primary Slot 6 ROM
+	m.MMU.MapSecondHalfOfIO()
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc600)) // read from Primary Slot 6 ROM
+}
+
+// TestC3RomSwitching tests the mapping of the internal 80 column firmware at
+// $c300 and $c800-$cfff and the 80 column display soft switches
+func TestC3RomSwitching(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	// The internal ROM is mapped to $c300 at startup
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc017)) // RDC3ROM
+	assert.Equal(t, m.MMU.PhysicalMemory.RomC2[0x300], m.MMU.ReadMemory(0xc300))
+	assert.Equal(t, m.MMU.PhysicalMemory.RomC2[0x800], m.MMU.ReadMemory(0xc800))
+
+	// An access to $cfff unmaps the internal $c800 ROM
+	m.MMU.ReadMemory(0xcfff)
+	assert.Equal(t, false, m.MMU.IntC8Rom)
+	assert.Equal(t, m.MMU.PhysicalMemory.RomC1[0x800], m.MMU.ReadMemory(0xc800))
+
+	// Switch to the slot 3 ROM
+	m.MMU.WriteMemory(0xc00b, 0x00) // SETC3ROM
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc017))
+	assert.Equal(t, m.MMU.PhysicalMemory.RomC1[0x300], m.MMU.ReadMemory(0xc300))
+	assert.Equal(t, false, m.MMU.IntC8Rom)
+	m.MMU.WriteMemory(0xc00a, 0x00) // CLRC3ROM
+
+	// 80 column display and the alternate character set
+	m.MMU.WriteMemory(0xc00d, 0x00) // SET80VID
+	m.MMU.WriteMemory(0xc00f, 0x00) // SETALTCH
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc01f))
+	assert.Equal(t, uint8(0x8d), m.MMU.ReadMemory(0xc01e))
+	m.MMU.WriteMemory(0xc00c, 0x00) // CLR80VID
+	m.MMU.WriteMemory(0xc00e, 0x00) // CLRALTCH
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc01f))
+	assert.Equal(t, uint8(0x0d), m.MMU.ReadMemory(0xc01e))
+}
+
+// TestAnnunciators tests the annunciator soft switches that are used to
+// enable double hires
+func TestAnnunciators(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	for i := uint16(0); i < 4; i++ {
+		m.MMU.ReadMemory(0xc059 + i*2)
+		assert.Equal(t, true, m.MMU.Annunciators[i])
+		m.MMU.WriteMemory(0xc058+i*2, 0x00)
+		assert.Equal(t, false, m.MMU.Annunciators[i])
+	}
+}
+
+// TestJoystick tests the pushbuttons and the paddle timers
+func TestJoystick(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	// Pushbuttons
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0xc061))
+	m.Joystick.Buttons[0] = true
+	m.Joystick.Buttons[2] = true
+	assert.Equal(t, uint8(0x80), m.MMU.ReadMemory(0xc061))
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0xc062))
+	assert.Equal(t, uint8(0x80), m.MMU.ReadMemory(0xc063))
+
+	// The paddle 0 timer runs for 11 cycles per unit after a trigger
+	m.Joystick.Paddles[0] = 10
+	m.Joystick.Paddles[1] = 0
+	m.MMU.ReadMemory(0xc070)
+	m.System.FrameCycles += 109
+	assert.Equal(t, uint8(0x80), m.MMU.ReadMemory(0xc064))
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0xc065))
+	m.System.FrameCycles++
+	assert.Equal(t, uint8(0x00), m.MMU.ReadMemory(0xc064))
+
+	// A write also triggers the timers
+	m.MMU.WriteMemory(0xc070, 0)
+	assert.Equal(t, uint8(0x80), m.MMU.ReadMemory(0xc064))
 }
@@ -0,0 +1,62 @@
+package joystick
+
+// The paddles are read with a timer. A read of $c070 starts the timers of all
+// four paddles. The high bit of $c064-$c067 stays set until the paddle's
+// timer has run out, which takes longer for higher paddle values. The
+// firmware's PREAD counts the number of 11 cycle loops until it's cleared.
+
+import "github.com/freewilll/apple2-go/system"
+
+const (
+	// PaddleCenter is the value of a centered joystick
+	PaddleCenter = 127
+
+	paddleCyclesPerUnit = 11 // CPU cycles per unit of paddle value
+)
+
+// Joystick has the state of the paddles and pushbuttons of a machine
+type Joystick struct {
+	System *system.System
+
+	Paddles [4]uint8 // Paddle values from 0 to 255
+	Buttons [3]bool  // Pushbuttons 0 (open apple), 1 (closed apple) and 2
+
+	triggerCycle uint64 // CPU cycle when the paddle timers were last started
+}
+
+// New creates a joystick with both axes centered and no buttons pressed
+func New(s *system.System) *Joystick {
+	return &Joystick{
+		System:  s,
+		Paddles: [4]uint8{PaddleCenter, PaddleCenter, PaddleCenter, PaddleCenter},
+	}
+}
+
+// cycles returns the number of CPU cycles since startup
+func (j *Joystick) cycles() uint64 {
+	return j.System.Cycles + j.System.FrameCycles
+}
+
+// Trigger starts the paddle timers
+func (j *Joystick) Trigger() {
+	j.triggerCycle = j.cycles()
+}
+
+// ReadPaddle returns $80 while the timer of paddle n is running and 0 when it
+// has run out
+func (j *Joystick) ReadPaddle(n int) uint8 {
+	if j.cycles()-j.triggerCycle < uint64(j.Paddles[n])*paddleCyclesPerUnit {
+		return 0x80
+	}
+
+	return 0
+}
+
+// ReadButton returns $80 if pushbutton n is pressed and 0 otherwise
+func (j *Joystick) ReadButton(n int) uint8 {
+	if j.Buttons[n] {
+		return 0x80
+	}
+
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeyboardType tests typing queued text into $c000 and $c010
+func TestKeyboardType(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+	m.Keyboard.Type("RUN\r\n")
+	assert.Equal(t, 4, m.Keyboard.Pending())
+
+	// The key stays in $c000 until the strobe is cleared
+	assert.Equal(t, uint8('R'|0x80), m.MMU.ReadMemory(0xc000))
+	assert.Equal(t, uint8('R'|0x80), m.MMU.ReadMemory(0xc000))
+	assert.Equal(t, uint8('R'), m.MMU.ReadMemory(0xc010)) // No key is held down
+	assert.Equal(t, uint8('U'|0x80), m.MMU.ReadMemory(0xc000))
+
+	// Clearing the strobe before the key has been read sends it again. Reading
+	// $c010 puts N in $c000 and clears the strobe straight away.
+	m.MMU.WriteMemory(0xc010, 0x00)
+	assert.Equal(t, uint8('N'), m.MMU.ReadMemory(0xc010))
+	assert.Equal(t, 2, m.Keyboard.Pending())
+	assert.Equal(t, uint8('N'|0x80), m.MMU.ReadMemory(0xc000))
+	m.MMU.WriteMemory(0xc010, 0x00)
+	m.MMU.WriteMemory(0xc010, 0x00)
+	assert.Equal(t, uint8('\r'|0x80), m.MMU.ReadMemory(0xc000))
+	m.MMU.WriteMemory(0xc010, 0x00)
+
+	// Once the queue is empty, the last key remains with the strobe cleared
+	assert.Equal(t, uint8('\r'), m.MMU.ReadMemory(0xc000))
+	assert.Equal(t, 0, m.Keyboard.Pending())
+}
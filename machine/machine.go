@@ -0,0 +1,89 @@
+package machine
+
+// The machine package wires all the components of an Apple //e together.
+// Each Machine has its own state, so several of them can run side by side.
+
+import (
+	"time"
+
+	"github.com/freewilll/apple2-go/audio"
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/joystick"
+	"github.com/freewilll/apple2-go/keyboard"
+	"github.com/freewilll/apple2-go/mmu"
+	"github.com/freewilll/apple2-go/system"
+	"github.com/freewilll/apple2-go/video"
+)
+
+// Machine is a complete Apple //e
+type Machine struct {
+	System   *system.System
+	CPU      *cpu.CPU
+	MMU      *mmu.MMU
+	Disk     *disk.Controller
+	HardDisk *disk.HardDisk
+	Keyboard *keyboard.Keyboard
+	Joystick *joystick.Joystick
+	Video    *video.Video
+	Audio    *audio.Audio
+}
+
+// New creates an Apple //e with the ROM loaded, empty disk drives and a hard
+// disk card in slot 7. The CPU is set up so that the next call to Run() does
+// a cold start.
+func New(cpuModel int) *Machine {
+	m := &Machine{}
+	m.System = system.New()
+	m.Audio = audio.New(m.System)
+	m.Disk = disk.NewController(m.System)
+	m.HardDisk = disk.NewHardDisk()
+	m.Keyboard = keyboard.New()
+	m.Joystick = joystick.New(m.System)
+	m.MMU = mmu.New(m.System, m.Audio, m.Disk, m.HardDisk, m.Keyboard, m.Joystick)
+	m.CPU = cpu.New(cpuModel, m.MMU, m.System)
+	m.Video = video.New(m.MMU)
+
+	m.MMU.InitRAM()           // Set all switches to bootup values and initialize the page tables
+	m.MMU.InitApple2eROM()    // Load the ROM and init page tables
+	m.MMU.InitIO()            // Init slots, video and disk image statuses
+	m.CPU.SetColdStartReset() // Prepare memory to ensure a cold reset
+	m.CPU.Reset()             // Set the CPU and memory states so that a next call to Run() calls the firmware reset code
+
+	return m
+}
+
+// SetDiskWriteMode sets where writes to floppy and hard disk images end up
+func (m *Machine) SetDiskWriteMode(mode disk.WriteMode) {
+	m.Disk.SetWriteMode(mode)
+	m.HardDisk.SetWriteMode(mode)
+}
+
+// SetAutosaveInterval sets the time between autosaves of the floppy and hard
+// disk images. Zero turns autosaving off.
+func (m *Machine) SetAutosaveInterval(interval time.Duration) {
+	m.Disk.SetAutosaveInterval(interval)
+	m.HardDisk.SetAutosaveInterval(interval)
+}
+
+// Autosave flushes the floppy and hard disk images if it's time for an
+// autosave
+func (m *Machine) Autosave() error {
+	err := m.Disk.Autosave()
+	if hardDiskErr := m.HardDisk.Autosave(); err == nil {
+		err = hardDiskErr
+	}
+
+	return err
+}
+
+// FlushImages writes the floppy and hard disk images that have been written
+// to. It returns the first error, after trying all images.
+func (m *Machine) FlushImages() error {
+	err := m.Disk.FlushImage()
+	if hardDiskErr := m.HardDisk.FlushImages(); err == nil {
+		err = hardDiskErr
+	}
+
+	return err
+}
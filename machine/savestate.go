@@ -0,0 +1,286 @@
+package machine
+
+// Save states are gzipped gob streams. The stream starts with a header
+// containing the format version, followed by the state itself. gob matches
+// fields by name, so fields can be added to saveState without breaking older
+// snapshots, they are simply left at their zero value. If the meaning of an
+// existing field changes, bump saveStateVersion and convert older states in
+// loadState.
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/mmu"
+	"github.com/freewilll/apple2-go/system"
+)
+
+// saveStateMagic identifies a save state file
+const saveStateMagic = "apple2-go save state"
+
+// saveStateVersion is the version of the save state format written by
+// SaveState. Version 2 added the second disk drive. Version 3 has the
+// position of the disk heads in quarter tracks and bits instead of half
+// tracks and nibbles.
+const saveStateVersion = 3
+
+// saveStateHeader precedes the state in a save state file
+type saveStateHeader struct {
+	Magic   string
+	Version int
+}
+
+// saveState is everything that's needed to resume a machine
+type saveState struct {
+	CPUModel   int
+	CPU        cpu.State
+	Cycles     uint64
+	MMU        *mmu.State
+	DriveState system.DriveState
+	Disk       *disk.State
+	HardDisk   *disk.HardDiskState // Missing from states saved before the hard disk card was added
+}
+
+// saveStateV1 is the version 1 save state, from before the second drive. The
+// head position was kept in DriveState.
+type saveStateV1 struct {
+	CPUModel   int
+	CPU        cpu.State
+	Cycles     uint64
+	MMU        *mmu.State
+	DriveState struct {
+		Drive        uint8
+		Spinning     bool
+		Phase        int8
+		Phases       uint8
+		BytePosition int
+		Q6           bool
+		Q7           bool
+	}
+	Disk *struct {
+		ImagePath    string
+		Image        []byte
+		ImageIsDirty bool
+	}
+}
+
+// convert converts a version 1 save state to the current version
+func (v1 *saveStateV1) convert() saveState {
+	state := saveState{
+		CPUModel: v1.CPUModel,
+		CPU:      v1.CPU,
+		Cycles:   v1.Cycles,
+		MMU:      v1.MMU,
+		DriveState: system.DriveState{
+			Drive:    v1.DriveState.Drive,
+			Spinning: v1.DriveState.Spinning,
+			Phases:   v1.DriveState.Phases,
+			Q6:       v1.DriveState.Q6,
+			Q7:       v1.DriveState.Q7,
+		},
+	}
+
+	if v1.Disk != nil {
+		state.Disk = &disk.State{}
+		state.Disk.Drives[0] = disk.DriveState{
+			ImagePath:    v1.Disk.ImagePath,
+			Image:        v1.Disk.Image,
+			ImageIsDirty: v1.Disk.ImageIsDirty,
+			QuarterTrack: int(v1.DriveState.Phase) * 2,
+			BitPosition:  v1.DriveState.BytePosition * 8,
+		}
+	}
+
+	return state
+}
+
+// saveStateV2 is the version 2 save state, from before the disk head position
+// was kept in quarter tracks and bits
+type saveStateV2 struct {
+	CPUModel   int
+	CPU        cpu.State
+	Cycles     uint64
+	MMU        *mmu.State
+	DriveState system.DriveState
+	Disk       *struct {
+		Drives [2]struct {
+			ImagePath     string
+			Image         []byte
+			ImageIsDirty  bool
+			ImageIsLocked bool
+			SectorOrder   string
+			Phase         int8
+			BytePosition  int
+		}
+	}
+	HardDisk *disk.HardDiskState
+}
+
+// convert converts a version 2 save state to the current version
+func (v2 *saveStateV2) convert() saveState {
+	state := saveState{
+		CPUModel:   v2.CPUModel,
+		CPU:        v2.CPU,
+		Cycles:     v2.Cycles,
+		MMU:        v2.MMU,
+		DriveState: v2.DriveState,
+		HardDisk:   v2.HardDisk,
+	}
+
+	if v2.Disk != nil {
+		state.Disk = &disk.State{}
+		for i, d := range v2.Disk.Drives {
+			state.Disk.Drives[i] = disk.DriveState{
+				ImagePath:     d.ImagePath,
+				Image:         d.Image,
+				ImageIsDirty:  d.ImageIsDirty,
+				ImageIsLocked: d.ImageIsLocked,
+				SectorOrder:   d.SectorOrder,
+				QuarterTrack:  int(d.Phase) * 2,
+				BitPosition:   d.BytePosition * 8,
+			}
+		}
+	}
+
+	return state
+}
+
+// writeState writes a save state to w
+func (m *Machine) writeState(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	encoder := gob.NewEncoder(zw)
+
+	if err := encoder.Encode(saveStateHeader{Magic: saveStateMagic, Version: saveStateVersion}); err != nil {
+		return err
+	}
+
+	state := saveState{
+		CPUModel:   m.CPU.Model,
+		CPU:        m.CPU.State,
+		Cycles:     m.System.Cycles,
+		MMU:        m.MMU.SaveState(),
+		DriveState: m.System.DriveState,
+		Disk:       m.Disk.SaveState(),
+		HardDisk:   m.HardDisk.SaveState(),
+	}
+
+	if err := encoder.Encode(&state); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// readState reads a save state from r into the machine
+func (m *Machine) readState(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	decoder := gob.NewDecoder(zr)
+
+	var header saveStateHeader
+	if err := decoder.Decode(&header); err != nil {
+		return err
+	}
+
+	if header.Magic != saveStateMagic {
+		return fmt.Errorf("Not a save state")
+	}
+
+	if header.Version < 1 || header.Version > saveStateVersion {
+		return fmt.Errorf("Unsupported save state version %d", header.Version)
+	}
+
+	var state saveState
+	switch header.Version {
+	case 1:
+		var v1 saveStateV1
+		if err := decoder.Decode(&v1); err != nil {
+			return err
+		}
+		state = v1.convert()
+	case 2:
+		var v2 saveStateV2
+		if err := decoder.Decode(&v2); err != nil {
+			return err
+		}
+		state = v2.convert()
+	default:
+		if err := decoder.Decode(&state); err != nil {
+			return err
+		}
+	}
+
+	if state.MMU == nil || state.Disk == nil {
+		return fmt.Errorf("Incomplete save state")
+	}
+
+	// The disk images are the only part of the state that can fail to load,
+	// so they go first. If one fails, the drives are put back as they were and
+	// the rest of the machine is left alone. The floppy drives rotate from the
+	// restored cycle count.
+	cycles := m.System.Cycles
+	hardDisk := m.HardDisk.SaveState()
+	if state.HardDisk != nil {
+		if err := m.HardDisk.LoadState(state.HardDisk); err != nil {
+			return err
+		}
+	}
+
+	m.System.Cycles = state.Cycles
+	if err := m.Disk.LoadState(state.Disk); err != nil {
+		m.System.Cycles = cycles
+		if restoreErr := m.HardDisk.LoadState(hardDisk); restoreErr != nil {
+			panic(fmt.Sprintf("Unable to restore the hard disk images: %s", restoreErr))
+		}
+		return err
+	}
+
+	// The instruction decoder depends on the model, so a new CPU is needed if
+	// the model differs
+	if state.CPUModel != m.CPU.Model {
+		m.CPU = cpu.New(state.CPUModel, m.MMU, m.System)
+	}
+
+	m.CPU.State = state.CPU
+	m.System.PendingInterrupt = false
+	m.System.PendingNMI = false
+	m.MMU.LoadState(state.MMU)
+	m.System.DriveState = state.DriveState
+
+	return nil
+}
+
+// SaveState writes the complete machine state to a file
+func (m *Machine) SaveState(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.writeState(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LoadState restores the complete machine state from a file written by SaveState
+func (m *Machine) LoadState(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return m.readState(f)
+}
@@ -3,10 +3,7 @@ package mmu
 import (
 	"fmt"
 
-	"github.com/freewilll/apple2-go/audio"
 	"github.com/freewilll/apple2-go/disk"
-	"github.com/freewilll/apple2-go/keyboard"
-	"github.com/freewilll/apple2-go/system"
 )
 
 // Adapted from
@@ -70,6 +67,11 @@ const (
 
 	mOPNAPPLE = 0xC061 // open apple (command) key data
 	mCLSAPPLE = 0xC062 // closed apple (option) key data
+	mPB2      = 0xC063 // pushbutton 2
+	mPADDL0   = 0xC064 // paddle 0 timer
+	mPADDL1   = 0xC065 // paddle 1 timer
+	mPADDL2   = 0xC066 // paddle 2 timer
+	mPADDL3   = 0xC067 // paddle 3 timer
 	mSTATEREG = 0xC068 // Has no effect on //e
 
 	mPDLTRIG = 0xC070 // trigger paddles
@@ -91,199 +93,168 @@ const (
 	mS6Q6H      = 0xC0ED // WP sense
 	mS6Q7L      = 0xC0EE // WP sense/read    (Q7)
 	mS6Q7H      = 0xC0EF // write
-)
 
-// VideoState has 3 booleans which determine the video configuration:
-//                    TextMode HiresMode Mixed
-// text				  1        0 		 N/A
-// lores + text		  0        0         1
-// lores              0        0         0
-// hires              N/A      1         0
-// hires + text       N/A      1         1
-var VideoState struct {
-	TextMode  bool
-	HiresMode bool
-	Mixed     bool
-}
+	// Slot 7 hard disk IO
+	mS7COMMAND = 0xC0F0 // run the command in $42-$47
+	mS7BLOCKSL = 0xC0F1 // number of blocks, low byte
+	mS7BLOCKSH = 0xC0F2 // number of blocks, high byte
+	mS7ERROR   = 0xC0F3 // error code of the last command
+	mS7LAST    = 0xC0FF // last IO address of the card, $c0f4-$c0ff are unused
+)
 
 // InitIO resets all IO states
-func InitIO() {
+func (m *MMU) InitIO() {
 	// Empty slots that aren't yet implemented
-	emptySlot(3)
-	emptySlot(4)
-	emptySlot(7)
+	m.emptySlot(3)
+	m.emptySlot(4)
+
+	// Slot 7 has the hard disk card
+	m.loadSlotROM(7, disk.HardDiskROM(7))
 
 	// Initialize slot 6 drive
-	system.DriveState.Drive = 1
-	system.DriveState.Spinning = false
-	system.DriveState.Phase = 0
-	system.DriveState.BytePosition = 0
-	system.DriveState.Q6 = false
-	system.DriveState.Q7 = false
+	m.System.DriveState.Drive = 1
+	m.System.DriveState.Spinning = false
+	m.System.DriveState.Q6 = false
+	m.System.DriveState.Q7 = false
 
 	// Initialize video
-	VideoState.TextMode = true
-	VideoState.HiresMode = false
-	VideoState.Mixed = false
+	m.VideoState.TextMode = true
+	m.VideoState.HiresMode = false
+	m.VideoState.Mixed = false
+
+	// AN3 is on at power up, which disables double hires
+	m.Annunciators = [4]bool{false, false, false, true}
 
-	disk.InitDiskImage()
+	m.Disk.InitDrives()
 }
 
 // Handle soft switch addresses between $c000-$c0ff where both a read and a write has a side
 // effect. Returns true if the read/write has been handled.
-func readWrite(address uint16, isRead bool) bool {
+func (m *MMU) readWrite(address uint16, isRead bool) bool {
+	// $c000-$c00f are write-only switches, a read returns the keyboard data
+	if isRead && address >= mKEYBOARD && address <= mSETALTCH {
+		return false
+	}
+
 	lsb := address & 0xff
 	if lsb >= 0x80 && lsb < 0x90 {
-		SetMemoryMode(uint8(lsb - 0x80))
+		m.SetMemoryMode(uint8(lsb - 0x80))
 		return true
 	}
 
 	switch address {
 	case mCLRAUXRD:
-		SetFakeAuxMemoryRead(false)
+		m.SetAuxMemoryRead(false)
 		return true
 	case mSETAUXRD:
-		SetFakeAuxMemoryRead(true)
+		m.SetAuxMemoryRead(true)
 		return true
 
 	case mCLRAUXWR:
-		SetFakeAuxMemoryWrite(false)
+		m.SetAuxMemoryWrite(false)
 		return true
 	case mSETAUXWR:
-		SetFakeAuxMemoryWrite(true)
+		m.SetAuxMemoryWrite(true)
 		return true
 
 	case mCLRAUXZP:
-		SetFakeAltZP(false)
+		m.SetAltZP(false)
 		return true
 	case mSETAUXZP:
-		SetFakeAltZP(true)
+		m.SetAltZP(true)
 		return true
 
 	case mCLR80VID:
-		SetCol80(false)
+		m.SetCol80(false)
 		return true
 	case mSET80VID:
-		SetCol80(true)
+		m.SetCol80(true)
 		return true
 
 	case mTXTPAGE1:
-		SetPage2(false)
+		m.SetPage2(false)
 		return true
 	case mTXTPAGE2:
-		SetPage2(true)
+		m.SetPage2(true)
 		return true
 
 	case mCLRTEXT:
-		VideoState.TextMode = false
+		m.VideoState.TextMode = false
 		return true
 	case mSETTEXT:
-		VideoState.TextMode = true
+		m.VideoState.TextMode = true
 		return true
 
 	case mCLRMIXED:
-		VideoState.Mixed = false
+		m.VideoState.Mixed = false
 		return true
 	case mSETMIXED:
-		VideoState.Mixed = true
+		m.VideoState.Mixed = true
 		return true
 
 	case mCLRHIRES:
-		VideoState.HiresMode = false
+		m.SetHiresMode(false)
 		return true
 	case mSETHIRES:
-		VideoState.HiresMode = true
+		m.SetHiresMode(true)
 		return true
 
 	case mCLR80COL:
-		if !isRead {
-			SetStore80(false)
-			return true
-		}
-		return false
-
+		m.SetStore80(false)
+		return true
 	case mSET80COL:
-		SetStore80(true)
+		m.SetStore80(true)
 		return true
 
 	case mSTATEREG:
 		// Ignore not implemented memory management reg
 		return true
 
+	// 4-bit annunciator outputs. The even addresses switch an annunciator off
+	// and the odd addresses switch it on.
+	case mSETAN0, mCLRAN0, mSETAN1, mCLRAN1, mSETAN2, mCLRAN2, mSETAN3, mCLRAN3:
+		m.Annunciators[(address-mSETAN0)/2] = (address & 1) == 1
+		return true
+
 	// Drive stepper motor phase change
 	case mS6CLRDRVP0, mS6SETDRVP0, mS6CLRDRVP1, mS6SETDRVP1, mS6CLRDRVP2, mS6SETDRVP2, mS6CLRDRVP3, mS6SETDRVP3:
-		magnet := (address - mS6CLRDRVP0) / 2
+		magnet := uint8((address - mS6CLRDRVP0) / 2)
 		on := ((address - mS6CLRDRVP0) % 2) == 1
-		if !on {
-			// Turn off the magnet in Phases
-			system.DriveState.Phases &= ^(1 << magnet)
-			return true
-		}
-
-		// Implicit else, a magnet has been switched on
-		system.DriveState.Phases |= (1 << magnet)
-
-		// Move head if a neighboring magnet is on and all others are off
-		direction := int8(0)
-		if (system.DriveState.Phases & (1 << uint8((system.DriveState.Phase+1)&3))) != 0 {
-			direction++
-		}
-		if (system.DriveState.Phases & (1 << uint8((system.DriveState.Phase+3)&3))) != 0 {
-			direction--
-		}
-
-		// Move the head
-		if direction != 0 {
-			system.DriveState.Phase += direction
-
-			if system.DriveState.Phase < 0 {
-				system.DriveState.Phase = 0
-			}
-			if system.DriveState.Phase == 80 {
-				system.DriveState.Phase = 79
-			}
-
-			disk.MakeTrackData(uint8(system.DriveState.Phase))
-
-			if audio.ClickWhenDriveHeadMoves {
-				audio.Click()
-			}
+		if m.Disk.SetPhase(magnet, on) && m.Audio.ClickWhenDriveHeadMoves {
+			m.Audio.Click()
 		}
 
 		return true
 
 	case mS6MOTOROFF:
-		system.DriveState.Spinning = false
+		m.Disk.SetMotor(false)
 		return true
 	case mS6MOTORON:
-		system.DriveState.Spinning = true
+		m.Disk.SetMotor(true)
 		return true
 
 	case mS6SELDRV1:
-		system.DriveState.Drive = 1
+		m.Disk.SelectDrive(1)
 		return true
 	case mS6SELDRV2:
-		system.DriveState.Drive = 2
+		m.Disk.SelectDrive(2)
 		return true
 
 	case mS6Q6L:
-		if !isRead {
-			system.DriveState.Q6 = false
-			return true
-		}
-		return false
+		m.System.DriveState.Q6 = false
+		return !isRead
 	case mS6Q6H:
 		if isRead {
-			system.DriveState.Q6 = true
+			m.System.DriveState.Q6 = true
 			return true
 		}
 		return false
 
 	case mS6Q7L:
-		system.DriveState.Q7 = false
-		return true
+		m.Disk.SetQ7(false)
+		return !isRead
 	case mS6Q7H:
-		system.DriveState.Q7 = true
+		m.Disk.SetQ7(true)
 		return true
 
 	default:
@@ -292,72 +263,138 @@ func readWrite(address uint16, isRead bool) bool {
 }
 
 // ReadIO does a read in the $c000-$c0ff area
-func ReadIO(address uint16) uint8 {
+func (m *MMU) ReadIO(address uint16) uint8 {
 	// Try the generic readWrite and return if it has handled the read
-	if readWrite(address, true) {
+	if m.readWrite(address, true) {
 		return 0
 	}
 
+	// Reads of the write-only switches in $c000-$c00f all return the keyboard
+	// data
+	if address >= mKEYBOARD && address <= mSETALTCH {
+		return m.Keyboard.ReadData()
+	}
+
 	switch address {
 
-	case mKEYBOARD, mSTROBE:
-		keyBoardData, strobe := keyboard.Read()
-		if address == mKEYBOARD {
-			return keyBoardData
-		}
-		keyboard.ResetStrobe()
+	case mSTROBE:
+		strobe := m.Keyboard.ReadStrobe()
+		m.Keyboard.ResetStrobe()
 		return strobe
 
-	case mRDRAMRD, mRDRAMWR, mRDAUXZP:
-		panic("Read/write aux memory not implemented")
+	case mRDLCBNK2:
+		if m.D000Bank == 2 {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDLCRAM:
+		if !m.UpperReadMappedToROM {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDRAMRD:
+		if m.AuxMemoryRead {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDRAMWR:
+		if m.AuxMemoryWrite {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDAUXZP:
+		if m.AltZP {
+			return 0x8d
+		}
 		return 0x0d
 
 	case mRDCXROM:
-		if UsingExternalSlotRom {
+		if m.UsingExternalSlotRom {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDC3ROM:
+		if m.SlotC3Rom {
 			return 0x8d
 		}
 		return 0x0d
 
 	case mRD80VID:
-		// using 80-column display mode not implemented
+		if m.Col80 {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDTEXT:
+		if m.VideoState.TextMode {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDMIXED:
+		if m.VideoState.Mixed {
+			return 0x8d
+		}
+		return 0x0d
+
+	case mRDHIRES:
+		if m.VideoState.HiresMode {
+			return 0x8d
+		}
 		return 0x0d
 
 	case mRDPAGE2:
-		if Page2 {
+		if m.Page2 {
 			return 0x8d
 		}
 		return 0x0d
 
-	// 4-bit annunciator inputs
-	case mSETAN0, mCLRAN0, mSETAN1, mCLRAN1, mSETAN2, mCLRAN2, mSETAN3, mCLRAN3:
-		// Annunciators not implemented
+	case mOPNAPPLE, mCLSAPPLE, mPB2:
+		return m.Joystick.ReadButton(int(address - mOPNAPPLE))
 
-	case mOPNAPPLE:
-		// Open apple key not implemented
-		return 0
+	case mPADDL0, mPADDL1, mPADDL2, mPADDL3:
+		return m.Joystick.ReadPaddle(int(address - mPADDL0))
 
-	case mCLSAPPLE:
-		// Closed apple key not implemented
+	case mPDLTRIG:
+		m.Joystick.Trigger()
 
 	case mRD80COL:
-		if Store80 {
+		if m.Store80 {
 			return 0x8d
 		}
 		return 0x0d
 
 	case mRDALTCH:
-		// RDALTCH not implemented, but it's also used, so don't fail on it.
+		if m.AltCharSet {
+			return 0x8d
+		}
 		return 0x0d
 
 	case mSPEAKER:
-		audio.Click()
+		m.Audio.Click()
 		return 0
 
 	case mS6Q6L:
 		// A read from disk
-		return disk.ReadTrackData()
+		return m.Disk.ReadTrackData()
+
+	case mS6Q7L:
+		// Write protect sense or a read from disk
+		return m.Disk.ReadStatus()
+
+	case mS7COMMAND, mS7BLOCKSL, mS7BLOCKSH, mS7ERROR:
+		return m.HardDisk.ReadRegister(int(address - mS7COMMAND))
 
 	default:
+		// The unused registers of the hard disk card read as 0
+		if address > mS7ERROR && address <= mS7LAST {
+			return 0
+		}
 		panic(fmt.Sprintf("TODO read %04x\n", address))
 	}
 
@@ -365,41 +402,48 @@ func ReadIO(address uint16) uint8 {
 }
 
 // WriteIO does a write in the $c000-$c0ff area
-func WriteIO(address uint16, value uint8) {
+func (m *MMU) WriteIO(address uint16, value uint8) {
 	// Try the generic readWrite and return if it has handled the write
-	if readWrite(address, false) {
+	if m.readWrite(address, false) {
 		return
 	}
 
 	switch address {
 
 	case mSTROBE:
-		keyboard.ResetStrobe()
+		m.Keyboard.ResetStrobe()
 
 	case mCLRCXROM:
-		MapFirstHalfOfIO()
+		m.MapFirstHalfOfIO()
 	case mSETCXROM:
-		MapSecondHalfOfIO()
+		m.MapSecondHalfOfIO()
 
 	case mCLRALTCH:
-		return
+		m.AltCharSet = false
 	case mSETALTCH:
-		panic("SETALTCH not implemented")
-
-	case mCLR80COL:
-		// CLR80COL not implemented
-		return
+		m.AltCharSet = true
 
 	case mCLRC3ROM:
-		// CLRC3ROM not implemented
+		m.SetSlotC3Rom(false)
 	case mSETC3ROM:
-		// SETC3ROM not implemented
+		m.SetSlotC3Rom(true)
+
+	case mPDLTRIG:
+		m.Joystick.Trigger()
 
 	case mS6Q6H:
 		// A write to disk
-		disk.WriteTrackData(value)
+		m.Disk.WriteTrackData(value)
+
+	case mS7COMMAND:
+		// A hard disk block device call
+		m.HardDisk.Execute(m)
 
 	default:
+		// Writes to the other registers of the hard disk card are ignored
+		if address > mS7COMMAND && address <= mS7LAST {
+			return
+		}
 		panic(fmt.Sprintf("TODO write %04x\n", address))
 	}
 
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/freewilll/apple2-go/audio"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/joystick"
+	"github.com/freewilll/apple2-go/keyboard"
 	"github.com/freewilll/apple2-go/system"
 )
 
@@ -13,112 +17,247 @@ const RomPath = "apple2e.rom"
 // StackPage is the location of the 6504 stack
 const StackPage = 1
 
-// PhysicalMemory contains all the unmapped memory, ROM and RAM
-var PhysicalMemory struct {
-	MainMemory [0x10000]uint8 // Main RAM
-	UpperROM   [0x3000]uint8  // $c000-$ffff ROM area
-	RomC1      [0x1000]uint8  // First half of IO ROM
-	RomC2      [0x1000]uint8  // Second half of IO ROM
-}
-
-// ReadPageTable is the page table for reads
-var ReadPageTable [0x100][]uint8
+// MMU is the memory management unit. It maps the physical memory into the
+// 6502 address space and handles the soft switches in the $c000-$c0ff area.
+type MMU struct {
+	System   *system.System
+	Audio    *audio.Audio
+	Disk     *disk.Controller
+	HardDisk *disk.HardDisk
+	Keyboard *keyboard.Keyboard
+	Joystick *joystick.Joystick
+
+	// PhysicalMemory contains all the unmapped memory, ROM and RAM
+	PhysicalMemory struct {
+		MainMemory [0x10000]uint8 // Main RAM
+		AuxMemory  [0x10000]uint8 // Auxiliary RAM
+		UpperROM   [0x3000]uint8  // $c000-$ffff ROM area
+		RomC1      [0x1000]uint8  // First half of IO ROM
+		RomC2      [0x1000]uint8  // Second half of IO ROM
+	}
 
-// WritePageTable is the page table for writes
-var WritePageTable [0x100][]uint8
+	ReadPageTable  [0x100][]uint8 // Page table for reads
+	WritePageTable [0x100][]uint8 // Page table for writes
 
-// Memory mapping states
-var (
+	// Memory mapping states
 	D000Bank             int  // one maps to $c000, two maps to $d000
 	UsingExternalSlotRom bool // Which IO ROM is being used
 	UpperReadMappedToROM bool // Do reads go to the RAM or ROM
 	UpperRAMReadOnly     bool // Is the upper RAM read only
-	FakeAuxMemoryRead    bool // Aux memory isn't implemented
-	FakeAuxMemoryWrite   bool // Aux memory isn't implemented
-	FakeAltZP            bool // Aux memory isn't implemented
-	FakePage2            bool // Aux memory isn't implemented
-	Col80                bool // 80 Column card is on (not implemented)
-	Store80              bool // 80 Column card is on (not implemented)
-	Page2                bool // Main memory Page2 is selected
-)
+	AuxMemoryRead        bool // RAMRD: reads from $0200-$bfff go to aux memory
+	AuxMemoryWrite       bool // RAMWRT: writes to $0200-$bfff go to aux memory
+	AltZP                bool // ALTZP: zero page, stack and upper RAM are in aux memory
+	SlotC3Rom            bool // SLOTC3ROM: $c300 is mapped to the slot ROM instead of the internal 80 column firmware
+	IntC8Rom             bool // The internal ROM is mapped to $c800-$cfff after an access to the internal $c300 ROM
+	Col80                bool // 80VID: 80 column display mode
+	AltCharSet           bool // ALTCHARSET: the alternate character set with MouseText is used
+	Store80              bool // 80STORE: PAGE2 switches display memory between main and aux
+	Page2                bool // PAGE2 soft switch
+
+	// VideoState has 3 booleans which determine the video configuration:
+	//                    TextMode HiresMode Mixed
+	// text               1        0         N/A
+	// lores + text       0        0         1
+	// lores              0        0         0
+	// hires              N/A      1         0
+	// hires + text       N/A      1         1
+	VideoState struct {
+		TextMode  bool
+		HiresMode bool
+		Mixed     bool
+	}
+
+	// Annunciators has the state of the 4 annunciator outputs. The 80 column card
+	// uses AN3 to enable double hires: double hires is on if 80VID is on and AN3
+	// is off.
+	Annunciators [4]bool
+}
+
+// New creates an MMU. The audio, disks, keyboard and joystick are only used
+// for IO and may be nil if the IO area isn't accessed.
+func New(s *system.System, a *audio.Audio, d *disk.Controller, h *disk.HardDisk, k *keyboard.Keyboard, j *joystick.Joystick) *MMU {
+	return &MMU{System: s, Audio: a, Disk: d, HardDisk: h, Keyboard: k, Joystick: j}
+}
 
 // ApplyMemoryConfiguration creates the page tables for current RAM, ROM and IO configuration
-func ApplyMemoryConfiguration() {
-	// Map main RAM for read/write
-	for i := 0x0; i < 0xc0; i++ {
-		ReadPageTable[i] = PhysicalMemory.MainMemory[i*0x100 : i*0x100+0x100]
-		WritePageTable[i] = PhysicalMemory.MainMemory[i*0x100 : i*0x100+0x100]
+func (m *MMU) ApplyMemoryConfiguration() {
+	// Zero page, stack and the upper RAM area are either in main or aux memory
+	altMemory := &m.PhysicalMemory.MainMemory
+	if m.AltZP {
+		altMemory = &m.PhysicalMemory.AuxMemory
+	}
+
+	// Map $0000-$01ff
+	for i := 0x0; i < 0x2; i++ {
+		m.ReadPageTable[i] = altMemory[i*0x100 : i*0x100+0x100]
+		m.WritePageTable[i] = altMemory[i*0x100 : i*0x100+0x100]
+	}
+
+	// Map $0200-$bfff to main or aux RAM
+	readMemory := &m.PhysicalMemory.MainMemory
+	if m.AuxMemoryRead {
+		readMemory = &m.PhysicalMemory.AuxMemory
+	}
+
+	writeMemory := &m.PhysicalMemory.MainMemory
+	if m.AuxMemoryWrite {
+		writeMemory = &m.PhysicalMemory.AuxMemory
+	}
+
+	for i := 0x2; i < 0xc0; i++ {
+		m.ReadPageTable[i] = readMemory[i*0x100 : i*0x100+0x100]
+		m.WritePageTable[i] = writeMemory[i*0x100 : i*0x100+0x100]
+	}
+
+	// If 80STORE is on, PAGE2 selects main or aux memory for the text page
+	// and also for the hires page if hires mode is on. This takes precedence
+	// over RAMRD and RAMWRT.
+	if m.Store80 {
+		displayMemory := &m.PhysicalMemory.MainMemory
+		if m.Page2 {
+			displayMemory = &m.PhysicalMemory.AuxMemory
+		}
+
+		for i := 0x4; i < 0x8; i++ {
+			m.ReadPageTable[i] = displayMemory[i*0x100 : i*0x100+0x100]
+			m.WritePageTable[i] = displayMemory[i*0x100 : i*0x100+0x100]
+		}
+
+		if m.VideoState.HiresMode {
+			for i := 0x20; i < 0x40; i++ {
+				m.ReadPageTable[i] = displayMemory[i*0x100 : i*0x100+0x100]
+				m.WritePageTable[i] = displayMemory[i*0x100 : i*0x100+0x100]
+			}
+		}
 	}
 
 	// Map $c000
 	var ioRom *[0x1000]uint8
-	if UsingExternalSlotRom {
-		ioRom = &PhysicalMemory.RomC2
+	if m.UsingExternalSlotRom {
+		ioRom = &m.PhysicalMemory.RomC2
 	} else {
-		ioRom = &PhysicalMemory.RomC1
+		ioRom = &m.PhysicalMemory.RomC1
 	}
 
 	for i := 0x1; i < 0x10; i++ {
-		ReadPageTable[0xc0+i] = (*ioRom)[i*0x100 : i*0x100+0x100]
-		WritePageTable[0xc0+i] = nil
+		m.ReadPageTable[0xc0+i] = (*ioRom)[i*0x100 : i*0x100+0x100]
+		m.WritePageTable[0xc0+i] = nil
+	}
+
+	// When the slot ROMs are in use, $c300 and $c800-$cfff can still map to
+	// the internal 80 column firmware.
+	if !m.UsingExternalSlotRom {
+		if !m.SlotC3Rom {
+			m.ReadPageTable[0xc3] = m.PhysicalMemory.RomC2[0x300:0x400]
+		}
+
+		if m.IntC8Rom {
+			for i := 0x8; i < 0x10; i++ {
+				m.ReadPageTable[0xc0+i] = m.PhysicalMemory.RomC2[i*0x100 : i*0x100+0x100]
+			}
+		}
 	}
 
 	// Map $d000
 	for i := 0xd0; i < 0xe0; i++ {
-		base := i*0x100 + D000Bank*0x1000 - 0x2000
-		if !UpperReadMappedToROM {
-			ReadPageTable[i] = PhysicalMemory.MainMemory[base : base+0x100]
+		base := i*0x100 + m.D000Bank*0x1000 - 0x2000
+		if !m.UpperReadMappedToROM {
+			m.ReadPageTable[i] = altMemory[base : base+0x100]
 		}
 
-		if UpperRAMReadOnly {
-			WritePageTable[i] = nil
+		if m.UpperRAMReadOnly {
+			m.WritePageTable[i] = nil
 		} else {
-			WritePageTable[i] = PhysicalMemory.MainMemory[base : base+0x100]
+			m.WritePageTable[i] = altMemory[base : base+0x100]
 		}
 	}
 
 	// Map 0xe00 to 0xffff
 	for i := 0xe0; i < 0x100; i++ {
 		base := i * 0x100
-		if !UpperReadMappedToROM {
-			ReadPageTable[i] = PhysicalMemory.MainMemory[base : base+0x100]
+		if !m.UpperReadMappedToROM {
+			m.ReadPageTable[i] = altMemory[base : base+0x100]
 		}
-		if UpperRAMReadOnly {
-			WritePageTable[i] = nil
+		if m.UpperRAMReadOnly {
+			m.WritePageTable[i] = nil
 		} else {
-			WritePageTable[i] = PhysicalMemory.MainMemory[base : base+0x100]
+			m.WritePageTable[i] = altMemory[base : base+0x100]
 		}
 	}
 
-	if UpperReadMappedToROM {
+	if m.UpperReadMappedToROM {
 		for i := 0x00; i < 0x30; i++ {
-			ReadPageTable[i+0xd0] = PhysicalMemory.UpperROM[i*0x100 : i*0x100+0x100]
+			m.ReadPageTable[i+0xd0] = m.PhysicalMemory.UpperROM[i*0x100 : i*0x100+0x100]
 		}
 	}
 
 }
 
 // MapFirstHalfOfIO maps 0xc100-0xcfff for reading from RomC1
-func MapFirstHalfOfIO() {
-	UsingExternalSlotRom = false
-	ApplyMemoryConfiguration()
+func (m *MMU) MapFirstHalfOfIO() {
+	m.UsingExternalSlotRom = false
+	m.ApplyMemoryConfiguration()
 }
 
 // MapSecondHalfOfIO map 0xc100-0xcfff for reading from RomC2
-func MapSecondHalfOfIO() {
-	UsingExternalSlotRom = true
-	ApplyMemoryConfiguration()
+func (m *MMU) MapSecondHalfOfIO() {
+	m.UsingExternalSlotRom = true
+	m.ApplyMemoryConfiguration()
+}
+
+// SetSlotC3Rom sets SLOTC3ROM. If true, $c300 is mapped to the slot 3 ROM,
+// otherwise to the internal 80 column firmware.
+func (m *MMU) SetSlotC3Rom(value bool) {
+	m.SlotC3Rom = value
+	m.ApplyMemoryConfiguration()
+}
+
+// accessSlotROM handles the side effects of an access to $c100-$cfff. An
+// access to the internal $c300 ROM maps the internal ROM to $c800-$cfff and
+// an access to $cfff unmaps it again.
+func (m *MMU) accessSlotROM(address uint16) {
+	if address >= 0xc300 && address < 0xc400 && !m.SlotC3Rom && !m.IntC8Rom {
+		m.IntC8Rom = true
+		m.ApplyMemoryConfiguration()
+	} else if address == 0xcfff && m.IntC8Rom {
+		m.IntC8Rom = false
+		m.ApplyMemoryConfiguration()
+	}
 }
 
 // emptySlot zeroes all RAM for a slot, effectively disabling the slot
-func emptySlot(slot int) {
+func (m *MMU) emptySlot(slot int) {
 	for i := slot * 0x100; i < (slot+1)*0x100; i++ {
-		PhysicalMemory.RomC1[i] = 0
-		PhysicalMemory.RomC2[i] = 0
+		m.PhysicalMemory.RomC1[i] = 0
+		m.PhysicalMemory.RomC2[i] = 0
 	}
 }
 
-func loadApple2eROM() {
+// loadSlotROM copies a card's ROM to the $Cn00 area of its slot
+func (m *MMU) loadSlotROM(slot int, rom [0x100]uint8) {
+	copy(m.PhysicalMemory.RomC1[slot*0x100:(slot+1)*0x100], rom[:])
+}
+
+// LoadDiskROM replaces the disk controller's boot ROM in slot 6 with a 256
+// byte ROM file. The 13 sector P5A ROM boots DOS 3.2 disks.
+func (m *MMU) LoadDiskROM(path string) error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes) != 0x100 {
+		return fmt.Errorf("Disk ROM has invalid length %d, expected 256", len(bytes))
+	}
+
+	var rom [0x100]uint8
+	copy(rom[:], bytes)
+	m.loadSlotROM(6, rom)
+
+	return nil
+}
+
+func (m *MMU) loadApple2eROM() {
 	bytes, err := ioutil.ReadFile(RomPath)
 	if err != nil {
 		panic(fmt.Sprintf("Unable to read ROM: %s", err))
@@ -126,194 +265,181 @@ func loadApple2eROM() {
 
 	// Copy both I/O areas over c000-cfff, including unused c000-c0ff
 	for i := 0x0000; i < 0x1000; i++ {
-		PhysicalMemory.RomC1[i] = bytes[i]
-		PhysicalMemory.RomC2[i] = bytes[i+0x4000]
+		m.PhysicalMemory.RomC1[i] = bytes[i]
+		m.PhysicalMemory.RomC2[i] = bytes[i+0x4000]
 	}
 
 	// Copy ROM over for 0xd000-0xffff area
 	for i := 0x0; i < 0x3000; i++ {
-		PhysicalMemory.UpperROM[i] = bytes[i+0x1000]
+		m.PhysicalMemory.UpperROM[i] = bytes[i+0x1000]
 	}
 }
 
 // InitApple2eROM loads the ROM and inits the ROM page tables
-func InitApple2eROM() {
-	loadApple2eROM()
-	MapFirstHalfOfIO() // Map 0xc100-0xcfff for reading
-	InitROM()          // Map 0xd000-0xffff for reading
+func (m *MMU) InitApple2eROM() {
+	m.loadApple2eROM()
+	m.MapFirstHalfOfIO() // Map 0xc100-0xcfff for reading
+	m.InitROM()          // Map 0xd000-0xffff for reading
 }
 
 // InitROM sets the upper memory area for reading from ROM
-func InitROM() {
-	UpperReadMappedToROM = true
-	ApplyMemoryConfiguration()
+func (m *MMU) InitROM() {
+	m.UpperReadMappedToROM = true
+	m.ApplyMemoryConfiguration()
 }
 
 // SetUpperReadMappedToROM sets the upper area so that reads are done from the ROM if true or RAM if false
-func SetUpperReadMappedToROM(value bool) {
-	UpperReadMappedToROM = value
-	ApplyMemoryConfiguration()
+func (m *MMU) SetUpperReadMappedToROM(value bool) {
+	m.UpperReadMappedToROM = value
+	m.ApplyMemoryConfiguration()
 }
 
 // SetUpperRAMReadOnly sets the upper RAM area to read only
-func SetUpperRAMReadOnly(value bool) {
-	UpperRAMReadOnly = value
-	ApplyMemoryConfiguration()
+func (m *MMU) SetUpperRAMReadOnly(value bool) {
+	m.UpperRAMReadOnly = value
+	m.ApplyMemoryConfiguration()
 }
 
 // SetD000Bank sets the $d000 bank to map to $c000 or $d000 in the physical  memory
-func SetD000Bank(value int) {
-	D000Bank = value
-	ApplyMemoryConfiguration()
+func (m *MMU) SetD000Bank(value int) {
+	m.D000Bank = value
+	m.ApplyMemoryConfiguration()
 }
 
-// SetFakeAuxMemoryRead sets an internal state to fake aux memory reads. Aux memory hasn't been implemented. If aux memory is selected, and a read
-// is attempted, then nonsense must be returned.
-func SetFakeAuxMemoryRead(value bool) {
-	FakeAuxMemoryRead = value
-	ApplyMemoryConfiguration()
+// SetAuxMemoryRead sets RAMRD. If true, reads from $0200-$bfff are done from aux memory.
+func (m *MMU) SetAuxMemoryRead(value bool) {
+	m.AuxMemoryRead = value
+	m.ApplyMemoryConfiguration()
 }
 
-// SetFakeAuxMemoryWrite sets an internal state to fake aux memory writes. Aux memory hasn't been implemented. If aux memory is selected, and a write
-// is attempted, then it must be ignored.
-func SetFakeAuxMemoryWrite(value bool) {
-	FakeAuxMemoryWrite = value
-	ApplyMemoryConfiguration()
+// SetAuxMemoryWrite sets RAMWRT. If true, writes to $0200-$bfff are done to aux memory.
+func (m *MMU) SetAuxMemoryWrite(value bool) {
+	m.AuxMemoryWrite = value
+	m.ApplyMemoryConfiguration()
 }
 
-// SetFakeAltZP sets an internal state to fake a missing alternate zero page. Alternate zero page isn't implemented
-func SetFakeAltZP(value bool) {
-	FakeAltZP = value
-	ApplyMemoryConfiguration()
+// SetAltZP sets ALTZP. If true, the zero page, stack and the $d000-$ffff RAM are in aux memory.
+func (m *MMU) SetAltZP(value bool) {
+	m.AltZP = value
+	m.ApplyMemoryConfiguration()
 }
 
-// SetCol80 sets an internal state to fake a missing 80 column card
-func SetCol80(value bool) {
-	Col80 = value
+// SetCol80 sets 80VID, which switches the display to 80 columns
+func (m *MMU) SetCol80(value bool) {
+	m.Col80 = value
 	// No changes are needed when this is toggled
 }
 
-// SetPage2 sets page1/page2 in text, lores or hires. This only works if the
-// 80 column card is disabled since the 80 columns card has not yet nbeen
-// implemented.
-func SetPage2(value bool) {
-	// If the 80 column card is enabled, then this toggles aux memory
-	// Otherwise, page1/page2 is toggled in the main memory
-	if Col80 {
-		FakePage2 = value
-		ApplyMemoryConfiguration()
-	} else {
-		Page2 = value
+// SetPage2 sets the PAGE2 soft switch. If 80STORE is on, this switches the
+// text page and, in hires mode, the hires page between main and aux memory.
+// Otherwise page1/page2 is toggled in the display.
+func (m *MMU) SetPage2(value bool) {
+	m.Page2 = value
+	if m.Store80 {
+		m.ApplyMemoryConfiguration()
 	}
 }
 
-// SetStore80 sets an internal state to fake a missing 80 column card
-func SetStore80(value bool) {
-	Store80 = value
-	FakePage2 = value
-	ApplyMemoryConfiguration()
+// SetStore80 sets 80STORE, which makes PAGE2 switch between main and aux display memory
+func (m *MMU) SetStore80(value bool) {
+	m.Store80 = value
+	m.ApplyMemoryConfiguration()
+}
+
+// SetHiresMode sets hires mode, which also affects the memory mapping if 80STORE is on
+func (m *MMU) SetHiresMode(value bool) {
+	m.VideoState.HiresMode = value
+	if m.Store80 {
+		m.ApplyMemoryConfiguration()
+	}
 }
 
 // InitRAM sets all default RAM memory settings and resets the page tables
-func InitRAM() {
-	UpperRAMReadOnly = false
-	D000Bank = 2
-	FakeAuxMemoryRead = false  // Aux memory isn't implemented
-	FakeAuxMemoryWrite = false // Aux memory isn't implemented
-	FakeAltZP = false          // Aux memory isn't implemented
-	FakePage2 = false          // Aux memory isn't implemented
-	Col80 = false              // Aux memory isn't implemented
-	Page2 = false
-	ApplyMemoryConfiguration()
+func (m *MMU) InitRAM() {
+	m.UpperRAMReadOnly = false
+	m.D000Bank = 2
+	m.AuxMemoryRead = false
+	m.AuxMemoryWrite = false
+	m.AltZP = false
+	m.SlotC3Rom = false
+	m.IntC8Rom = false
+	m.Col80 = false
+	m.AltCharSet = false
+	m.Store80 = false
+	m.Page2 = false
+	m.ApplyMemoryConfiguration()
 }
 
 // WipeRAM wipes all the physical RAM
-func WipeRAM() {
+func (m *MMU) WipeRAM() {
 	for i := 0; i < 0x10000; i++ {
-		PhysicalMemory.MainMemory[i] = 0
+		m.PhysicalMemory.MainMemory[i] = 0
+		m.PhysicalMemory.AuxMemory[i] = 0
 	}
 }
 
 // SetMemoryMode is used to set UpperRAMReadOnly, UpperReadMappedToROM and D000Bank number
-func SetMemoryMode(mode uint8) {
+func (m *MMU) SetMemoryMode(mode uint8) {
 	// mode corresponds to a read/write to $c080 with
 	// $c080 mode=$00
 	// $c08f mode=$0f
 
 	if (mode & 1) == 0 {
-		UpperRAMReadOnly = true
+		m.UpperRAMReadOnly = true
 	} else {
-		UpperRAMReadOnly = false
+		m.UpperRAMReadOnly = false
 	}
 
 	if (((mode & 2) >> 1) ^ (mode & 1)) == 0 {
-		UpperReadMappedToROM = false
+		m.UpperReadMappedToROM = false
 
 	} else {
-		UpperReadMappedToROM = true
+		m.UpperReadMappedToROM = true
 	}
 
 	if (mode & 8) == 0 {
-		D000Bank = 2
+		m.D000Bank = 2
 	} else {
-		D000Bank = 1
+		m.D000Bank = 1
 	}
 
-	ApplyMemoryConfiguration()
+	m.ApplyMemoryConfiguration()
 }
 
 // ReadMemory reads the ROM or RAM page table
-func ReadMemory(address uint16) uint8 {
+func (m *MMU) ReadMemory(address uint16) uint8 {
 	if (address >= 0xc000) && (address < 0xc100) {
-		return ReadIO(address)
+		return m.ReadIO(address)
 	}
 
-	if FakePage2 && (address >= 0x400 && address < 0x800) {
-		// Return nothingness
-		return uint8(0x00)
+	if (address >= 0xc100) && (address < 0xd000) {
+		m.accessSlotROM(address)
 	}
 
-	if FakeAuxMemoryRead {
-		if address >= 0x200 {
-			// Return nothingness
-			return uint8(0x00)
-		}
-		if FakeAltZP {
-			return uint8(0x00)
-		}
-
-	}
-
-	// Implicit else, we're reading the main non-IO RAM
-	return ReadPageTable[address>>8][address&0xff]
+	// Implicit else, we're reading the non-IO RAM or ROM
+	return m.ReadPageTable[address>>8][address&0xff]
 }
 
 // WriteMemory writes to the ROM or RAM page table
-func WriteMemory(address uint16, value uint8) {
+func (m *MMU) WriteMemory(address uint16, value uint8) {
 	if (address >= 0xc000) && (address < 0xc100) {
-		WriteIO(address, value)
-		return
-	}
-
-	// Magic routine to trigger an interrupt, used in the CPU interrupt tests
-	if system.RunningInterruptTests && address == 0xbffc {
-		oldValue := ReadMemory(address)
-		system.WriteInterruptTestOpenCollector(address, oldValue, value)
-		WritePageTable[uint8(address>>8)][uint8(address&0xff)] = value
+		m.WriteIO(address, value)
 		return
 	}
 
-	if FakePage2 && (address >= 0x400 && address < 0x800) {
-		// Do nothing
-		return
+	if (address >= 0xc100) && (address < 0xd000) {
+		m.accessSlotROM(address)
 	}
 
-	if FakeAuxMemoryWrite {
-		// If there is no aux memory, then the write is ignored.
+	// Magic routine to trigger an interrupt, used in the CPU interrupt tests
+	if m.System.RunningInterruptTests && address == 0xbffc {
+		oldValue := m.ReadMemory(address)
+		m.System.WriteInterruptTestOpenCollector(address, oldValue, value)
+		m.WritePageTable[uint8(address>>8)][uint8(address&0xff)] = value
 		return
 	}
 
-	memory := WritePageTable[address>>8]
+	memory := m.WritePageTable[address>>8]
 
 	// If memory is nil, then it's read only. The write is ignored.
 	if memory != nil {
@@ -322,12 +448,88 @@ func WriteMemory(address uint16, value uint8) {
 
 	// If doing CPU functional tests, 0x200 has the test number in it. A write to
 	// it means a test passed or the tests are complete.
-	if system.RunningFunctionalTests && address == 0x200 {
-		testNumber := ReadMemory(0x200)
+	if m.System.RunningFunctionalTests && address == 0x200 {
+		testNumber := m.ReadMemory(0x200)
 		if testNumber == 0xf0 {
 			fmt.Println("Opcode testing completed")
 		} else {
-			fmt.Printf("Test %d OK\n", ReadMemory(0x200))
+			fmt.Printf("Test %d OK\n", m.ReadMemory(0x200))
 		}
 	}
 }
+
+// State is the part of the MMU that is kept in a save state. The ROM isn't
+// included since it's loaded from file at startup.
+type State struct {
+	MainMemory [0x10000]uint8
+	AuxMemory  [0x10000]uint8
+
+	D000Bank             int
+	UsingExternalSlotRom bool
+	UpperReadMappedToROM bool
+	UpperRAMReadOnly     bool
+	AuxMemoryRead        bool
+	AuxMemoryWrite       bool
+	AltZP                bool
+	SlotC3Rom            bool
+	IntC8Rom             bool
+	Col80                bool
+	AltCharSet           bool
+	Store80              bool
+	Page2                bool
+
+	TextMode  bool
+	HiresMode bool
+	Mixed     bool
+
+	Annunciators [4]bool
+}
+
+// SaveState returns the RAM and soft switch states
+func (m *MMU) SaveState() *State {
+	return &State{
+		MainMemory:           m.PhysicalMemory.MainMemory,
+		AuxMemory:            m.PhysicalMemory.AuxMemory,
+		D000Bank:             m.D000Bank,
+		UsingExternalSlotRom: m.UsingExternalSlotRom,
+		UpperReadMappedToROM: m.UpperReadMappedToROM,
+		UpperRAMReadOnly:     m.UpperRAMReadOnly,
+		AuxMemoryRead:        m.AuxMemoryRead,
+		AuxMemoryWrite:       m.AuxMemoryWrite,
+		AltZP:                m.AltZP,
+		SlotC3Rom:            m.SlotC3Rom,
+		IntC8Rom:             m.IntC8Rom,
+		Col80:                m.Col80,
+		AltCharSet:           m.AltCharSet,
+		Store80:              m.Store80,
+		Page2:                m.Page2,
+		TextMode:             m.VideoState.TextMode,
+		HiresMode:            m.VideoState.HiresMode,
+		Mixed:                m.VideoState.Mixed,
+		Annunciators:         m.Annunciators,
+	}
+}
+
+// LoadState restores the RAM and soft switch states and recreates the page tables
+func (m *MMU) LoadState(state *State) {
+	m.PhysicalMemory.MainMemory = state.MainMemory
+	m.PhysicalMemory.AuxMemory = state.AuxMemory
+	m.D000Bank = state.D000Bank
+	m.UsingExternalSlotRom = state.UsingExternalSlotRom
+	m.UpperReadMappedToROM = state.UpperReadMappedToROM
+	m.UpperRAMReadOnly = state.UpperRAMReadOnly
+	m.AuxMemoryRead = state.AuxMemoryRead
+	m.AuxMemoryWrite = state.AuxMemoryWrite
+	m.AltZP = state.AltZP
+	m.SlotC3Rom = state.SlotC3Rom
+	m.IntC8Rom = state.IntC8Rom
+	m.Col80 = state.Col80
+	m.AltCharSet = state.AltCharSet
+	m.Store80 = state.Store80
+	m.Page2 = state.Page2
+	m.VideoState.TextMode = state.TextMode
+	m.VideoState.HiresMode = state.HiresMode
+	m.VideoState.Mixed = state.Mixed
+	m.Annunciators = state.Annunciators
+	m.ApplyMemoryConfiguration()
+}
@@ -0,0 +1,388 @@
+package prodos
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const maxDirectoryBlocks = maxBlocks // Directories longer than this have a loop
+
+// File is a file or subdirectory in a directory
+type File struct {
+	Name       string
+	Type       uint8 // ProDOS file type, e.g. $06 for binary files
+	AuxType    uint16
+	BlocksUsed int // Number of blocks used by the file, including index blocks
+	EOF        int // Length of the file
+	Created    time.Time
+	Modified   time.Time
+	Locked     bool // If the file can't be written to or deleted
+
+	storageType uint8
+}
+
+// IsDir returns true if the file is a subdirectory
+func (f *File) IsDir() bool {
+	return f.storageType == subdirectory
+}
+
+// dirEntry is an entry in a directory block
+type dirEntry struct {
+	block  uint16 // Block the entry is in
+	number int    // Number of the entry in the block, the header of a key block is 1
+	data   []byte
+}
+
+// newFile returns the file in a directory entry
+func newFile(entry []byte) File {
+	return File{
+		Name:        entryFileName(entry),
+		Type:        entry[entryFileType],
+		AuxType:     word(entry, entryAuxType),
+		BlocksUsed:  int(word(entry, entryBlocksUsed)),
+		EOF:         int(word(entry, entryEOF)) | int(entry[entryEOF+2])<<16,
+		Created:     dateTime(entry[entryCreated:]),
+		Modified:    dateTime(entry[entryModified:]),
+		Locked:      (entry[entryAccess] & accessWrite) == 0,
+		storageType: entry[entryStorageType] >> 4,
+	}
+}
+
+// directoryBlocks returns the blocks of a directory in order
+func (v *Volume) directoryBlocks(key uint16) ([]uint16, error) {
+	var blocks []uint16
+
+	for block := key; block != 0; block = word(v.block(block), 2) {
+		if !v.validBlock(block) || len(blocks) == maxDirectoryBlocks {
+			return nil, fmt.Errorf("Invalid directory block %d", block)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// directoryEntries returns the entries of a directory, including deleted
+// ones but not the header
+func (v *Volume) directoryEntries(key uint16) ([]dirEntry, error) {
+	blocks, err := v.directoryBlocks(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []dirEntry
+	for i, block := range blocks {
+		for n := 1; n <= entriesPerBlock; n++ {
+			if i == 0 && n == 1 {
+				continue
+			}
+
+			offset := 4 + (n-1)*entryLength
+			entries = append(entries, dirEntry{block, n, v.block(block)[offset : offset+entryLength]})
+		}
+	}
+
+	return entries, nil
+}
+
+// splitPath returns the names in a path. Paths are relative to the volume
+// directory, with an optional leading slash.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool { return c == '/' })
+}
+
+// findEntry returns the entry with a name in a directory, or nil if there
+// isn't one
+func (v *Volume) findEntry(key uint16, name string) (*dirEntry, error) {
+	entries, err := v.directoryEntries(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if e.data[entryStorageType]>>4 != deletedEntry && strings.EqualFold(entryFileName(e.data), name) {
+			return e, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// lookup returns the entry of the file or subdirectory at path
+func (v *Volume) lookup(path string) (*dirEntry, error) {
+	names := splitPath(path)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%q is the volume directory", path)
+	}
+
+	key := uint16(volumeDirectoryBlock)
+	var entry *dirEntry
+	for i, name := range names {
+		var err error
+		if entry, err = v.findEntry(key, name); err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("%q not found", path)
+		}
+
+		if i < len(names)-1 {
+			if entry.data[entryStorageType]>>4 != subdirectory {
+				return nil, fmt.Errorf("%q is not a directory", strings.Join(names[:i+1], "/"))
+			}
+			key = word(entry.data, entryKeyPointer)
+		}
+	}
+
+	return entry, nil
+}
+
+// directoryKey returns the key block of the directory at path
+func (v *Volume) directoryKey(path string) (uint16, error) {
+	if len(splitPath(path)) == 0 {
+		return volumeDirectoryBlock, nil
+	}
+
+	entry, err := v.lookup(path)
+	if err != nil {
+		return 0, err
+	}
+	if entry.data[entryStorageType]>>4 != subdirectory {
+		return 0, fmt.Errorf("%q is not a directory", path)
+	}
+
+	return word(entry.data, entryKeyPointer), nil
+}
+
+// parent returns the key block of the directory a path is in and the last
+// name in the path, checked and in upper case
+func (v *Volume) parent(path string) (uint16, string, error) {
+	names := splitPath(path)
+	if len(names) == 0 {
+		return 0, "", fmt.Errorf("%q is the volume directory", path)
+	}
+
+	key, err := v.directoryKey(strings.Join(names[:len(names)-1], "/"))
+	if err != nil {
+		return 0, "", err
+	}
+
+	name, err := checkName(names[len(names)-1])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return key, name, nil
+}
+
+// ReadDir returns the files in the directory at path, "/" for the volume
+// directory
+func (v *Volume) ReadDir(path string) ([]File, error) {
+	key, err := v.directoryKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := v.directoryEntries(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, e := range entries {
+		if e.data[entryStorageType]>>4 != deletedEntry {
+			files = append(files, newFile(e.data))
+		}
+	}
+
+	return files, nil
+}
+
+// Stat returns the file or subdirectory at path
+func (v *Volume) Stat(path string) (*File, error) {
+	entry, err := v.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := newFile(entry.data)
+	return &f, nil
+}
+
+// addFileCount adds to the number of files in a directory's header
+func (v *Volume) addFileCount(key uint16, n int) {
+	header := v.header(key)
+	setWord(header, headerFileCount, uint16(int(word(header, headerFileCount))+n))
+}
+
+// newEntry returns a free entry in a directory. A block is added to a full
+// subdirectory, the volume directory has a fixed size.
+func (v *Volume) newEntry(key uint16) (*dirEntry, error) {
+	entries, err := v.directoryEntries(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if entries[i].data[entryStorageType]>>4 == deletedEntry {
+			return &entries[i], nil
+		}
+	}
+
+	if key == volumeDirectoryBlock {
+		return nil, fmt.Errorf("Volume directory full")
+	}
+
+	block, err := v.allocate()
+	if err != nil {
+		return nil, err
+	}
+
+	last := entries[len(entries)-1].block
+	setWord(v.block(last), 2, block)
+	setWord(v.block(block), 0, last)
+
+	// The subdirectory's entry in its parent has the new size
+	header := v.header(key)
+	parent := v.block(word(header, headerParentPointer))
+	offset := 4 + (int(header[headerParentEntry])-1)*entryLength
+	entry := parent[offset : offset+entryLength]
+	blocks := word(entry, entryBlocksUsed) + 1
+	setWord(entry, entryBlocksUsed, blocks)
+	setEOF(entry, int(blocks)*blockLength)
+
+	return &dirEntry{block, 1, v.block(block)[4 : 4+entryLength]}, nil
+}
+
+// setEOF sets the 24 bit length of the file in an entry
+func setEOF(entry []byte, eof int) {
+	setWord(entry, entryEOF, uint16(eof))
+	entry[entryEOF+2] = uint8(eof >> 16)
+}
+
+// initEntry fills in an entry for a new file or subdirectory
+func initEntry(entry []byte, storageType uint8, name string, fileType uint8, auxType uint16, key uint16, blocksUsed int, eof int, header uint16) {
+	for i := range entry {
+		entry[i] = 0
+	}
+
+	now := time.Now()
+	setEntryName(entry, storageType, name)
+	entry[entryFileType] = fileType
+	setWord(entry, entryKeyPointer, key)
+	setWord(entry, entryBlocksUsed, uint16(blocksUsed))
+	setEOF(entry, eof)
+	setDateTime(entry[entryCreated:], now)
+	entry[entryAccess] = defaultAccess
+	setWord(entry, entryAuxType, auxType)
+	setDateTime(entry[entryModified:], now)
+	setWord(entry, entryHeader, header)
+}
+
+// update runs f on a copy of the volume and keeps the changes if it succeeds,
+// so a full volume or directory leaves the volume as it was
+func (v *Volume) update(f func(v *Volume) error) error {
+	updated := &Volume{Blocks: append([]byte(nil), v.Blocks...)}
+	if err := f(updated); err != nil {
+		return err
+	}
+
+	copy(v.Blocks, updated.Blocks)
+	return nil
+}
+
+// Mkdir creates a subdirectory at path
+func (v *Volume) Mkdir(path string) error {
+	return v.update(func(v *Volume) error {
+		key, name, err := v.parent(path)
+		if err != nil {
+			return err
+		}
+
+		if existing, err := v.findEntry(key, name); err != nil || existing != nil {
+			if err == nil {
+				err = fmt.Errorf("%q already exists", path)
+			}
+			return err
+		}
+
+		entry, err := v.newEntry(key)
+		if err != nil {
+			return err
+		}
+
+		block, err := v.allocate()
+		if err != nil {
+			return err
+		}
+
+		header := v.header(block)
+		setEntryName(header, subdirectoryHeader, name)
+		header[headerReserved] = subdirectoryMagic
+		setDateTime(header[entryCreated:], time.Now())
+		header[entryAccess] = defaultAccess
+		header[headerEntryLength] = entryLength
+		header[headerEntriesPerBlock] = entriesPerBlock
+		setWord(header, headerParentPointer, entry.block)
+		header[headerParentEntry] = uint8(entry.number)
+		header[headerParentLength] = entryLength
+
+		initEntry(entry.data, subdirectory, name, DirectoryType, 0, block, 1, blockLength, key)
+		v.addFileCount(key, 1)
+
+		return nil
+	})
+}
+
+// Remove deletes a file or an empty subdirectory and frees its blocks
+func (v *Volume) Remove(path string) error {
+	return v.update(func(v *Volume) error {
+		return v.remove(path)
+	})
+}
+
+// remove deletes a file or an empty subdirectory on a volume that's being
+// updated
+func (v *Volume) remove(path string) error {
+	entry, err := v.lookup(path)
+	if err != nil {
+		return err
+	}
+
+	names := splitPath(path)
+	parent, err := v.directoryKey(strings.Join(names[:len(names)-1], "/"))
+	if err != nil {
+		return err
+	}
+
+	if (entry.data[entryAccess] & accessDestroy) == 0 {
+		return fmt.Errorf("%q is locked", path)
+	}
+
+	storageType := entry.data[entryStorageType] >> 4
+	key := word(entry.data, entryKeyPointer)
+
+	var blocks []uint16
+	if storageType == subdirectory {
+		if word(v.header(key), headerFileCount) != 0 {
+			return fmt.Errorf("Directory %q isn't empty", path)
+		}
+		blocks, err = v.directoryBlocks(key)
+	} else {
+		blocks, err = v.fileBlocks(storageType, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		v.setFree(b, true)
+	}
+
+	entry.data[entryStorageType] = deletedEntry
+	v.addFileCount(parent, -1)
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package prodos
+
+import (
+	"fmt"
+)
+
+const pointersPerIndexBlock = 256
+const pointersPerMasterIndexBlock = 128
+const maxEOF = 0xffffff
+
+// indexPointer returns pointer i of an index block, which has the low bytes
+// in its first half and the high bytes in its second half
+func indexPointer(index []byte, i int) uint16 {
+	return uint16(index[i]) | uint16(index[i+pointersPerIndexBlock])<<8
+}
+
+// setIndexPointer sets pointer i of an index block
+func setIndexPointer(index []byte, i int, block uint16) {
+	index[i] = uint8(block)
+	index[i+pointersPerIndexBlock] = uint8(block >> 8)
+}
+
+// checkBlock returns an error if a block pointer in a file isn't on the
+// volume
+func (v *Volume) checkBlock(block uint16) error {
+	if !v.validBlock(block) {
+		return fmt.Errorf("Invalid block %d in file", block)
+	}
+
+	return nil
+}
+
+// indexBlocks returns the non-zero pointers in an index block
+func (v *Volume) indexBlocks(index uint16, pointers int) ([]uint16, error) {
+	var blocks []uint16
+	for i := 0; i < pointers; i++ {
+		if b := indexPointer(v.block(index), i); b != 0 {
+			if err := v.checkBlock(b); err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, b)
+		}
+	}
+
+	return blocks, nil
+}
+
+// fileBlocks returns all the blocks used by a seedling, sapling or tree file,
+// including its index blocks
+func (v *Volume) fileBlocks(storageType uint8, key uint16) ([]uint16, error) {
+	if err := v.checkBlock(key); err != nil {
+		return nil, err
+	}
+	blocks := []uint16{key}
+
+	switch storageType {
+	case seedling:
+		return blocks, nil
+
+	case sapling:
+		data, err := v.indexBlocks(key, pointersPerIndexBlock)
+		return append(blocks, data...), err
+
+	case tree:
+		indexes, err := v.indexBlocks(key, pointersPerMasterIndexBlock)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, index := range indexes {
+			data, err := v.indexBlocks(index, pointersPerIndexBlock)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(append(blocks, index), data...)
+		}
+		return blocks, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported storage type %d", storageType)
+	}
+}
+
+// dataBlock returns the block with block i of a file's data, 0 if it's a
+// sparse block of zeroes
+func (v *Volume) dataBlock(storageType uint8, key uint16, i int) (uint16, error) {
+	block := key
+	switch storageType {
+	case seedling:
+		if i > 0 {
+			block = 0
+		}
+
+	case sapling:
+		block = 0
+		if i < pointersPerIndexBlock {
+			block = indexPointer(v.block(key), i)
+		}
+
+	case tree:
+		block = indexPointer(v.block(key), i/pointersPerIndexBlock)
+		if block != 0 {
+			if err := v.checkBlock(block); err != nil {
+				return 0, err
+			}
+			block = indexPointer(v.block(block), i%pointersPerIndexBlock)
+		}
+
+	default:
+		return 0, fmt.Errorf("Unsupported storage type %d", storageType)
+	}
+
+	if block != 0 {
+		if err := v.checkBlock(block); err != nil {
+			return 0, err
+		}
+	}
+
+	return block, nil
+}
+
+// ReadFile returns the file at path and its data
+func (v *Volume) ReadFile(path string) (*File, []byte, error) {
+	entry, err := v.lookup(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := newFile(entry.data)
+	if f.IsDir() {
+		return nil, nil, fmt.Errorf("%q is a directory", path)
+	}
+
+	key := word(entry.data, entryKeyPointer)
+	if err := v.checkBlock(key); err != nil {
+		return nil, nil, err
+	}
+
+	data := make([]byte, 0, f.EOF+blockLength)
+	for i := 0; len(data) < f.EOF; i++ {
+		block, err := v.dataBlock(f.storageType, key, i)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if block == 0 {
+			data = append(data, make([]byte, blockLength)...)
+		} else {
+			data = append(data, v.block(block)...)
+		}
+	}
+
+	return &f, data[:f.EOF], nil
+}
+
+// writeBlocks allocates blocks for data and writes it to them. It returns the
+// blocks in order.
+func (v *Volume) writeBlocks(data []byte) ([]uint16, error) {
+	var blocks []uint16
+	for i := 0; i == 0 || i*blockLength < len(data); i++ {
+		block, err := v.allocate()
+		if err != nil {
+			return nil, err
+		}
+
+		if i*blockLength < len(data) {
+			copy(v.block(block), data[i*blockLength:])
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// writeIndexBlock allocates an index block pointing at blocks
+func (v *Volume) writeIndexBlock(blocks []uint16) (uint16, error) {
+	index, err := v.allocate()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, b := range blocks {
+		setIndexPointer(v.block(index), i, b)
+	}
+
+	return index, nil
+}
+
+// WriteFile writes a file with a ProDOS file type and aux type. An existing
+// file with the same name is replaced, unless it's locked. Files of up to 512
+// bytes are seedlings, up to 128K saplings and larger files trees. Nothing is
+// changed if there isn't enough room.
+func (v *Volume) WriteFile(path string, fileType uint8, auxType uint16, data []byte) error {
+	if len(data) > maxEOF {
+		return fmt.Errorf("File is too long, %d bytes is more than the most ProDOS can hold, %d", len(data), maxEOF)
+	}
+
+	return v.update(func(v *Volume) error {
+		key, name, err := v.parent(path)
+		if err != nil {
+			return err
+		}
+
+		if existing, err := v.findEntry(key, name); err != nil {
+			return err
+		} else if existing != nil {
+			if existing.data[entryStorageType]>>4 == subdirectory {
+				return fmt.Errorf("%q is a directory", path)
+			}
+			if err := v.remove(path); err != nil {
+				return err
+			}
+		}
+
+		entry, err := v.newEntry(key)
+		if err != nil {
+			return err
+		}
+
+		blocks, err := v.writeBlocks(data)
+		if err != nil {
+			return err
+		}
+		blocksUsed := len(blocks)
+
+		storageType, keyPointer := uint8(seedling), blocks[0]
+		switch {
+		case len(blocks) == 1:
+
+		case len(blocks) <= pointersPerIndexBlock:
+			storageType = sapling
+			if keyPointer, err = v.writeIndexBlock(blocks); err != nil {
+				return err
+			}
+			blocksUsed++
+
+		default:
+			var indexes []uint16
+			for i := 0; i < len(blocks); i += pointersPerIndexBlock {
+				end := i + pointersPerIndexBlock
+				if end > len(blocks) {
+					end = len(blocks)
+				}
+
+				index, err := v.writeIndexBlock(blocks[i:end])
+				if err != nil {
+					return err
+				}
+				indexes = append(indexes, index)
+			}
+
+			storageType = tree
+			if keyPointer, err = v.writeIndexBlock(indexes); err != nil {
+				return err
+			}
+			blocksUsed += len(indexes) + 1
+		}
+
+		initEntry(entry.data, storageType, name, fileType, auxType, keyPointer, blocksUsed, len(data), key)
+		v.addFileCount(key, 1)
+
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package prodos
+
+import (
+	"fmt"
+	"time"
+)
+
+const bootBlocks = 2            // Blocks 0 and 1 have the boot loader
+const volumeDirectoryBlocks = 4 // Blocks 2-5
+const bitmapBlock = volumeDirectoryBlock + volumeDirectoryBlocks
+
+// Format returns a ProDOS volume with blocks blocks and an empty volume
+// directory named name. If boot isn't nil, the boot loader in its first two
+// blocks is copied, so the volume boots if it has a PRODOS file.
+func Format(name string, blocks int, boot []byte) ([]byte, error) {
+	name, err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmapBlocks := (blocks + bitsPerBitmapBlock - 1) / bitsPerBitmapBlock
+	if blocks <= bitmapBlock+bitmapBlocks || blocks > maxBlocks {
+		return nil, fmt.Errorf("Invalid number of blocks %d, expected %d to %d", blocks, bitmapBlock+bitmapBlocks+1, maxBlocks)
+	}
+
+	volume := make([]byte, blocks*blockLength)
+	if boot != nil {
+		if len(boot) < bootBlocks*blockLength {
+			return nil, fmt.Errorf("Boot image is too short, it has %d bytes", len(boot))
+		}
+		copy(volume, boot[:bootBlocks*blockLength])
+	}
+
+	v := &Volume{Blocks: volume}
+	for b := uint16(volumeDirectoryBlock); b < bitmapBlock; b++ {
+		if b > volumeDirectoryBlock {
+			setWord(v.block(b), 0, b-1)
+		}
+		if b < bitmapBlock-1 {
+			setWord(v.block(b), 2, b+1)
+		}
+	}
+
+	header := v.header(volumeDirectoryBlock)
+	setEntryName(header, volumeDirectoryHeader, name)
+	setDateTime(header[entryCreated:], time.Now())
+	header[entryAccess] = defaultAccess
+	header[headerEntryLength] = entryLength
+	header[headerEntriesPerBlock] = entriesPerBlock
+	setWord(header, headerBitmapPointer, bitmapBlock)
+	setWord(header, headerTotalBlocks, uint16(blocks))
+
+	for b := bitmapBlock + bitmapBlocks; b < blocks; b++ {
+		v.setFree(uint16(b), true)
+	}
+
+	return volume, nil
+}
@@ -0,0 +1,250 @@
+package prodos
+
+// Reading and writing files on ProDOS volumes. A volume is a sequence of 512
+// byte blocks. Block 2 is the key block of the volume directory, whose header
+// points at the bitmap of free blocks. Directories are chains of blocks with
+// 13 entries each. Files are seedlings with one data block, saplings with an
+// index block or trees with a master index block pointing at index blocks.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const blockLength = 512
+const volumeDirectoryBlock = 2
+const maxBlocks = 0xffff
+const bitsPerBitmapBlock = blockLength * 8
+
+// Storage types, in the top 4 bits of the first byte of an entry
+const (
+	deletedEntry          = 0x0
+	seedling              = 0x1
+	sapling               = 0x2
+	tree                  = 0x3
+	subdirectory          = 0xd
+	subdirectoryHeader    = 0xe
+	volumeDirectoryHeader = 0xf
+)
+
+// Directory entry fields, the header has the same name and access fields
+const (
+	entryStorageType = 0x00 // Storage type and name length
+	entryName        = 0x01
+	entryFileType    = 0x10
+	entryKeyPointer  = 0x11
+	entryBlocksUsed  = 0x13
+	entryEOF         = 0x15
+	entryCreated     = 0x18
+	entryVersion     = 0x1c
+	entryMinVersion  = 0x1d
+	entryAccess      = 0x1e
+	entryAuxType     = 0x1f
+	entryModified    = 0x21
+	entryHeader      = 0x25 // Key block of the directory the entry is in
+
+	headerReserved        = 0x10 // Subdirectory headers have $75 here
+	headerEntryLength     = 0x1f
+	headerEntriesPerBlock = 0x20
+	headerFileCount       = 0x21
+	headerBitmapPointer   = 0x23 // Volume directory only
+	headerTotalBlocks     = 0x25 // Volume directory only
+	headerParentPointer   = 0x23 // Subdirectory only, the block with its entry
+	headerParentEntry     = 0x25 // Subdirectory only, the entry number in that block
+	headerParentLength    = 0x26 // Subdirectory only
+
+	entryLength     = 0x27
+	entriesPerBlock = 0x0d
+	maxNameLength   = 15
+)
+
+const subdirectoryMagic = 0x75
+
+// Access bits
+const (
+	accessRead    = 0x01
+	accessWrite   = 0x02
+	accessBackup  = 0x20
+	accessRename  = 0x40
+	accessDestroy = 0x80
+
+	defaultAccess = accessDestroy | accessRename | accessBackup | accessWrite | accessRead
+)
+
+// Volume is a ProDOS volume
+type Volume struct {
+	Blocks []byte // The volume's blocks in ProDOS order
+}
+
+// New returns the volume in blocks. The blocks must have a ProDOS volume
+// directory.
+func New(blocks []byte) (*Volume, error) {
+	if len(blocks) < (volumeDirectoryBlock+1)*blockLength || len(blocks)%blockLength != 0 {
+		return nil, fmt.Errorf("Volume has invalid length %d, expected a multiple of %d", len(blocks), blockLength)
+	}
+
+	v := &Volume{Blocks: blocks}
+	header := v.header(volumeDirectoryBlock)
+	if header[entryStorageType]>>4 != volumeDirectoryHeader || header[headerEntryLength] != entryLength || header[headerEntriesPerBlock] != entriesPerBlock {
+		return nil, fmt.Errorf("Volume doesn't have a ProDOS volume directory")
+	}
+
+	if total := v.TotalBlocks(); total > len(blocks)/blockLength || int(v.bitmapPointer())+(total+bitsPerBitmapBlock-1)/bitsPerBitmapBlock > total {
+		return nil, fmt.Errorf("Volume directory has an invalid number of blocks %d or bitmap block %d", total, v.bitmapPointer())
+	}
+
+	return v, nil
+}
+
+// block returns the 512 bytes of a block
+func (v *Volume) block(block uint16) []byte {
+	offset := int(block) * blockLength
+	return v.Blocks[offset : offset+blockLength]
+}
+
+// validBlock returns true if a block is on the volume and isn't a boot block
+func (v *Volume) validBlock(block uint16) bool {
+	return block >= volumeDirectoryBlock && int(block) < v.TotalBlocks()
+}
+
+// header returns the header entry in a directory's key block
+func (v *Volume) header(key uint16) []byte {
+	return v.block(key)[4 : 4+entryLength]
+}
+
+// word returns the 16 bit little endian value at offset
+func word(data []byte, offset int) uint16 {
+	return binary.LittleEndian.Uint16(data[offset:])
+}
+
+// setWord sets the 16 bit little endian value at offset
+func setWord(data []byte, offset int, value uint16) {
+	binary.LittleEndian.PutUint16(data[offset:], value)
+}
+
+// Name returns the volume name
+func (v *Volume) Name() string {
+	return entryFileName(v.header(volumeDirectoryBlock))
+}
+
+// TotalBlocks returns the number of blocks on the volume
+func (v *Volume) TotalBlocks() int {
+	return int(word(v.header(volumeDirectoryBlock), headerTotalBlocks))
+}
+
+// bitmapPointer returns the first block of the bitmap of free blocks
+func (v *Volume) bitmapPointer() uint16 {
+	return word(v.header(volumeDirectoryBlock), headerBitmapPointer)
+}
+
+// bitmapBit returns the byte of the bitmap with a block's bit and the bit's
+// mask
+func (v *Volume) bitmapBit(block uint16) (*byte, uint8) {
+	offset := int(v.bitmapPointer())*blockLength + int(block)/8
+	return &v.Blocks[offset], 0x80 >> (block % 8)
+}
+
+// isFree returns true if the bitmap has a block as free
+func (v *Volume) isFree(block uint16) bool {
+	b, mask := v.bitmapBit(block)
+	return (*b & mask) != 0
+}
+
+// setFree marks a block as free or in use in the bitmap
+func (v *Volume) setFree(block uint16, free bool) {
+	b, mask := v.bitmapBit(block)
+	if free {
+		*b |= mask
+	} else {
+		*b &^= mask
+	}
+}
+
+// FreeBlocks returns the number of free blocks
+func (v *Volume) FreeBlocks() int {
+	free := 0
+	for b := 0; b < v.TotalBlocks(); b++ {
+		if v.isFree(uint16(b)) {
+			free++
+		}
+	}
+
+	return free
+}
+
+// allocate marks the first free block as in use, clears it and returns it
+func (v *Volume) allocate() (uint16, error) {
+	for b := 0; b < v.TotalBlocks(); b++ {
+		if v.isFree(uint16(b)) {
+			v.setFree(uint16(b), false)
+
+			block := v.block(uint16(b))
+			for i := range block {
+				block[i] = 0
+			}
+
+			return uint16(b), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Volume full")
+}
+
+// entryFileName returns the name in an entry or header
+func entryFileName(entry []byte) string {
+	length := int(entry[entryStorageType] & 0x0f)
+	return string(entry[entryName : entryName+length])
+}
+
+// setEntryName sets the storage type and name of an entry or header
+func setEntryName(entry []byte, storageType uint8, name string) {
+	entry[entryStorageType] = storageType<<4 | uint8(len(name))
+	for i := 0; i < maxNameLength; i++ {
+		entry[entryName+i] = 0
+	}
+	copy(entry[entryName:], name)
+}
+
+// checkName returns a name in upper case or an error if it can't be used
+// for a ProDOS file: 1 to 15 letters, digits and periods, starting with a
+// letter
+func checkName(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if len(name) == 0 || len(name) > maxNameLength || name[0] < 'A' || name[0] > 'Z' {
+		return "", fmt.Errorf("Invalid name %q, it must have 1 to %d characters and start with a letter", name, maxNameLength)
+	}
+
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '.' {
+			return "", fmt.Errorf("Invalid name %q, it can only have letters, digits and periods", name)
+		}
+	}
+
+	return name, nil
+}
+
+// dateTime converts a ProDOS date and time to a time, or the zero time if
+// there is no date
+func dateTime(data []byte) time.Time {
+	date := word(data, 0)
+	if date == 0 {
+		return time.Time{}
+	}
+
+	// Years 0-39 are 2000-2039
+	year := int(date >> 9)
+	if year < 40 {
+		year += 100
+	}
+
+	return time.Date(1900+year, time.Month((date>>5)&0x0f), int(date&0x1f), int(data[3]&0x1f), int(data[2]&0x3f), 0, 0, time.Local)
+}
+
+// setDateTime stores a time as a ProDOS date and time
+func setDateTime(data []byte, t time.Time) {
+	setWord(data, 0, uint16(t.Year()%100)<<9|uint16(t.Month())<<5|uint16(t.Day()))
+	data[2] = uint8(t.Minute())
+	data[3] = uint8(t.Hour())
+}
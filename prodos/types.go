@@ -0,0 +1,67 @@
+package prodos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Common ProDOS file types
+const (
+	TypelessType    = 0x00
+	TextType        = 0x04
+	BinaryType      = 0x06
+	DirectoryType   = 0x0f
+	IntegerType     = 0xfa // Integer BASIC program
+	ApplesoftType   = 0xfc // Applesoft BASIC program
+	VariablesType   = 0xfd // Applesoft variables
+	RelocatableType = 0xfe
+	SystemType      = 0xff
+)
+
+var fileTypeNames = map[uint8]string{
+	TypelessType:    "NON",
+	TextType:        "TXT",
+	BinaryType:      "BIN",
+	DirectoryType:   "DIR",
+	0x19:            "ADB",
+	0x1a:            "AWP",
+	0x1b:            "ASP",
+	0xb3:            "S16",
+	0xef:            "PAS",
+	0xf0:            "CMD",
+	IntegerType:     "INT",
+	0xfb:            "IVR",
+	ApplesoftType:   "BAS",
+	VariablesType:   "VAR",
+	RelocatableType: "REL",
+	SystemType:      "SYS",
+}
+
+// FileTypeName returns the three letter name of a file type, or its value in
+// hex such as $F1 if it doesn't have one
+func FileTypeName(fileType uint8) string {
+	if name, ok := fileTypeNames[fileType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("$%02X", fileType)
+}
+
+// ParseFileType returns the file type with a three letter name such as BIN,
+// or a hex value such as $F1
+func ParseFileType(s string) (uint8, error) {
+	for fileType, name := range fileTypeNames {
+		if strings.EqualFold(name, s) {
+			return fileType, nil
+		}
+	}
+
+	if strings.HasPrefix(s, "$") {
+		if value, err := strconv.ParseUint(s[1:], 16, 8); err == nil {
+			return uint8(value), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid file type %q, expected a name such as BIN or a hex value such as $F1", s)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/prodos"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProDOSFiles tests reading and writing files and directories on a
+// ProDOS volume
+func TestProDOSFiles(t *testing.T) {
+	t.Parallel()
+
+	_, err := prodos.New(make([]byte, 280*512))
+	assert.NotNil(t, err)
+
+	volume, err := prodos.Format("TEST", 800, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := prodos.New(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "TEST", v.Name())
+	assert.Equal(t, 800, v.TotalBlocks())
+	free := v.FreeBlocks()
+	assert.Equal(t, 800-7, free)
+
+	// Seedling, sapling and tree files take 1, 4+1 and 260+2+1 blocks
+	tests := []struct {
+		name   string
+		length int
+		blocks int
+	}{
+		{"SEEDLING", 100, 1},
+		{"SAPLING", 2000, 5},
+		{"tree.file", 130 * 1024, 263},
+	}
+	for i, test := range tests {
+		data := make([]byte, test.length)
+		for j := range data {
+			data[j] = byte(j*7 + j/512 + i)
+		}
+		assert.Nil(t, v.WriteFile("/"+test.name, prodos.BinaryType, 0x2000, data))
+
+		f, read, err := v.ReadFile(test.name)
+		assert.Nil(t, err)
+		assert.Equal(t, data, read, test.name)
+		assert.Equal(t, test.blocks, f.BlocksUsed, test.name)
+		assert.Equal(t, test.length, f.EOF)
+		assert.Equal(t, uint16(0x2000), f.AuxType)
+		assert.Equal(t, "BIN", prodos.FileTypeName(f.Type))
+		assert.False(t, f.Modified.IsZero())
+	}
+	assert.Equal(t, free-1-5-263, v.FreeBlocks())
+
+	files, err := v.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SEEDLING", "SAPLING", "TREE.FILE"}, []string{files[0].Name, files[1].Name, files[2].Name})
+
+	// Writing a file again replaces it
+	assert.Nil(t, v.WriteFile("SAPLING", prodos.TextType, 0, []byte("TEXT")))
+	files, err = v.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, free-1-1-263, v.FreeBlocks())
+
+	// A subdirectory grows when its first block is full
+	assert.Nil(t, v.Mkdir("DIR"))
+	assert.NotNil(t, v.Mkdir("DIR"))
+	for i := 0; i < 13; i++ {
+		assert.Nil(t, v.WriteFile(fmt.Sprintf("DIR/FILE%d", i), prodos.TextType, 0, []byte{byte(i)}))
+	}
+	files, err = v.ReadDir("DIR")
+	assert.Nil(t, err)
+	assert.Equal(t, 13, len(files))
+	dir, err := v.Stat("DIR")
+	assert.Nil(t, err)
+	assert.True(t, dir.IsDir())
+	assert.Equal(t, 2, dir.BlocksUsed)
+	assert.Equal(t, 1024, dir.EOF)
+	_, data, err := v.ReadFile("dir/file12")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{12}, data)
+	_, _, err = v.ReadFile("DIR")
+	assert.NotNil(t, err)
+
+	// Only empty directories can be removed, which frees all their blocks
+	assert.NotNil(t, v.Remove("DIR"))
+	for i := 0; i < 13; i++ {
+		assert.Nil(t, v.Remove(fmt.Sprintf("DIR/FILE%d", i)))
+	}
+	assert.Nil(t, v.Remove("DIR"))
+	for _, name := range []string{"SEEDLING", "SAPLING", "TREE.FILE"} {
+		assert.Nil(t, v.Remove(name))
+	}
+	assert.Equal(t, free, v.FreeBlocks())
+	assert.NotNil(t, v.Remove("SEEDLING"))
+
+	// The volume directory has room for 51 entries and a file that doesn't fit
+	// leaves the volume as it was
+	for i := 0; i < 51; i++ {
+		assert.Nil(t, v.WriteFile(fmt.Sprintf("F%d", i), prodos.TextType, 0, nil))
+	}
+	before := append([]byte(nil), v.Blocks...)
+	assert.NotNil(t, v.WriteFile("F51", prodos.TextType, 0, nil))
+	assert.Nil(t, v.Remove("F0"))
+	assert.NotNil(t, v.WriteFile("F0", prodos.BinaryType, 0, make([]byte, 800*512)))
+	assert.Nil(t, v.WriteFile("F0", prodos.TextType, 0, nil))
+	assert.Equal(t, before[6*512:], v.Blocks[6*512:])
+
+	// Invalid names
+	assert.NotNil(t, v.WriteFile("1ST", prodos.TextType, 0, nil))
+	assert.NotNil(t, v.WriteFile("A_B", prodos.TextType, 0, nil))
+	assert.NotNil(t, v.WriteFile("ABCDEFGHIJKLMNOP", prodos.TextType, 0, nil))
+	assert.NotNil(t, v.WriteFile("MISSING/FILE", prodos.TextType, 0, nil))
+
+	fileType, err := prodos.ParseFileType("sys")
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0xff), fileType)
+	fileType, err = prodos.ParseFileType("$F1")
+	assert.Nil(t, err)
+	assert.Equal(t, "$F1", prodos.FileTypeName(fileType))
+}
+
+// TestProDOSOrderBlocks tests that ProDOS volumes in DOS 3.3 order images are
+// rearranged into blocks and back
+func TestProDOSOrderBlocks(t *testing.T) {
+	t.Parallel()
+
+	volume, err := prodos.Format("TEST", 280, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 7 * 512; i < len(volume); i++ {
+		volume[i] = byte(i / 256)
+	}
+
+	image := disk.DOSOrderImage(volume)
+	assert.False(t, bytes.Equal(volume, image))
+
+	blocks, dosOrdered := disk.ProDOSOrderBlocks("test.dsk", image)
+	assert.True(t, dosOrdered)
+	assert.Equal(t, volume, blocks)
+
+	blocks, dosOrdered = disk.ProDOSOrderBlocks("test.dsk", volume)
+	assert.False(t, dosOrdered)
+	assert.Equal(t, volume, blocks)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/disk"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/freewilll/apple2-go/mmu"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSaveState writes a save state file the way machine.SaveState does, so
+// that tests can make states the machine wouldn't save. gob matches fields
+// by name, so the types don't need to be the ones in the machine package.
+func writeSaveState(t *testing.T, path string, version int, state interface{}) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	encoder := gob.NewEncoder(zw)
+	header := struct {
+		Magic   string
+		Version int
+	}{"apple2-go save state", version}
+	if err := encoder.Encode(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSaveState saves the state of a machine and checks that loading it into a
+// fresh machine restores the registers, memory, soft switches and drive.
+func TestSaveState(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.state")
+
+	m1 := machine.New(cpu.Model65C02)
+	m1.CPU.State.A = 0x12
+	m1.CPU.State.PC = 0x1234
+	m1.MMU.WriteMemory(0x2000, 0x42)
+	m1.MMU.WriteMemory(0xc005, 0x00) // SETAUXWR
+	m1.MMU.WriteMemory(0x2000, 0x43)
+	m1.MMU.ReadMemory(0xc057)        // SETHIRES
+	m1.MMU.WriteMemory(0xc05e, 0x00) // CLRAN3
+	m1.MMU.ReadMemory(0xc0e3)        // Phase 1 on, which moves the head of drive 1
+	m1.MMU.ReadMemory(0xc0eb)        // SELDRV2
+	diskPath := writeTestDiskImage(t, dir, "test.dsk", 0x42)
+	assert.Nil(t, m1.Disk.InsertDiskImage(2, diskPath))
+	hardDiskPath := writeTestHardDiskImage(t, dir, "test.po", 280, nil)
+	assert.Nil(t, m1.HardDisk.InsertImage(1, hardDiskPath))
+
+	assert.Nil(t, m1.SaveState(path))
+
+	m2 := machine.New(cpu.Model6502)
+	assert.Nil(t, m2.LoadState(path))
+
+	assert.Equal(t, cpu.Model65C02, m2.CPU.Model)
+	assert.Equal(t, uint8(0x12), m2.CPU.State.A)
+	assert.Equal(t, uint16(0x1234), m2.CPU.State.PC)
+	assert.Equal(t, uint8(0x42), m2.MMU.PhysicalMemory.MainMemory[0x2000])
+	assert.Equal(t, uint8(0x43), m2.MMU.PhysicalMemory.AuxMemory[0x2000])
+	assert.Equal(t, uint8(0x8d), m2.MMU.ReadMemory(0xc014)) // RDRAMWR
+	assert.Equal(t, true, m2.MMU.VideoState.HiresMode)
+	assert.Equal(t, false, m2.MMU.Annunciators[3])
+	assert.Equal(t, int8(1), m2.Disk.Phase(1))
+	assert.Equal(t, uint8(2), m2.System.DriveState.Drive)
+	assert.Equal(t, "", m2.Disk.ImagePath(1))
+	assert.Equal(t, diskPath, m2.Disk.ImagePath(2))
+	assert.Equal(t, hardDiskPath, m2.HardDisk.ImagePath(1))
+	assert.Equal(t, 280, m2.HardDisk.BlockCount(1))
+
+	// Writes go to aux memory after the load, since the page tables are restored
+	m2.MMU.WriteMemory(0x2000, 0x44)
+	assert.Equal(t, uint8(0x44), m2.MMU.PhysicalMemory.AuxMemory[0x2000])
+
+	// Loading something that isn't a save state fails and leaves the machine alone
+	assert.Nil(t, ioutil.WriteFile(path, []byte("not a save state"), 0644))
+	assert.NotNil(t, m2.LoadState(path))
+	assert.Equal(t, uint16(0x1234), m2.CPU.State.PC)
+
+	// A state with an invalid disk image fails to load and leaves the machine
+	// alone, including the hard disk that's loaded before the floppy disks
+	otherHardDiskPath := writeTestHardDiskImage(t, dir, "other.po", 280, nil)
+	hardDisk := machine.New(cpu.Model6502).HardDisk
+	assert.Nil(t, hardDisk.InsertImage(1, otherHardDiskPath))
+	badDisk := m2.Disk.SaveState()
+	badDisk.Drives[0].ImagePath = filepath.Join(dir, "bad.dsk")
+	badDisk.Drives[0].Image = []byte("not a disk image")
+	writeSaveState(t, path, 3, struct {
+		CPUModel int
+		CPU      cpu.State
+		Cycles   uint64
+		MMU      *mmu.State
+		Disk     *disk.State
+		HardDisk *disk.HardDiskState
+	}{cpu.Model6502, cpu.State{PC: 0x5678}, 1, m2.MMU.SaveState(), badDisk, hardDisk.SaveState()})
+
+	cycles := m2.System.Cycles
+	assert.NotNil(t, m2.LoadState(path))
+	assert.Equal(t, cpu.Model65C02, m2.CPU.Model)
+	assert.Equal(t, uint16(0x1234), m2.CPU.State.PC)
+	assert.Equal(t, cycles, m2.System.Cycles)
+	assert.Equal(t, diskPath, m2.Disk.ImagePath(2))
+	assert.Equal(t, hardDiskPath, m2.HardDisk.ImagePath(1))
+
+	// Version 2 states have the head position in half tracks and nibbles
+	type driveStateV2 struct {
+		ImagePath    string
+		Image        []byte
+		Phase        int8
+		BytePosition int
+	}
+	image, err := ioutil.ReadFile(diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSaveState(t, path, 2, struct {
+		CPUModel int
+		MMU      *mmu.State
+		Disk     struct{ Drives [2]driveStateV2 }
+	}{cpu.Model6502, m2.MMU.SaveState(), struct{ Drives [2]driveStateV2 }{[2]driveStateV2{{diskPath, image, 3, 100}, {}}}})
+
+	m3 := machine.New(cpu.Model6502)
+	assert.Nil(t, m3.LoadState(path))
+	assert.Equal(t, diskPath, m3.Disk.ImagePath(1))
+	assert.Equal(t, 6, m3.Disk.QuarterTrack(1))
+	assert.Equal(t, 800, m3.Disk.SaveState().Drives[0].BitPosition)
+}
@@ -1,7 +1,7 @@
 package system
 
-// The system package is a dumping ground for globals that are shared between
-// the packages.
+// The system package is a dumping ground for state that is shared between
+// the packages of a single machine.
 
 const (
 	// CPUFrequency is the 6502 CPU frequency in Hz
@@ -11,7 +11,18 @@ const (
 	AudioSampleRate = 44100
 )
 
-var (
+// DriveState has the state of the disk controller. The head positions are
+// kept by the drives in the disk package.
+type DriveState struct {
+	Drive    uint8 // What drive we're using, 1 or 2
+	Spinning bool  // Is the motor spinning
+	Phases   uint8 // the 4 lowest bits represent the 4 stepper motor magnet on/off states.
+	Q6       bool  // Q6 soft switch
+	Q7       bool  // Q7 soft switch
+}
+
+// System contains the state that is shared between the packages
+type System struct {
 	// PendingInterrupt is set when an interrupt has just happened
 	PendingInterrupt bool
 
@@ -44,28 +55,21 @@ var (
 
 	// AudioAttenuationCounter is a counter to keep track of when the audio should be zeroed after inactivity
 	AudioAttenuationCounter uint64
-)
 
-// DriveState has the state of the disk drive
-var DriveState struct {
-	Drive        uint8 // What drive we're using. Currently only 1 is implemented
-	Spinning     bool  // Is the motor spinning
-	Phase        int8  // Phase of the stepper motor
-	Phases       uint8 // the 4 lowest bits represent the 4 stepper motor magnet on/off states.
-	BytePosition int   // Index of the position on the current track
-	Q6           bool  // Q6 soft switch
-	Q7           bool  // Q7 soft switch
+	// DriveState has the state of the disk controller
+	DriveState DriveState
 }
 
-// Init initializes the system-wide state
-func Init() {
-	Cycles = 0
-	AudioChannel = make(chan int16, AudioSampleRate*4) // 1 second
-	LastAudioValue = 0x2000
+// New creates and initializes the system-wide state
+func New() *System {
+	return &System{
+		AudioChannel:   make(chan int16, AudioSampleRate*4), // 1 second
+		LastAudioValue: 0x2000,
+	}
 }
 
 // WriteInterruptTestOpenCollector handles a write to a magic test address that triggers an interrupt and/or an NMI
-func WriteInterruptTestOpenCollector(address uint16, oldValue uint8, value uint8) {
+func (s *System) WriteInterruptTestOpenCollector(address uint16, oldValue uint8, value uint8) {
 	oldInterrupt := (oldValue & 0x1) == 0x1
 	oldNMI := (oldValue & 0x2) == 0x2
 
@@ -73,10 +77,10 @@ func WriteInterruptTestOpenCollector(address uint16, oldValue uint8, value uint8
 	NMI := (value & 0x2) == 0x2
 
 	if oldInterrupt != interrupt {
-		PendingInterrupt = interrupt
+		s.PendingInterrupt = interrupt
 	}
 
 	if oldNMI != NMI {
-		PendingNMI = NMI
+		s.PendingNMI = NMI
 	}
 }
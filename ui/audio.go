@@ -1,42 +1,41 @@
-package audio
+package ui
 
-// This file contains the consumer part of the audio code. audio.go is responsible for producing to it
+// This file contains the consumer part of the audio code. The audio package is responsible for producing to it
 
 import (
 	"errors"
 
-	"github.com/freewilll/apple2-go/system"
 	ebiten_audio "github.com/hajimehoshi/ebiten/audio"
+
+	"github.com/freewilll/apple2-go/audio"
+	"github.com/freewilll/apple2-go/system"
 )
 
+// There can only be one ebiten audio context in a process
 var (
 	audioContext *ebiten_audio.Context // Ebitem audio context
 	player       *ebiten_audio.Player  // Ebitem stream player
-	firstAudio   bool                  // True at startup
-)
-
-var (
-	// Mute ensures no samples are output
-	Mute bool
-
-	// ClickWhenDriveHeadMoves makes the speaker click once every time the stepper motor magnets change
-	ClickWhenDriveHeadMoves bool
 )
 
 // The streaming code is based on the ebiten sinewave example
-type stream struct{}
+type stream struct {
+	audio      *audio.Audio
+	firstAudio bool // True at startup
+}
 
 // Read is called whenever the sound hardware wants some samples. Convert the
 // 16 bit data in the sound buffer to 8 bit stereo values.
 func (s *stream) Read(data []byte) (int, error) {
 	dataLen := len(data)
 
-	if firstAudio {
+	a := s.audio
+
+	if s.firstAudio {
 		// The first time, drain the audio queue and exit
-		firstAudio = false
+		s.firstAudio = false
 
-		for i := 0; i < len(system.AudioChannel); i++ {
-			<-system.AudioChannel
+		for i := 0; i < len(a.System.AudioChannel); i++ {
+			<-a.System.AudioChannel
 		}
 		return dataLen, nil
 	}
@@ -47,8 +46,8 @@ func (s *stream) Read(data []byte) (int, error) {
 	}
 
 	// Do nothing if we're muted, but ensure the channel keeps getting drained
-	if Mute {
-		firstAudio = true
+	if a.Mute {
+		s.firstAudio = true
 		return dataLen, nil
 	}
 
@@ -60,7 +59,7 @@ func (s *stream) Read(data []byte) (int, error) {
 	}
 
 	for i := 0; i < samples; i++ {
-		b := <-system.AudioChannel
+		b := <-a.System.AudioChannel
 
 		data[4*i] = byte(b)
 		data[4*i+1] = byte(b >> 8)
@@ -76,13 +75,9 @@ func (s *stream) Close() error {
 	return nil
 }
 
-// InitEbiten initializes the audio sets up the ebiten output stream
-func InitEbiten() {
-	// Setup initial state
-	firstAudio = true
-	Mute = false
-	ClickWhenDriveHeadMoves = false
-
+// InitAudio sets up the ebiten output stream for a. This can only be done for
+// one machine in a process.
+func InitAudio(a *audio.Audio) {
 	var err error
 	audioContext, err = ebiten_audio.NewContext(system.AudioSampleRate)
 	if err != nil {
@@ -92,7 +87,7 @@ func InitEbiten() {
 	// Pass the (infinite) stream to audio.NewPlayer.
 	// After calling Play, the stream never ends as long as the player object lives.
 	// var err error
-	player, err = ebiten_audio.NewPlayer(audioContext, &stream{})
+	player, err = ebiten_audio.NewPlayer(audioContext, &stream{audio: a, firstAudio: true})
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// ReadClipboard returns the text in the system clipboard. ebiten doesn't
+// support the clipboard, so the platform's command line tool is used.
+func ReadClipboard() (string, error) {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbpaste")
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", "Get-Clipboard")
+	default:
+		if _, err := exec.LookPath("xclip"); err == nil {
+			cmd = exec.Command("xclip", "-selection", "clipboard", "-o")
+		} else {
+			cmd = exec.Command("xsel", "--clipboard", "--output")
+		}
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,119 @@
+package ui
+
+// Translation of ebiten's gamepad, mouse and keypad state to Apple //e
+// paddles and pushbuttons
+
+import (
+	"github.com/hajimehoshi/ebiten"
+
+	"github.com/freewilll/apple2-go/joystick"
+	"github.com/freewilll/apple2-go/video"
+)
+
+// Joystick feeds the first gamepad, the mouse or the keypad into an emulated
+// joystick
+type Joystick struct {
+	Joystick *joystick.Joystick
+	UseMouse bool // Use the mouse position instead of the gamepad axes
+}
+
+// NewJoystick creates an ebiten joystick for j
+func NewJoystick(j *joystick.Joystick) *Joystick {
+	return &Joystick{Joystick: j}
+}
+
+// axisToPaddle converts a gamepad axis value from -1 to 1 into a paddle value
+func axisToPaddle(v float64) uint8 {
+	if v < -1 {
+		v = -1
+	} else if v > 1 {
+		v = 1
+	}
+
+	return uint8((v + 1) * 255 / 2)
+}
+
+// positionToPaddle converts a mouse position from 0 to size-1 into a paddle value
+func positionToPaddle(p int, size int) uint8 {
+	if p < 0 {
+		p = 0
+	} else if p >= size {
+		p = size - 1
+	}
+
+	return uint8(p * 255 / (size - 1))
+}
+
+// pollKeypad returns the paddle values for the keypad keys 1-9. ok is false if
+// none of them are pressed.
+func pollKeypad() (x uint8, y uint8, ok bool) {
+	x = joystick.PaddleCenter
+	y = joystick.PaddleCenter
+
+	if ebiten.IsKeyPressed(ebiten.KeyKP1) || ebiten.IsKeyPressed(ebiten.KeyKP4) || ebiten.IsKeyPressed(ebiten.KeyKP7) {
+		x, ok = 0, true
+	} else if ebiten.IsKeyPressed(ebiten.KeyKP3) || ebiten.IsKeyPressed(ebiten.KeyKP6) || ebiten.IsKeyPressed(ebiten.KeyKP9) {
+		x, ok = 255, true
+	}
+
+	if ebiten.IsKeyPressed(ebiten.KeyKP7) || ebiten.IsKeyPressed(ebiten.KeyKP8) || ebiten.IsKeyPressed(ebiten.KeyKP9) {
+		y, ok = 0, true
+	} else if ebiten.IsKeyPressed(ebiten.KeyKP1) || ebiten.IsKeyPressed(ebiten.KeyKP2) || ebiten.IsKeyPressed(ebiten.KeyKP3) {
+		y, ok = 255, true
+	}
+
+	return x, y, ok || ebiten.IsKeyPressed(ebiten.KeyKP5)
+}
+
+// Poll updates the paddles and pushbuttons. The paddles come from the mouse if
+// UseMouse is set, otherwise from the first gamepad's axes. The keypad
+// overrides both while its keys are held. Pushbutton 0 (open apple) is alt,
+// keypad 0, the left mouse button or gamepad button 0. Alt doesn't count while
+// control is down, so that the ctrl-alt shortcuts don't press it. Pushbutton 1
+// (closed apple) is the menu key, keypad ., the right mouse button or gamepad
+// button 1. Pushbutton 2 is gamepad button 2.
+//
+// On a //e the apple keys are either side of the space bar, so open apple
+// should be left alt and closed apple right alt. ebiten reports both alt keys
+// as KeyAlt, so either alt key presses open apple and closed apple has to
+// make do with the menu key and keypad .
+func (j *Joystick) Poll() {
+	paddles := [4]uint8{joystick.PaddleCenter, joystick.PaddleCenter, joystick.PaddleCenter, joystick.PaddleCenter}
+	var buttons [3]bool
+
+	gamepads := ebiten.GamepadIDs()
+	if len(gamepads) > 0 {
+		id := gamepads[0]
+
+		if !j.UseMouse {
+			for axis := 0; axis < len(paddles) && axis < ebiten.GamepadAxisNum(id); axis++ {
+				paddles[axis] = axisToPaddle(ebiten.GamepadAxis(id, axis))
+			}
+		}
+
+		for button := 0; button < len(buttons) && button < ebiten.GamepadButtonNum(id); button++ {
+			buttons[button] = ebiten.IsGamepadButtonPressed(id, ebiten.GamepadButton0+ebiten.GamepadButton(button))
+		}
+	}
+
+	if j.UseMouse {
+		x, y := ebiten.CursorPosition()
+		paddles[0] = positionToPaddle(x, video.Width)
+		paddles[1] = positionToPaddle(y, video.Height)
+
+		buttons[0] = buttons[0] || ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
+		buttons[1] = buttons[1] || ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight)
+	}
+
+	if x, y, ok := pollKeypad(); ok {
+		paddles[0] = x
+		paddles[1] = y
+	}
+
+	buttons[0] = buttons[0] || ebiten.IsKeyPressed(ebiten.KeyKP0) ||
+		(ebiten.IsKeyPressed(ebiten.KeyAlt) && !ebiten.IsKeyPressed(ebiten.KeyControl))
+	buttons[1] = buttons[1] || ebiten.IsKeyPressed(ebiten.KeyKPDecimal) || ebiten.IsKeyPressed(ebiten.KeyMenu)
+
+	j.Joystick.Paddles = paddles
+	j.Joystick.Buttons = buttons
+}
@@ -0,0 +1,30 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/hajimehoshi/ebiten"
+	"github.com/hajimehoshi/ebiten/ebitenutil"
+
+	"github.com/freewilll/apple2-go/video"
+)
+
+// DrawScreen renders the video memory and copies it to the ebiten screen
+func DrawScreen(v *video.Video, screen *ebiten.Image) error {
+	frame := v.Render()
+
+	if ebiten.IsRunningSlowly() {
+		return nil
+	}
+
+	if err := screen.ReplacePixels(frame.Pix); err != nil {
+		return err
+	}
+
+	if v.ShowFPS {
+		msg := fmt.Sprintf(`FPS: %0.2f`, ebiten.CurrentFPS())
+		ebitenutil.DebugPrint(screen, msg)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/freewilll/apple2-go/cpu"
@@ -52,30 +53,57 @@ func DecodeCmdLineAddress(s *string) (result *uint16) {
 	return result
 }
 
+// DecodeCmdLineText decodes the \n, \r and \\ escapes in text from the
+// command line
+func DecodeCmdLineText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\\`, `\`).Replace(s)
+}
+
+// DecodeCmdLineDrives decodes a comma separated list of drive numbers, e.g.
+// 1,2
+func DecodeCmdLineDrives(s string) (drives []int) {
+	if s == "" {
+		return drives
+	}
+
+	for _, d := range strings.Split(s, ",") {
+		switch strings.TrimSpace(d) {
+		case "1":
+			drives = append(drives, 1)
+		case "2":
+			drives = append(drives, 2)
+		default:
+			panic(fmt.Sprintf("Invalid drive %q", d))
+		}
+	}
+
+	return drives
+}
+
 // RunUntilBreakPoint runs the CPU until it either hits a breakpoint or a time
 // has expired. An assertion is done at the end to ensure the breakpoint has
 // been reached.
-func RunUntilBreakPoint(t *testing.T, breakAddress uint16, seconds int, showInstructions bool, message string) {
+func RunUntilBreakPoint(t *testing.T, c *cpu.CPU, breakAddress uint16, seconds int, showInstructions bool, message string) {
 	fmt.Printf("Running until %#04x: %s \n", breakAddress, message)
-	system.LastAudioCycles = 0
+	c.System.LastAudioCycles = 0
 	exitAtBreak := false
 	disableFirmwareWait := false
 	disableDosDelay := false
-	cpu.Run(showInstructions, &breakAddress, exitAtBreak, disableFirmwareWait, disableDosDelay, uint64(system.CPUFrequency*seconds))
-	if cpu.State.PC != breakAddress {
-		t.Fatalf("Did not reach breakpoint at %04x. Got to %04x", breakAddress, cpu.State.PC)
+	c.Run(showInstructions, &breakAddress, exitAtBreak, disableFirmwareWait, disableDosDelay, uint64(system.CPUFrequency*seconds))
+	if c.State.PC != breakAddress {
+		t.Fatalf("Did not reach breakpoint at %04x. Got to %04x", breakAddress, c.State.PC)
 	}
 }
 
 // Disassemble disassembles and prints the code in memory between start and end
-func Disassemble(start uint16, end uint16) {
-	oldPC := cpu.State.PC
+func Disassemble(c *cpu.CPU, start uint16, end uint16) {
+	oldPC := c.State.PC
 
-	cpu.State.PC = start
-	for cpu.State.PC <= end {
-		cpu.PrintInstruction(false)
-		cpu.AdvanceInstruction()
+	c.State.PC = start
+	for c.State.PC <= end {
+		c.PrintInstruction(false)
+		c.AdvanceInstruction()
 	}
 
-	cpu.State.PC = oldPC
+	c.State.PC = oldPC
 }
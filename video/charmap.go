@@ -1,9 +1,5 @@
 package video
 
-import (
-	"github.com/hajimehoshi/ebiten"
-)
-
 const charMapASCIIArt = `
 0x00
 ---------
@@ -2823,39 +2819,21 @@ const charMapASCIIArt = `
 ---------
 `
 
-var charMap [0x100]*ebiten.Image
+// charMap has the 7x8 pixels of each character. Bit x of each row is set if
+// pixel x is set.
+var charMap [0x100][8]uint8
 
 // initTextCharMap initializes the text character map
 func initTextCharMap() {
 	for c := 0; c < 0x100; c++ {
-		image, err := ebiten.NewImage(7, 8, ebiten.FilterNearest)
-		if err != nil {
-			panic(err)
-		}
-
-		pixels := make([]byte, 8*7*4)
-
 		start := c*105 + 17
 
 		for y := 0; y < 8; y++ {
 			for x := 0; x < 7; x++ {
-				var b float64
-				set := charMapASCIIArt[start+10*y+x] == 'X'
-				if set {
-					b = 1
-				} else {
-					b = 0
+				if charMapASCIIArt[start+10*y+x] == 'X' {
+					charMap[c][y] |= 1 << uint(x)
 				}
-
-				p := 4 * (7*y + x)
-				pixels[p+0] = byte(0xff * b)
-				pixels[p+1] = byte(0xff * b)
-				pixels[p+2] = byte(0xff * b)
-				pixels[p+3] = 0xff
-
 			}
 		}
-		image.ReplacePixels(pixels)
-		charMap[c] = image
 	}
 }
@@ -1,230 +1,300 @@
 package video
 
+// The video is rendered into an in-memory image, so that it can be used both
+// by the ebiten front end and without a display.
+
 import (
-	"fmt"
 	"image"
 	"image/color"
 
-	"github.com/hajimehoshi/ebiten"
-	"github.com/hajimehoshi/ebiten/ebitenutil"
-
 	"github.com/freewilll/apple2-go/mmu"
 )
 
 const (
+	// Width is the width of a rendered frame in pixels
+	Width = 560
+
+	// Height is the height of a rendered frame in pixels
+	Height = 384
+
 	textVideoMemory = 0x400 // Base location of page 1 text video memory
 	flashFrames     = 11    // Number of frames when FLASH mode is toggled
 )
 
 // drawTextLoresByte is a function definition used for mixed text/lores rendering
-type drawTextLoresByte func(*ebiten.Image, int, int, uint8) error
+type drawTextLoresByte func(int, int, uint8)
 
 var (
-	flashCounter           int               // Counter used for flashing characters on the text screen
-	flashOn                bool              // Are we currently flashing?
-	monochromeLoresSquares [16]*ebiten.Image // Monochrome blocks for lores rendering
-	colorLoresSquares      [16]*ebiten.Image // Colored blocks for lores rendering
-	colors                 [16]color.NRGBA   // 4-bit Colors
-
-	// ShowFPS determines if the FPS is shown in the corner of the video
-	ShowFPS    bool
-	Monochrome bool
+	colors           [16]color.RGBA // 4-bit Colors
+	monochromeColors [16]color.RGBA // Monochrome versions of the 4-bit colors for lores rendering
+
+	black = color.RGBA{0, 0, 0, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	green = color.RGBA{51, 191, 51, 0xff} // White scaled by 0.20, 0.75, 0.20
 )
 
-// initLoresSquares creates 16 colored squares for the lores renderer
-func initLoresSquares() {
-	var err error
-	for i := 0; i < 16; i++ {
-		monochromeLoresSquares[i], err = ebiten.NewImage(7, 4, ebiten.FilterNearest)
-		if err != nil {
-			panic(err)
-		}
+// Video renders the video memory of an MMU
+type Video struct {
+	MMU *mmu.MMU
 
-		colorLoresSquares[i], err = ebiten.NewImage(7, 4, ebiten.FilterNearest)
-		if err != nil {
-			panic(err)
-		}
-	}
+	ShowFPS    bool // Show the FPS in the corner of the video
+	Monochrome bool // Render in green monochrome instead of color
+
+	frame        *image.RGBA // The rendered frame
+	flashCounter int         // Counter used for flashing characters on the text screen
+	flashOn      bool        // Are we currently flashing?
+}
 
+func init() {
+	initTextCharMap()
+	initColors()
+}
+
+// initColors sets up the 16 colors and their monochrome equivalents for the lores renderer
+func initColors() {
 	// From
 	// https://mrob.com/pub/xgithub.com/freewilll/apple2/colors.html
 	// https://archive.org/details/IIgs_2523063_Master_Color_Values
 	alpha := uint8(0xff)
 
-	colors[0x00] = color.NRGBA{0, 0, 0, alpha}
-	colors[0x01] = color.NRGBA{221, 0, 51, alpha}
-	colors[0x02] = color.NRGBA{0, 0, 153, alpha}
-	colors[0x03] = color.NRGBA{221, 34, 221, alpha}
-	colors[0x04] = color.NRGBA{0, 119, 34, alpha}
-	colors[0x05] = color.NRGBA{85, 85, 85, alpha}
-	colors[0x06] = color.NRGBA{34, 34, 255, alpha}
-	colors[0x07] = color.NRGBA{102, 170, 255, alpha}
-	colors[0x08] = color.NRGBA{136, 85, 0, alpha}
-	colors[0x09] = color.NRGBA{255, 102, 0, alpha}
-	colors[0x0A] = color.NRGBA{170, 170, 170, alpha}
-	colors[0x0B] = color.NRGBA{255, 153, 136, alpha}
-	colors[0x0C] = color.NRGBA{17, 221, 0, alpha}
-	colors[0x0D] = color.NRGBA{255, 255, 0, alpha}
-	colors[0x0E] = color.NRGBA{68, 255, 153, alpha}
-	colors[0x0F] = color.NRGBA{255, 255, 255, alpha}
+	colors[0x00] = color.RGBA{0, 0, 0, alpha}
+	colors[0x01] = color.RGBA{221, 0, 51, alpha}
+	colors[0x02] = color.RGBA{0, 0, 153, alpha}
+	colors[0x03] = color.RGBA{221, 34, 221, alpha}
+	colors[0x04] = color.RGBA{0, 119, 34, alpha}
+	colors[0x05] = color.RGBA{85, 85, 85, alpha}
+	colors[0x06] = color.RGBA{34, 34, 255, alpha}
+	colors[0x07] = color.RGBA{102, 170, 255, alpha}
+	colors[0x08] = color.RGBA{136, 85, 0, alpha}
+	colors[0x09] = color.RGBA{255, 102, 0, alpha}
+	colors[0x0A] = color.RGBA{170, 170, 170, alpha}
+	colors[0x0B] = color.RGBA{255, 153, 136, alpha}
+	colors[0x0C] = color.RGBA{17, 221, 0, alpha}
+	colors[0x0D] = color.RGBA{255, 255, 0, alpha}
+	colors[0x0E] = color.RGBA{68, 255, 153, alpha}
+	colors[0x0F] = color.RGBA{255, 255, 255, alpha}
 
 	for i := 0; i < 0x10; i++ {
-		colorLoresSquares[i].Fill(colors[i])
 		avgIntensity := float64(int(colors[i].R)+int(colors[i].G)+int(colors[i].B)) / 3
-		avgColor := color.NRGBA{byte(avgIntensity * 0.2), byte(avgIntensity * 0.75), byte(avgIntensity * 0.2), alpha}
-		monochromeLoresSquares[i].Fill(avgColor)
+		monochromeColors[i] = color.RGBA{byte(avgIntensity * 0.2), byte(avgIntensity * 0.75), byte(avgIntensity * 0.2), alpha}
+	}
+}
+
+// New creates a monochrome video renderer for the MMU's memory
+func New(m *mmu.MMU) *Video {
+	return &Video{
+		MMU:        m,
+		Monochrome: true,
+		frame:      image.NewRGBA(image.Rect(0, 0, Width, Height)),
 	}
 }
 
-// Init the video data structures used for rendering
-func Init() {
-	ShowFPS = false
-	Monochrome = true
+// Frame returns the frame drawn by the last call to Render
+func (v *Video) Frame() *image.RGBA {
+	return v.frame
+}
 
-	initTextCharMap()
-	initLoresSquares()
+// fillRect fills a w x h rectangle at x, y in the frame
+func (v *Video) fillRect(x int, y int, w int, h int, c color.RGBA) {
+	for yy := y; yy < y+h; yy++ {
+		p := v.frame.PixOffset(x, yy)
+		for xx := 0; xx < w; xx++ {
+			v.frame.Pix[p+0] = c.R
+			v.frame.Pix[p+1] = c.G
+			v.frame.Pix[p+2] = c.B
+			v.frame.Pix[p+3] = c.A
+			p += 4
+		}
+	}
 }
 
-// drawText draws a single text character at x, y. The characters are either normal, inverted or flashing
-func drawText(screen *ebiten.Image, x int, y int, value uint8) error {
-	// Determine if the character is inverted
+// drawCharacter draws a single text character at screen position xPos, line
+// y. The characters are either normal, inverted or flashing. With the
+// alternate character set, MouseText and inverted lowercase characters replace
+// the flashing ones.
+func (v *Video) drawCharacter(xPos int, y int, value uint8, xScale int) {
+	// Determine if the character is inverted and convert the value to an
+	// index for the charMap
 	inverted := false
+	var index uint8
 
-	if (value & 0xc0) == 0 {
+	switch {
+	case (value & 0xc0) == 0:
 		// Inverted
+		index = value
+		inverted = true
+	case (value&0x80) == 0 && v.MMU.AltCharSet && (value&0xe0) == 0x40:
+		// MouseText
+		index = 0x80 + (value & 0x1f)
+	case (value&0x80) == 0 && v.MMU.AltCharSet:
+		// Inverted lowercase
+		index = value
 		inverted = true
-	} else if (value & 0x80) == 0 {
+	case (value & 0x80) == 0:
 		// Flashing
-		value = value & 0x3f
-		inverted = flashOn
+		index = value & 0x3f
+		inverted = v.flashOn
+	default:
+		// Normal
+		index = value & 0x7f
 	}
 
-	// Convert the value to a index for the charMap
-	if !inverted {
-		value = value & 0x7f
+	if index < 0x20 {
+		index += 0x40
 	}
 
-	if value < 0x20 {
-		value += 0x40
+	foreground := white
+	if v.Monochrome {
+		// Make it look greenish
+		foreground = green
 	}
 
-	op := &ebiten.DrawImageOptions{}
-	op.GeoM.Scale(2, 2)
-	op.GeoM.Translate(2*7*float64(x), 2*8*float64(y))
+	for row := 0; row < 8; row++ {
+		bits := charMap[index][row]
+		for col := 0; col < 7; col++ {
+			set := (bits & (1 << uint(col))) != 0
+			c := black
+			if set != inverted {
+				c = foreground
+			}
+			v.fillRect(xPos+col*xScale, 2*(8*y+row), xScale, 2, c)
+		}
+	}
+}
 
-	r := image.Rect(0, 0, 7, 8)
-	op.SourceRect = &r
+// drawText draws a single 40 column text character at x, y
+func (v *Video) drawText(x int, y int, value uint8) {
+	v.drawCharacter(2*7*x, y, value, 2)
+}
 
-	// The charMap is already inverted. Invert it back if we ourselves aren't inverted.
-	if inverted {
-		op.ColorM.Scale(-1, -1, -1, 1)
-		op.ColorM.Translate(1, 1, 1, 0)
-	}
+// drawText80 draws a single 80 column text character at x, y
+func (v *Video) drawText80(x int, y int, value uint8) {
+	v.drawCharacter(7*x, y, value, 1)
+}
 
-	if Monochrome {
-		// Make it look greenish
-		op.ColorM.Scale(0.20, 0.75, 0.20, 1)
-	}
+// drawLoresSquares draws two colored lores squares at screen position xPos, line y.
+func (v *Video) drawLoresSquares(xPos int, y int, values [2]uint8, xScale int) {
+	// Render top & bottom squares
+	for i := 0; i < 2; i++ {
+		c := colors[values[i]]
+		if v.Monochrome {
+			c = monochromeColors[values[i]]
+		}
 
-	return screen.DrawImage(charMap[value], op)
+		v.fillRect(xPos, 2*8*y+2*i*4, 7*xScale, 2*4, c)
+	}
 }
 
 // drawLores draws two colored lores squares at the equivalent text location x,y.
-func drawLores(screen *ebiten.Image, x int, y int, value uint8) error {
+func (v *Video) drawLores(x int, y int, value uint8) {
 	// Convert the 8 bit value to two 4 bit values
 	var values = [2]uint8{value & 0xf, value >> 4}
 
-	// Render top & bottom squares
-	for i := 0; i < 2; i++ {
-		op := &ebiten.DrawImageOptions{}
-		op.GeoM.Scale(2, 2)
-		op.GeoM.Translate(2*7*float64(x), 2*8*float64(y)+2*float64(i)*4)
-
-		var loresSquare *ebiten.Image
-		if Monochrome {
-			loresSquare = monochromeLoresSquares[values[i]]
-		} else {
-			loresSquare = colorLoresSquares[values[i]]
-		}
+	v.drawLoresSquares(2*7*x, y, values, 2)
+}
+
+// drawLores80 draws two colored double lores squares at the equivalent 80
+// column text location x,y. The colors of the aux memory bytes in the even
+// columns are rotated by one bit.
+func (v *Video) drawLores80(x int, y int, value uint8) {
+	// Convert the 8 bit value to two 4 bit values
+	var values = [2]uint8{value & 0xf, value >> 4}
 
-		if err := screen.DrawImage(loresSquare, op); err != nil {
-			return err
+	if x%2 == 0 {
+		for i := 0; i < 2; i++ {
+			values[i] = ((values[i] << 1) | (values[i] >> 3)) & 0xf
 		}
 	}
 
-	return nil
+	v.drawLoresSquares(7*x, y, values, 1)
 }
 
-// drawTextLoresBlock draws a number of lines of text or lores from start to end
-func drawTextLoresBlock(screen *ebiten.Image, start int, end int, drawer drawTextLoresByte) error {
-	for y := start; y < end; y++ {
-		base := 128*(y%8) + 40*(y/8)
+// textPageValue returns the byte in the text page at column x and line y. With
+// 80 columns, the even columns come from aux memory and the odd columns from
+// main memory.
+func (v *Video) textPageValue(x int, y int, columns int) uint8 {
+	base := 128*(y%8) + 40*(y/8)
 
-		// Flip to the 2nd page if so toggled
-		if mmu.Page2 {
-			base += 0x400
-		}
+	// Flip to the 2nd page if so toggled. If 80STORE is on, PAGE2
+	// switches to aux memory instead.
+	if v.MMU.Page2 && !v.MMU.Store80 {
+		base += 0x400
+	}
 
-		for x := 0; x < 40; x++ {
-			offset := textVideoMemory + base + x
-			value := mmu.ReadPageTable[offset>>8][offset&0xff]
-			if err := drawer(screen, x, y, value); err != nil {
-				return err
-			}
+	if columns == 80 {
+		offset := textVideoMemory + base + x/2
+		if x%2 == 0 {
+			return v.MMU.PhysicalMemory.AuxMemory[offset]
 		}
+		return v.MMU.PhysicalMemory.MainMemory[offset]
 	}
 
-	return nil
+	return v.MMU.PhysicalMemory.MainMemory[textVideoMemory+base+x]
 }
 
-// drawTextBlock draws a number of lines of text from start to end
-func drawTextBlock(screen *ebiten.Image, start int, end int) error {
-	drawTextLoresBlock(screen, start, end, drawText)
-	return nil
+// drawTextLoresBlock draws a number of lines of text or lores from start to
+// end.
+func (v *Video) drawTextLoresBlock(start int, end int, columns int, drawer drawTextLoresByte) {
+	for y := start; y < end; y++ {
+		for x := 0; x < columns; x++ {
+			drawer(x, y, v.textPageValue(x, y, columns))
+		}
+	}
+}
+
+// drawTextBlock draws a number of lines of 40 or 80 column text from start to end
+func (v *Video) drawTextBlock(start int, end int) {
+	if v.MMU.Col80 {
+		v.drawTextLoresBlock(start, end, 80, v.drawText80)
+	} else {
+		v.drawTextLoresBlock(start, end, 40, v.drawText)
+	}
 }
 
-// drawTextBlock draws a number of lores lines from the equivalent text start to end line
-func drawLoresBlock(screen *ebiten.Image, start int, end int) error {
-	drawTextLoresBlock(screen, start, end, drawLores)
-	return nil
+// drawLoresBlock draws a number of lores or double lores lines from the equivalent text start to end line
+func (v *Video) drawLoresBlock(start int, end int) {
+	if v.MMU.Col80 {
+		v.drawTextLoresBlock(start, end, 80, v.drawLores80)
+	} else {
+		v.drawTextLoresBlock(start, end, 40, v.drawLores)
+	}
 }
 
 // drawTextOrLoresScreen draws a text and/or lores screen depending on the VideoState
-func drawTextOrLoresScreen(screen *ebiten.Image) error {
-	topHalfIsLowRes := !mmu.VideoState.TextMode
-	bottomHalfIsLowRes := !mmu.VideoState.TextMode && !mmu.VideoState.Mixed
+func (v *Video) drawTextOrLoresScreen() {
+	topHalfIsLowRes := !v.MMU.VideoState.TextMode
+	bottomHalfIsLowRes := !v.MMU.VideoState.TextMode && !v.MMU.VideoState.Mixed
 
 	if !topHalfIsLowRes {
-		drawTextBlock(screen, 0, 20)
+		v.drawTextBlock(0, 20)
 	} else {
-		drawLoresBlock(screen, 0, 20)
+		v.drawLoresBlock(0, 20)
 	}
 
 	if !bottomHalfIsLowRes {
-		drawTextBlock(screen, 20, 24)
+		v.drawTextBlock(20, 24)
 	} else {
-		drawLoresBlock(screen, 20, 24)
+		v.drawLoresBlock(20, 24)
 	}
-
-	return nil
 }
 
 // drawHiresScreen draws an entire hires screen. If it's in mixed mode, the lower end is drawn in text.
-func drawHiresScreen(screen *ebiten.Image) error {
-	pixels := make([]byte, 560*384*4)
+func (v *Video) drawHiresScreen() {
+	pixels := v.frame.Pix
 	halfPixels := make([]byte, 14)
 
 	// Loop over all hires lines
 	for y := 0; y < 192; y++ {
-		if mmu.VideoState.Mixed && y >= 160 {
+		if v.MMU.VideoState.Mixed && y >= 160 {
 			continue
 		}
 
 		// Woz is a genius
 		yOffset := 0x2000 - (0x3d8)*(y>>6) + 0x80*(y>>3) + 0x400*(y&0x7)
 
-		// Flip to the 2nd page if so toggled
-		if mmu.Page2 {
+		// Flip to the 2nd page if so toggled. If 80STORE is on, PAGE2
+		// switches to aux memory instead.
+		if v.MMU.Page2 && !v.MMU.Store80 {
 			yOffset += 0x2000
 		}
 
@@ -240,12 +310,12 @@ func drawHiresScreen(screen *ebiten.Image) error {
 		// Don't shift half-bits in monochrome mode
 		for x := 0; x < 40; x++ {
 			offset := yOffset + x
-			value := mmu.ReadPageTable[offset>>8][offset&0xff]
+			value := v.MMU.PhysicalMemory.MainMemory[offset]
 
 			phaseShifted := value >> 7
 
 			var hp uint8
-			if Monochrome {
+			if v.Monochrome {
 				hp = 0
 			} else {
 				hp = phaseShifted
@@ -272,9 +342,9 @@ func drawHiresScreen(screen *ebiten.Image) error {
 
 				// Draw two lines at a time
 				for rowDouble := 0; rowDouble < 2; rowDouble++ {
-					p := ((y*2+rowDouble)*560 + x*2*7 + int(hp)) * 4
+					p := ((y*2+rowDouble)*Width + x*2*7 + int(hp)) * 4
 
-					if Monochrome {
+					if v.Monochrome {
 						b := float64(halfPixels[hp])
 						pixels[p+0] = byte(0xff * float64(0.20) * b)
 						pixels[p+1] = byte(0xff * float64(0.75) * b)
@@ -291,39 +361,135 @@ func drawHiresScreen(screen *ebiten.Image) error {
 		}
 	}
 
-	// The hires pixels are read, flush them to the screen
-	screen.ReplacePixels(pixels)
-
 	// Draw text bit at the bottom
-	if mmu.VideoState.Mixed {
-		drawTextBlock(screen, 20, 24)
+	if v.MMU.VideoState.Mixed {
+		v.drawTextBlock(20, 24)
 	}
-
-	return nil
 }
 
-// DrawScreen draws a text, lores, hires or combination screen
-func DrawScreen(screen *ebiten.Image) error {
-	flashCounter--
-	if flashCounter < 0 {
-		flashCounter = flashFrames
-		flashOn = !flashOn
+// drawDoubleHiresScreen draws an entire double hires screen. Each line has 560
+// pixels made up of 7 bits from alternating aux and main memory bytes. In
+// color mode, every 4 consecutive pixels determine a color in the 16 color
+// palette. If it's in mixed mode, the lower end is drawn in text.
+func (v *Video) drawDoubleHiresScreen() {
+	pixels := v.frame.Pix
+
+	// Loop over all hires lines
+	for y := 0; y < 192; y++ {
+		if v.MMU.VideoState.Mixed && y >= 160 {
+			continue
+		}
+
+		yOffset := 0x2000 - (0x3d8)*(y>>6) + 0x80*(y>>3) + 0x400*(y&0x7)
+
+		// Flip to the 2nd page if so toggled. If 80STORE is on, PAGE2
+		// switches to aux memory instead.
+		if v.MMU.Page2 && !v.MMU.Store80 {
+			yOffset += 0x2000
+		}
+
+		// The first pixel of a line is bit 1 of a 4-bit color
+		var color uint8      // Current 4-bit color
+		colorPos := uint8(1) // Current pixel in the 4-bit color
+
+		for x := 0; x < 80; x++ {
+			offset := yOffset + x/2
+
+			var value uint8
+			if x%2 == 0 {
+				value = v.MMU.PhysicalMemory.AuxMemory[offset]
+			} else {
+				value = v.MMU.PhysicalMemory.MainMemory[offset]
+			}
+
+			for bit := 0; bit < 7; bit++ {
+				pixel := value & 1
+				value >>= 1
+
+				// Update the color bit in colorPos with the pixel value
+				color &= ((1 << colorPos) ^ 0xf)
+				color |= pixel << colorPos
+				colorPos = (colorPos + 1) & 3
+
+				// Draw two lines at a time
+				for rowDouble := 0; rowDouble < 2; rowDouble++ {
+					p := ((y*2+rowDouble)*Width + x*7 + bit) * 4
+
+					if v.Monochrome {
+						b := float64(pixel)
+						pixels[p+0] = byte(0xff * float64(0.20) * b)
+						pixels[p+1] = byte(0xff * float64(0.75) * b)
+						pixels[p+2] = byte(0xff * float64(0.20) * b)
+						pixels[p+3] = 0xff
+					} else {
+						pixels[p+0] = colors[color].R
+						pixels[p+1] = colors[color].G
+						pixels[p+2] = colors[color].B
+						pixels[p+3] = 0xff
+					}
+				}
+			}
+		}
 	}
 
-	if ebiten.IsRunningSlowly() {
-		return nil
+	// Draw text bit at the bottom
+	if v.MMU.VideoState.Mixed {
+		v.drawTextBlock(20, 24)
+	}
+}
+
+// Render draws a text, lores, hires or combination screen into the frame. It
+// should be called once per 1/60s frame to keep flashing characters flashing.
+func (v *Video) Render() *image.RGBA {
+	v.flashCounter--
+	if v.flashCounter < 0 {
+		v.flashCounter = flashFrames
+		v.flashOn = !v.flashOn
 	}
 
-	if !mmu.VideoState.HiresMode {
-		drawTextOrLoresScreen(screen)
+	if !v.MMU.VideoState.HiresMode {
+		v.drawTextOrLoresScreen()
+	} else if v.MMU.Col80 && !v.MMU.Annunciators[3] {
+		v.drawDoubleHiresScreen()
 	} else {
-		drawHiresScreen(screen)
+		v.drawHiresScreen()
 	}
 
-	if ShowFPS {
-		msg := fmt.Sprintf(`FPS: %0.2f`, ebiten.CurrentFPS())
-		ebitenutil.DebugPrint(screen, msg)
+	return v.frame
+}
+
+// Text returns the text screen as 24 lines of 40 or 80 characters. Inverse
+// and flashing characters are returned as their normal equivalents.
+func (v *Video) Text() []string {
+	columns := 40
+	if v.MMU.Col80 {
+		columns = 80
+	}
+
+	lines := make([]string, 24)
+	for y := 0; y < 24; y++ {
+		line := make([]byte, columns)
+		for x := 0; x < columns; x++ {
+			value := v.textPageValue(x, y, columns)
+
+			// Normal characters are $a0-$ff, the lower values are inverse,
+			// flashing, MouseText or control character glyphs of the same
+			// character.
+			c := value & 0x7f
+			if (value & 0x80) == 0 {
+				c = value & 0x3f
+				if v.MMU.AltCharSet && value >= 0x60 {
+					c = value
+				}
+			}
+			if c < 0x20 {
+				c += 0x40
+			}
+
+			line[x] = c
+		}
+		lines[y] = string(line)
 	}
 
-	return nil
+	return lines
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVideoText tests rendering the text screen without a display
+func TestVideoText(t *testing.T) {
+	t.Parallel()
+
+	m := machine.New(cpu.Model6502)
+
+	// Clear the screen to spaces and write a normal, inverse and flashing A on
+	// line 0 and a normal B on line 8
+	for i := 0x400; i < 0x800; i++ {
+		m.MMU.PhysicalMemory.MainMemory[i] = 0xa0
+	}
+	m.MMU.PhysicalMemory.MainMemory[0x400] = 0xc1
+	m.MMU.PhysicalMemory.MainMemory[0x401] = 0x01
+	m.MMU.PhysicalMemory.MainMemory[0x402] = 0x41
+	m.MMU.PhysicalMemory.MainMemory[0x428] = 0xc2
+
+	text := m.Video.Text()
+	assert.Equal(t, 24, len(text))
+	assert.Equal(t, "AAA"+strings.Repeat(" ", 37), text[0])
+	assert.Equal(t, "B"+strings.Repeat(" ", 39), text[8])
+
+	// The top left pixel of a normal A is off and of an inverse A is on
+	frame := m.Video.Render()
+	assert.Equal(t, uint8(0), frame.Pix[frame.PixOffset(0, 0)+1])
+	assert.NotEqual(t, uint8(0), frame.Pix[frame.PixOffset(14, 0)+1])
+
+	// In 80 columns, the even columns come from aux memory
+	m.MMU.WriteMemory(0xc00d, 0x00) // SET80VID
+	m.MMU.PhysicalMemory.AuxMemory[0x400] = 0xc3
+	text = m.Video.Text()
+	assert.Equal(t, 80, len(text[0]))
+	assert.Equal(t, "CA", text[0][:2])
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freewilll/apple2-go/cpu"
+	"github.com/freewilll/apple2-go/machine"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestWOZImage writes a WOZ2 image to dir. Quarter track i has track
+// tmap[i], all other quarter tracks are empty. Each track has 20 sync bytes
+// followed by nibbles.
+func writeTestWOZImage(t *testing.T, dir string, name string, tmap []uint8, tracks ...[]uint8) string {
+	var trackData [][]byte
+	var bitCounts []int
+	for _, nibbles := range tracks {
+		var bits []uint8
+		addNibble := func(n uint8, length int) {
+			for i := 0; i < length; i++ {
+				bits = append(bits, (n>>7)&1)
+				n <<= 1
+			}
+		}
+		for i := 0; i < 20; i++ {
+			addNibble(0xff, 10)
+		}
+		for _, n := range nibbles {
+			addNibble(n, 8)
+		}
+
+		data := make([]byte, 512)
+		for i, b := range bits {
+			data[i/8] |= b << uint(7-i%8)
+		}
+		trackData = append(trackData, data)
+		bitCounts = append(bitCounts, len(bits))
+	}
+
+	woz := []byte("WOZ2\xff\n\r\n\x00\x00\x00\x00")
+	chunk := func(id string, data []byte) {
+		woz = append(woz, id...)
+		woz = append(woz, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(woz[len(woz)-4:], uint32(len(data)))
+		woz = append(woz, data...)
+	}
+
+	info := make([]byte, 60)
+	info[0], info[1], info[37], info[39] = 2, 1, 1, 32
+	chunk("INFO", info)
+
+	chunk("TMAP", append(tmap, bytes.Repeat([]byte{0xff}, 160-len(tmap))...))
+
+	// The track entries are followed by the tracks, one block each starting
+	// at block 3
+	trks := make([]byte, 1280)
+	for i, data := range trackData {
+		binary.LittleEndian.PutUint16(trks[i*8:], uint16(3+i))
+		binary.LittleEndian.PutUint16(trks[i*8+2:], 1)
+		binary.LittleEndian.PutUint32(trks[i*8+4:], uint32(bitCounts[i]))
+		trks = append(trks, data...)
+	}
+	chunk("TRKS", trks)
+
+	binary.LittleEndian.PutUint32(woz[8:], crc32.ChecksumIEEE(woz[12:]))
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, woz, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestWOZ tests reading and writing the bit stream of a WOZ image and that
+// writes are flushed with a valid CRC
+func TestWOZ(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	address := []uint8{0xd5, 0xaa, 0x96, 0xab, 0xcd, 0xef, 0xde, 0xaa, 0xeb}
+	path := writeTestWOZImage(t, dir, "test.woz", []uint8{0, 0}, address)
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Equal(t, "WOZ 2", m.Disk.ImageFormat(1))
+
+	assert.Contains(t, string(readTrackBytes(m, 60)), string(address))
+
+	// Write a data field with the timing of RWTS, 32 cycles per nibble and
+	// 40 cycles for sync bytes
+	data := []uint8{0xd5, 0xaa, 0xad, 0x96, 0x97, 0xde, 0xaa, 0xeb}
+	m.MMU.ReadMemory(0xc0ef) // Write mode
+	for i := 0; i < 5; i++ {
+		m.MMU.WriteMemory(0xc0ed, 0xff)
+		m.System.FrameCycles += 40
+	}
+	for _, n := range data {
+		m.MMU.WriteMemory(0xc0ed, n)
+		m.System.FrameCycles += 32
+	}
+	m.MMU.ReadMemory(0xc0ee) // Read mode
+
+	assert.Contains(t, string(readTrackBytes(m, 60)), string(data))
+
+	// The write is flushed on eject and the image can be read again
+	assert.Nil(t, m.Disk.EjectDiskImage(1))
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	nibbles := string(readTrackBytes(m, 60))
+	assert.Contains(t, nibbles, string(data))
+	assert.Contains(t, nibbles, string(address[:3]))
+
+	// An image with a bad CRC isn't loaded
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	bad := filepath.Join(dir, "bad.woz")
+	if err := ioutil.WriteFile(bad, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, m.Disk.InsertDiskImage(2, bad))
+}
+
+// TestQuarterTracks tests that two adjacent stepper motor magnets move the head
+// onto a quarter track and that WOZ images have data on quarter tracks
+func TestQuarterTracks(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "apple2-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := machine.New(cpu.Model6502)
+
+	track0 := []uint8{0xd5, 0xaa, 0x96, 0xff, 0xfe}
+	track1 := []uint8{0xd5, 0xaa, 0x96, 0xfe, 0xff}
+	path := writeTestWOZImage(t, dir, "test.woz", []uint8{0, 1, 0xff, 0xff, 1}, track0, track1)
+	assert.Nil(t, m.Disk.InsertDiskImage(1, path))
+	assert.Contains(t, string(readTrackBytes(m, 40)), string(track0))
+
+	m.MMU.ReadMemory(0xc0e1) // Phase 0 on
+	assert.Equal(t, 0, m.Disk.QuarterTrack(1))
+	m.MMU.ReadMemory(0xc0e3) // Phase 1 on
+	assert.Equal(t, 1, m.Disk.QuarterTrack(1))
+	assert.Contains(t, string(readTrackBytes(m, 40)), string(track1))
+
+	m.MMU.ReadMemory(0xc0e0) // Phase 0 off
+	assert.Equal(t, 2, m.Disk.QuarterTrack(1))
+	m.MMU.ReadMemory(0xc0e5) // Phase 2 on
+	m.MMU.ReadMemory(0xc0e2) // Phase 1 off
+	assert.Equal(t, 4, m.Disk.QuarterTrack(1))
+	assert.Contains(t, string(readTrackBytes(m, 40)), string(track1))
+
+	// Opposite magnets cancel each other out
+	m.MMU.ReadMemory(0xc0e1) // Phase 0 on
+	assert.Equal(t, 4, m.Disk.QuarterTrack(1))
+	m.MMU.ReadMemory(0xc0e4) // Phase 2 off
+	assert.Equal(t, 4, m.Disk.QuarterTrack(1))
+
+	// Sector images can be read a quarter track away from a track
+	m.MMU.ReadMemory(0xc0e0) // Phase 0 off
+	m.MMU.ReadMemory(0xc0e7) // Phase 3 on
+	m.MMU.ReadMemory(0xc0e5) // Phase 2 on
+	assert.Equal(t, 5, m.Disk.QuarterTrack(1))
+	assert.Nil(t, m.Disk.InsertDiskImage(1, writeTestDiskImage(t, dir, "test.dsk", 0)))
+	assert.Len(t, readTrackBytes(m, 40), 40)
+	m.MMU.ReadMemory(0xc0e4) // Phase 2 off
+	assert.Equal(t, 6, m.Disk.QuarterTrack(1))
+	assert.Empty(t, readTrackBytes(m, 40))
+}